@@ -6,6 +6,8 @@ import (
 	"github.com/go-redis/redis/v8"
 	"github.com/piupuer/go-helper/ms"
 	"github.com/piupuer/go-helper/pkg/delay"
+	"github.com/piupuer/go-helper/pkg/middleware"
+	"github.com/piupuer/go-helper/pkg/mq"
 	"github.com/piupuer/go-helper/pkg/oss"
 	"github.com/piupuer/go-helper/pkg/query"
 	"github.com/piupuer/go-helper/pkg/req"
@@ -17,6 +19,9 @@ type Options struct {
 	binlogOps                  []func(options *query.RedisOptions)
 	dbOps                      []func(options *query.MysqlOptions)
 	exportOps                  []func(options *delay.ExportOptions)
+	delayQueue                 *delay.Queue
+	rabbit                     *mq.Rabbit
+	rateLimiter                *middleware.RateLimiter
 	redis                      redis.UniversalClient
 	cachePrefix                string
 	operationAllowedToDelete   bool
@@ -55,6 +60,30 @@ func WithDbOps(ops ...func(options *query.MysqlOptions)) func(*Options) {
 	}
 }
 
+func WithDelayQueue(qu *delay.Queue) func(*Options) {
+	return func(options *Options) {
+		if qu != nil {
+			getOptionsOrSetDefault(options).delayQueue = qu
+		}
+	}
+}
+
+func WithRabbit(rb *mq.Rabbit) func(*Options) {
+	return func(options *Options) {
+		if rb != nil {
+			getOptionsOrSetDefault(options).rabbit = rb
+		}
+	}
+}
+
+func WithRateLimiter(l *middleware.RateLimiter) func(*Options) {
+	return func(options *Options) {
+		if l != nil {
+			getOptionsOrSetDefault(options).rateLimiter = l
+		}
+	}
+}
+
 func WithExportOps(ops ...func(options *delay.ExportOptions)) func(*Options) {
 	return func(options *Options) {
 		getOptionsOrSetDefault(options).exportOps = append(getOptionsOrSetDefault(options).exportOps, ops...)
@@ -149,7 +149,7 @@ func UpdateApiById(options ...func(*Options)) gin.HandlerFunc {
 // @Tags *Api
 // @Description UpdateApiByRoleId
 // @Param id path uint true "id"
-// @Param params body req.UpdateMenuIncrementalIds true "params"
+// @Param params body req.UpdateApiIncrementalIds true "params"
 // @Router /api/role/update/{id} [PATCH]
 func UpdateApiByRoleId(options ...func(*Options)) gin.HandlerFunc {
 	ops := ParseOptions(options...)
@@ -160,7 +160,7 @@ func UpdateApiByRoleId(options ...func(*Options)) gin.HandlerFunc {
 		ctx := tracing.RealCtx(c)
 		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "UpdateApiByRoleId"))
 		defer span.End()
-		var r req.UpdateMenuIncrementalIds
+		var r req.UpdateApiIncrementalIds
 		req.ShouldBind(c, &r)
 		u := ops.getCurrentUser(c)
 		if u.RoleId == u.PathRoleId {
@@ -173,7 +173,10 @@ func UpdateApiByRoleId(options ...func(*Options)) gin.HandlerFunc {
 
 		ops.addCtx(c)
 		q := query.NewMySql(ops.dbOps...)
-		err := q.UpdateApiByRoleKeyword(u.PathRoleKeyword, r)
+		err := q.UpdateApiByRoleKeywordAndDomain(u.PathRoleKeyword, r.Domain, req.UpdateMenuIncrementalIds{
+			Create: r.Create,
+			Delete: r.Delete,
+		})
 		resp.CheckErr(err)
 		CacheFlushMenuTree(c, *ops)
 		resp.Success()
@@ -57,3 +57,202 @@ func BatchDeleteDelayExportByIds(options ...func(*Options)) gin.HandlerFunc {
 		resp.Success()
 	}
 }
+
+// FindDelayCron
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Delay
+// @Description FindDelayCron
+// @Param params query req.DelayCron true "params"
+// @Router /delay/cron/list [GET]
+func FindDelayCron(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	if ops.delayQueue == nil {
+		panic("delayQueue is empty")
+	}
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "FindDelayCron"))
+		defer span.End()
+		var r req.DelayCron
+		req.ShouldBind(c, &r)
+		list, err := ops.delayQueue.FindCron(&r)
+		resp.CheckErr(err)
+		resp.SuccessWithPageData(list, &[]resp.DelayCron{}, r.Page)
+	}
+}
+
+// GetDelayCron
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Delay
+// @Description GetDelayCron
+// @Param uid path string true "uid"
+// @Router /delay/cron/detail/{uid} [GET]
+func GetDelayCron(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	if ops.delayQueue == nil {
+		panic("delayQueue is empty")
+	}
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "GetDelayCron"))
+		defer span.End()
+		rp, err := ops.delayQueue.GetCron(c.Param("uid"))
+		resp.CheckErr(err)
+		resp.SuccessWithData(rp)
+	}
+}
+
+// PauseDelayCron
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Delay
+// @Description PauseDelayCron
+// @Param uid path string true "uid"
+// @Router /delay/cron/pause/{uid} [PATCH]
+func PauseDelayCron(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	if ops.delayQueue == nil {
+		panic("delayQueue is empty")
+	}
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "PauseDelayCron"))
+		defer span.End()
+		err := ops.delayQueue.PauseCron(c.Param("uid"))
+		resp.CheckErr(err)
+		resp.Success()
+	}
+}
+
+// ResumeDelayCron
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Delay
+// @Description ResumeDelayCron
+// @Param uid path string true "uid"
+// @Router /delay/cron/resume/{uid} [PATCH]
+func ResumeDelayCron(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	if ops.delayQueue == nil {
+		panic("delayQueue is empty")
+	}
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "ResumeDelayCron"))
+		defer span.End()
+		err := ops.delayQueue.ResumeCron(c.Param("uid"))
+		resp.CheckErr(err)
+		resp.Success()
+	}
+}
+
+// UpdateDelayCron
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Delay
+// @Description UpdateDelayCron
+// @Param uid path string true "uid"
+// @Param params body req.UpdateDelayCron true "params"
+// @Router /delay/cron/update/{uid} [PATCH]
+func UpdateDelayCron(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	if ops.delayQueue == nil {
+		panic("delayQueue is empty")
+	}
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "UpdateDelayCron"))
+		defer span.End()
+		var r req.UpdateDelayCron
+		req.ShouldBind(c, &r)
+		err := ops.delayQueue.UpdateCron(c.Param("uid"), r)
+		resp.CheckErr(err)
+		resp.Success()
+	}
+}
+
+// FindDelayCallbackAttempt
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Delay
+// @Description FindDelayCallbackAttempt
+// @Param uid path string true "uid"
+// @Router /delay/callback/attempt/{uid} [GET]
+func FindDelayCallbackAttempt(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	if ops.delayQueue == nil {
+		panic("delayQueue is empty")
+	}
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "FindDelayCallbackAttempt"))
+		defer span.End()
+		list, err := ops.delayQueue.FindCallbackAttempt(c.Param("uid"))
+		resp.CheckErr(err)
+		resp.SuccessWithData(list)
+	}
+}
+
+// ReplayDelayCallback
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Delay
+// @Description ReplayDelayCallback
+// @Param uid path string true "uid"
+// @Router /delay/callback/replay/{uid} [POST]
+func ReplayDelayCallback(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	if ops.delayQueue == nil {
+		panic("delayQueue is empty")
+	}
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "ReplayDelayCallback"))
+		defer span.End()
+		err := ops.delayQueue.ReplayCallback(ctx, c.Param("uid"))
+		resp.CheckErr(err)
+		resp.Success()
+	}
+}
+
+// FindDelayHistory
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Delay
+// @Description FindDelayHistory
+// @Param params query req.DelayHistory true "params"
+// @Router /delay/history/list [GET]
+func FindDelayHistory(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	if ops.delayQueue == nil {
+		panic("delayQueue is empty")
+	}
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "FindDelayHistory"))
+		defer span.End()
+		var r req.DelayHistory
+		req.ShouldBind(c, &r)
+		list, err := ops.delayQueue.FindHistory(&r)
+		resp.CheckErr(err)
+		resp.SuccessWithPageData(list, &[]resp.DelayHistory{}, r.Page)
+	}
+}
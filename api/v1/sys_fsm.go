@@ -340,3 +340,218 @@ func BatchDeleteFsmByIds(options ...func(*Options)) gin.HandlerFunc {
 		resp.Success()
 	}
 }
+
+// FsmTransferLog
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Fsm
+// @Description FsmTransferLog
+// @Param params body req.FsmTransferLog true "params"
+// @Router /fsm/transfer [PATCH]
+func FsmTransferLog(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	if ops.getCurrentUser == nil {
+		panic("getCurrentUser is empty")
+	}
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "FsmTransferLog"))
+		defer span.End()
+		var r req.FsmTransferLog
+		req.ShouldBind(c, &r)
+		u := ops.getCurrentUser(c)
+		r.ApprovalRoleId = u.RoleId
+		r.ApprovalUserId = u.Id
+		ops.addCtx(c)
+		q := query.NewMySql(ops.dbOps...)
+		err := q.FsmTransferLog(r)
+		resp.CheckErr(err)
+		resp.Success()
+	}
+}
+
+// FindFsmDelegation
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Fsm
+// @Description FindFsmDelegation
+// @Param params query req.FsmDelegation true "params"
+// @Router /fsm/delegation/list [GET]
+func FindFsmDelegation(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "FindFsmDelegation"))
+		defer span.End()
+		var r req.FsmDelegation
+		req.ShouldBind(c, &r)
+		ops.addCtx(c)
+		q := query.NewMySql(ops.dbOps...)
+		list, err := q.FindFsmDelegation(&r)
+		resp.CheckErr(err)
+		resp.SuccessWithPageData(list, &[]resp.FsmDelegation{}, r.Page)
+	}
+}
+
+// CreateFsmDelegation
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Fsm
+// @Description CreateFsmDelegation
+// @Param params body req.FsmCreateDelegation true "params"
+// @Router /fsm/delegation/create [POST]
+func CreateFsmDelegation(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	if ops.getCurrentUser == nil {
+		panic("getCurrentUser is empty")
+	}
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "CreateFsmDelegation"))
+		defer span.End()
+		var r req.FsmCreateDelegation
+		req.ShouldBind(c, &r)
+		req.Validate(c, r, r.FieldTrans())
+		// only approvals of current user can be delegated
+		r.UserId = ops.getCurrentUser(c).Id
+		ops.addCtx(c)
+		q := query.NewMySql(ops.dbOps...)
+		err := q.CreateFsmDelegation(r)
+		resp.CheckErr(err)
+		resp.Success()
+	}
+}
+
+// BatchDeleteFsmDelegationByIds
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Fsm
+// @Description BatchDeleteFsmDelegationByIds
+// @Param ids body req.Ids true "ids"
+// @Router /fsm/delegation/delete/batch [DELETE]
+func BatchDeleteFsmDelegationByIds(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	if ops.getCurrentUser == nil {
+		panic("getCurrentUser is empty")
+	}
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "BatchDeleteFsmDelegationByIds"))
+		defer span.End()
+		var r req.Ids
+		req.ShouldBind(c, &r)
+		ops.addCtx(c)
+		q := query.NewMySql(ops.dbOps...)
+		err := q.DeleteFsmDelegationByIds(ops.getCurrentUser(c).Id, r.Uints())
+		resp.CheckErr(err)
+		resp.Success()
+	}
+}
+
+// FindFsmVersion
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Fsm
+// @Description FindFsmVersion
+// @Param params query req.FsmMachineVersion true "params"
+// @Router /fsm/version/list [GET]
+func FindFsmVersion(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "FindFsmVersion"))
+		defer span.End()
+		var r req.FsmMachineVersion
+		req.ShouldBind(c, &r)
+		ops.addCtx(c)
+		q := query.NewMySql(ops.dbOps...)
+		list, err := q.FindFsmVersion(&r)
+		resp.CheckErr(err)
+		resp.SuccessWithPageData(list, &[]resp.FsmMachineVersion{}, r.Page)
+	}
+}
+
+// DiffFsmVersion
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Fsm
+// @Description DiffFsmVersion
+// @Param params query req.FsmDiffMachineVersion true "params"
+// @Router /fsm/version/diff [GET]
+func DiffFsmVersion(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "DiffFsmVersion"))
+		defer span.End()
+		var r req.FsmDiffMachineVersion
+		req.ShouldBind(c, &r)
+		ops.addCtx(c)
+		q := query.NewMySql(ops.dbOps...)
+		item, err := q.DiffFsmVersion(r)
+		resp.CheckErr(err)
+		resp.SuccessWithData(item)
+	}
+}
+
+// FsmMigrateLog
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Fsm
+// @Description FsmMigrateLog
+// @Param params body req.FsmMigrateLog true "params"
+// @Router /fsm/log/migrate [PATCH]
+func FsmMigrateLog(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "FsmMigrateLog"))
+		defer span.End()
+		var r req.FsmMigrateLog
+		req.ShouldBind(c, &r)
+		ops.addCtx(c)
+		q := query.NewMySql(ops.dbOps...)
+		item, err := q.FsmMigrateLog(r)
+		resp.CheckErr(err)
+		resp.SuccessWithData(item)
+	}
+}
+
+// GetFsmDiagram
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Fsm
+// @Description GetFsmDiagram
+// @Param params query req.FsmDiagram true "params"
+// @Router /fsm/diagram [GET]
+func GetFsmDiagram(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "GetFsmDiagram"))
+		defer span.End()
+		var r req.FsmDiagram
+		req.ShouldBind(c, &r)
+		ops.addCtx(c)
+		q := query.NewMySql(ops.dbOps...)
+		item, err := q.GetFsmDiagram(r)
+		resp.CheckErr(err)
+		resp.SuccessWithData(item)
+	}
+}
@@ -0,0 +1,110 @@
+package v1
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/golang-module/carbon/v2"
+	"github.com/piupuer/go-helper/pkg/mq"
+	"github.com/piupuer/go-helper/pkg/req"
+	"github.com/piupuer/go-helper/pkg/resp"
+	"github.com/piupuer/go-helper/pkg/tracing"
+	"strings"
+)
+
+// FindMqDeadLetter
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Mq
+// @Description FindMqDeadLetter
+// @Param params query req.MqDeadLetter true "params"
+// @Router /mq/deadLetter/list [GET]
+func FindMqDeadLetter(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	if ops.rabbit == nil {
+		panic("rabbit is empty")
+	}
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "FindMqDeadLetter"))
+		defer span.End()
+		var r req.MqDeadLetter
+		req.ShouldBind(c, &r)
+		req.Validate(c, r, r.FieldTrans())
+		list, err := deadLetterQueue(ops.rabbit, r).PeekDeadLetter(deadLetterOptions(r)...)
+		resp.CheckErr(err)
+		rp := make([]resp.MqDeadLetter, 0)
+		for _, item := range list {
+			rp = append(rp, resp.MqDeadLetter{
+				Body:        string(item.Body),
+				ContentType: item.ContentType,
+				Headers:     item.Headers,
+				Exchange:    item.Exchange,
+				RoutingKey:  item.RoutingKey,
+				Queue:       item.Queue,
+				Reason:      item.Reason,
+				Count:       item.Count,
+				Time: carbon.DateTime{
+					Carbon: carbon.Time2Carbon(item.Time),
+				},
+			})
+		}
+		resp.SuccessWithData(rp)
+	}
+}
+
+// RepublishMqDeadLetter
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Mq
+// @Description RepublishMqDeadLetter
+// @Param params body req.MqDeadLetter true "params"
+// @Router /mq/deadLetter/republish [POST]
+func RepublishMqDeadLetter(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	if ops.rabbit == nil {
+		panic("rabbit is empty")
+	}
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "RepublishMqDeadLetter"))
+		defer span.End()
+		var r req.MqDeadLetter
+		req.ShouldBind(c, &r)
+		req.Validate(c, r, r.FieldTrans())
+		count, err := deadLetterQueue(ops.rabbit, r).RepublishDeadLetter(deadLetterOptions(r)...)
+		resp.CheckErr(err)
+		resp.SuccessWithData(count)
+	}
+}
+
+// dead letter queue is declared already
+func deadLetterQueue(rb *mq.Rabbit, r req.MqDeadLetter) *mq.Queue {
+	return rb.
+		Exchange(
+			mq.WithExchangeName(r.Exchange),
+			mq.WithExchangeDeclare(false),
+		).
+		Queue(
+			mq.WithQueueName(r.Queue),
+			mq.WithQueueDeclare(false),
+			mq.WithQueueBind(false),
+		)
+}
+
+func deadLetterOptions(r req.MqDeadLetter) []func(*mq.DeadLetterOptions) {
+	options := []func(*mq.DeadLetterOptions){
+		mq.WithDeadLetterSize(r.Size),
+		mq.WithDeadLetterScan(r.Scan),
+		mq.WithDeadLetterOrigin(r.Origin),
+	}
+	for _, item := range r.Headers {
+		arr := strings.SplitN(item, ":", 2)
+		if len(arr) == 2 {
+			options = append(options, mq.WithDeadLetterHeader(arr[0], arr[1]))
+		}
+	}
+	return options
+}
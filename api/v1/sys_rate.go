@@ -0,0 +1,112 @@
+package v1
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/piupuer/go-helper/pkg/req"
+	"github.com/piupuer/go-helper/pkg/resp"
+	"github.com/piupuer/go-helper/pkg/tracing"
+)
+
+// FindRateRule
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Rate
+// @Description FindRateRule
+// @Param params query req.RateRule true "params"
+// @Router /rate/rule/list [GET]
+func FindRateRule(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	if ops.rateLimiter == nil {
+		panic("rateLimiter is empty")
+	}
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "FindRateRule"))
+		defer span.End()
+		var r req.RateRule
+		req.ShouldBind(c, &r)
+		resp.SuccessWithData(ops.rateLimiter.FindRule(r))
+	}
+}
+
+// CreateRateRule
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Rate
+// @Description CreateRateRule
+// @Param params body req.CreateRateRule true "params"
+// @Router /rate/rule/create [POST]
+func CreateRateRule(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	if ops.rateLimiter == nil {
+		panic("rateLimiter is empty")
+	}
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "CreateRateRule"))
+		defer span.End()
+		var r req.CreateRateRule
+		req.ShouldBind(c, &r)
+		req.Validate(c, r, r.FieldTrans())
+		err := ops.rateLimiter.CreateRule(r)
+		resp.CheckErr(err)
+		resp.Success()
+	}
+}
+
+// UpdateRateRuleById
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Rate
+// @Description UpdateRateRuleById
+// @Param id path string true "id"
+// @Param params body req.UpdateRateRule true "params"
+// @Router /rate/rule/update/{id} [PATCH]
+func UpdateRateRuleById(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	if ops.rateLimiter == nil {
+		panic("rateLimiter is empty")
+	}
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "UpdateRateRuleById"))
+		defer span.End()
+		var r req.UpdateRateRule
+		req.ShouldBind(c, &r)
+		err := ops.rateLimiter.UpdateRuleById(c.Param("id"), r)
+		resp.CheckErr(err)
+		resp.Success()
+	}
+}
+
+// BatchDeleteRateRuleByIds
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Rate
+// @Description BatchDeleteRateRuleByIds
+// @Param ids body req.Ids true "ids"
+// @Router /rate/rule/delete/batch [DELETE]
+func BatchDeleteRateRuleByIds(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	if ops.rateLimiter == nil {
+		panic("rateLimiter is empty")
+	}
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "BatchDeleteRateRuleByIds"))
+		defer span.End()
+		var r req.Ids
+		req.ShouldBind(c, &r)
+		err := ops.rateLimiter.DeleteRuleByIds(r.Strs())
+		resp.CheckErr(err)
+		resp.Success()
+	}
+}
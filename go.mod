@@ -34,7 +34,6 @@ require (
 	github.com/streadway/amqp v1.0.0
 	github.com/thedevsaddam/gojsonq/v2 v2.5.2
 	github.com/thoas/go-funk v0.9.1
-	github.com/ulule/limiter/v3 v3.9.0
 	go.opentelemetry.io/otel v1.6.3
 	go.opentelemetry.io/otel/trace v1.6.3
 	go.uber.org/zap v1.19.1
@@ -1,16 +1,34 @@
 package ms
 
+import "github.com/piupuer/go-helper/pkg/constant"
+
 type User struct {
 	M
-	Username        string `json:"username"`
-	Mobile          string `json:"mobile"`
-	Nickname        string `json:"nickname"`
-	RoleId          uint   `json:"roleId"`
-	RoleName        string `json:"roleName"`
-	RoleSort        uint   `json:"roleSort"`
-	RoleKeyword     string `json:"roleKeyword"`
-	PathRoleId      uint   `json:"pathRoleId"`
-	PathRoleKeyword string `json:"pathRoleKeyword"`
+	Username        string   `json:"username"`
+	Mobile          string   `json:"mobile"`
+	Nickname        string   `json:"nickname"`
+	RoleId          uint     `json:"roleId"`
+	RoleName        string   `json:"roleName"`
+	RoleSort        uint     `json:"roleSort"`
+	RoleKeyword     string   `json:"roleKeyword"`
+	RoleKeywords    []string `json:"roleKeywords"` // other roles of multi-role user
+	PathRoleId      uint     `json:"pathRoleId"`
+	PathRoleKeyword string   `json:"pathRoleKeyword"`
+}
+
+// Roles all role keywords of user
+func (u User) Roles() []string {
+	roles := make([]string, 0, len(u.RoleKeywords)+1)
+	if u.RoleKeyword != "" {
+		roles = append(roles, u.RoleKeyword)
+	}
+	for _, item := range u.RoleKeywords {
+		if item == "" || item == u.RoleKeyword {
+			continue
+		}
+		roles = append(roles, item)
+	}
+	return roles
 }
 
 type Role struct {
@@ -23,10 +41,21 @@ type SignUser struct {
 	M
 	AppId     string      `json:"appId"`
 	AppSecret string      `json:"appSecret"`
+	SignType  string      `json:"signType"`  // constant.MiddlewareSignTypeHmac(default)/MiddlewareSignTypeEd25519/MiddlewareSignTypeRsa
+	PublicKey string      `json:"publicKey"` // hex public key of ed25519, pem public key of rsa
 	Scopes    []SignScope `json:"scopes"`
 	Status    uint        `json:"status"`
 }
 
+// SignKey key to verify signature, empty if sign user is invalid
+func (u SignUser) SignKey() string {
+	switch u.SignType {
+	case constant.MiddlewareSignTypeEd25519, constant.MiddlewareSignTypeRsa:
+		return u.PublicKey
+	}
+	return u.AppSecret
+}
+
 type SignScope struct {
 	Method string `json:"method"`
 	Path   string `json:"path"`
@@ -15,6 +15,14 @@ type SysCasbin struct {
 // role and casbin
 type SysRoleCasbin struct {
 	Keyword string `json:"keyword"` // role keyword
+	Domain  string `json:"domain"`  // tenant, only used by model with domain
 	Method  string `json:"method"`  // api method
 	Path    string `json:"path"`    // api path
 }
+
+// user and role(grouping policy)
+type SysUserRoleCasbin struct {
+	Username string `json:"username"`
+	Keyword  string `json:"keyword"` // role keyword
+	Domain   string `json:"domain"`  // tenant, only used by model with domain
+}
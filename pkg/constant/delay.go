@@ -5,4 +5,12 @@ const (
 	DelayExportObjPrefix      = "delay/export"
 	DelayExportEndPointSuffix = ".aliyuncs.com"
 	DelayExportObjExpire      = 1
+	DelayQueueTbPrefix        = "tb_delay_"
+)
+
+// policy when period task is missed longer than misfire threshold(scanner was down)
+const (
+	DelayMisfireCatchUp uint = iota // run all missed times one by one
+	DelayMisfireSkip                // skip missed times, wait for next time
+	DelayMisfireOnce                // run once now, skip others
 )
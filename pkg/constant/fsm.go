@@ -2,13 +2,58 @@ package constant
 
 const (
 	FsmPrefix = "tb_fsm_"
+	// store of current transaction in hook ctx
+	FsmStoreCtxKey = "FsmStore"
 )
 
 const (
-	FsmLogStatusWaiting   uint = iota // pending approval
-	FsmLogStatusApproved              // approved
-	FsmLogStatusRefused               // approval rejection
-	FsmLogStatusCancelled             // approval cancelled
+	FsmLogStatusWaiting     uint = iota // pending approval
+	FsmLogStatusApproved                // approved
+	FsmLogStatusRefused                 // approval rejection
+	FsmLogStatusCancelled               // approval cancelled
+	FsmLogStatusTransferred             // approval transferred to other approvers
+)
+
+const (
+	FsmJoinAll uint = iota // all parallel branches must be approved
+	FsmJoinAny             // any parallel branch approved is enough
+)
+
+const (
+	FsmSignAny    uint = iota // any single approver decides the level
+	FsmSignAll                // countersign, all approvers must approve
+	FsmSignQuorum             // at least quorum approvers must approve
+)
+
+const (
+	FsmRefuseOne      uint = iota // one refusal rejects the level
+	FsmRefuseMajority             // the level is rejected when most approvers refuse or quorum can not be reached
+)
+
+const (
+	FsmExpireActionNone     uint = iota // do nothing on timeout
+	FsmExpireActionEscalate             // escalate to other approvers on timeout
+	FsmExpireActionApprove              // auto approve on timeout
+	FsmExpireActionRefuse               // auto refuse on timeout
+)
+
+const (
+	FsmTaskName           = "fsm.sla"
+	FsmTaskActionTimeout  = "timeout"
+	FsmTaskActionRemind   = "remind"
+	FsmTaskUidTimeoutTmpl = "fsm.%d.timeout"
+	FsmTaskUidRemindTmpl  = "fsm.%d.remind.%d"
+)
+
+const (
+	FsmDiagramDot     = "dot"
+	FsmDiagramMermaid = "mermaid"
+)
+
+const (
+	FsmDiffActionAdd    = "add"
+	FsmDiffActionRemove = "remove"
+	FsmDiffActionChange = "change"
 )
 
 const (
@@ -16,6 +61,10 @@ const (
 	FsmMsgEnded           = "process ended"
 	FsmMsgConfigChanged   = "configuration changes"
 	FsmMsgManualCancel    = "manual cancelled"
+	FsmMsgTimeoutEscalate = "timeout escalated"
+	FsmMsgTimeoutApprove  = "timeout auto approved"
+	FsmMsgTimeoutRefuse   = "timeout auto refused"
+	FsmMsgTransferred     = "approver transferred"
 )
 
 const (
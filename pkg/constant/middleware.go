@@ -5,6 +5,8 @@ const (
 	MiddlewareIdempotencePrefix              = "idempotence"
 	MiddlewareIdempotenceExpire              = 24
 	MiddlewareIdempotenceTokenName           = "api-idempotence-token"
+	MiddlewareIdempotenceKeyName             = "Idempotency-Key"
+	MiddlewareIdempotenceReplayedHeaderKey   = "Idempotent-Replayed"
 	MiddlewareOperationLogNotLogin           = "not login"
 	MiddlewareOperationLogApiCacheKey        = "operation_log_api"
 	MiddlewareOperationLogSkipPathDict       = "OperationLogSkipPath"
@@ -15,11 +17,18 @@ const (
 	MiddlewareTransactionTxCtxKey            = "tx"
 	MiddlewareTransactionForceCommitCtxKey   = "ForceCommitTx"
 	MiddlewareJwtUserCtxKey                  = "user"
+	MiddlewareCasbinWatcherChannel           = "casbin.policy.update"
 	MiddlewareSignSeparator                  = "|"
+	MiddlewareSignAppIdCtxKey                = "SignAppId"
 	MiddlewareSignTokenHeaderKey             = "X-Sign-Token"
 	MiddlewareSignAppIdHeaderKey             = "appid"
 	MiddlewareSignTimestampHeaderKey         = "timestamp"
 	MiddlewareSignSignatureHeaderKey         = "signature"
+	MiddlewareSignNonceHeaderKey             = "nonce"
+	MiddlewareSignNoncePrefix                = "sign_nonce"
+	MiddlewareSignTypeHmac                   = "hmac"
+	MiddlewareSignTypeEd25519                = "ed25519"
+	MiddlewareSignTypeRsa                    = "rsa"
 	MiddlewareAccessLogIpLogKey              = "Ip"
 	MiddlewareParamsQueryCtxKey              = "ParamsQuery"
 	MiddlewareParamsBodyCtxKey               = "ParamsBody"
@@ -28,9 +37,34 @@ const (
 	MiddlewareParamsBodyLogKey               = "Body"
 	MiddlewareParamsRespCtxKey               = "ResponseBody"
 	MiddlewareParamsRespLogKey               = "Resp"
+	MiddlewareRatePrefix                     = "rate"
+	MiddlewareRateRuleKey                    = "rate.rule"
+	MiddlewareRateDefaultRuleId              = "default"
+	MiddlewareRateKeyIp                      = "ip"
+	MiddlewareRateKeyUser                    = "user"
+	MiddlewareRateKeyApp                     = "app"
+	MiddlewareRateTokenBucket                = "token"
+	MiddlewareRateSlidingWindow              = "window"
 	MiddlewareCorsOrigin                     = "*"
-	MiddlewareCorsHeaders                    = "Content-Type,AccessToken,X-CSRF-Token,Authorization,Token,X-Sign-Token,api-idempotence-token"
+	MiddlewareCorsHeaders                    = "Content-Type,AccessToken,X-CSRF-Token,Authorization,Token,X-Sign-Token,api-idempotence-token,Idempotency-Key"
 	MiddlewareCorsMethods                    = "OPTIONS,GET,POST,PUT,PATCH,DELETE"
 	MiddlewareCorsExpose                     = "Content-Length,Access-Control-Allow-Origin,Access-Control-Allow-Headers,Content-Type"
 	MiddlewareCorsCredentials                = "true"
 )
+
+// rbac with domains, g = username, role, domain
+const MiddlewareCasbinDomainModel = `[request_definition]
+r = sub, dom, obj, act
+
+[policy_definition]
+p = sub, dom, obj, act
+
+[role_definition]
+g = _, _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub, r.dom) && r.dom == p.dom && keyMatch2(r.obj, p.obj) && r.act == p.act
+`
@@ -0,0 +1,13 @@
+package constant
+
+const (
+	MqOutboxTbPrefix     = "tb_mq_"
+	MqRequestIdHeaderKey = "x-request-id"
+)
+
+// status of outbox message
+const (
+	MqOutboxPending uint = iota
+	MqOutboxSent
+	MqOutboxFailed // relay attempt times is more than max attempt
+)
@@ -0,0 +1,273 @@
+package delay
+
+import (
+	"bytes"
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-module/carbon/v2"
+	"github.com/hibiken/asynq"
+	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/log"
+	"github.com/piupuer/go-helper/pkg/resp"
+	"github.com/piupuer/go-helper/pkg/utils"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// callback config of single task
+type taskCallback struct {
+	Url    string            `json:"url"`
+	Header map[string]string `json:"header"`
+}
+
+// a delivery of http callback
+type callbackAttempt struct {
+	Uid        string            `json:"uid"`
+	Name       string            `json:"name"`
+	Url        string            `json:"url"`
+	Header     map[string]string `json:"header"`
+	Body       string            `json:"body"`
+	Attempt    int               `json:"attempt"`
+	Replay     bool              `json:"replay"`
+	StatusCode int               `json:"statusCode"`
+	Response   string            `json:"response"` // response body, max 4KB
+	Error      string            `json:"error"`
+	StartAt    int64             `json:"startAt"`  // unix timestamp
+	Duration   int64             `json:"duration"` // milliseconds
+}
+
+// FindCallbackAttempt find http callback attempts of task, the latest is first
+func (qu Queue) FindCallbackAttempt(uid string) (rp []resp.DelayCallbackAttempt, err error) {
+	rp = make([]resp.DelayCallbackAttempt, 0)
+	if qu.Error != nil {
+		err = qu.Error
+		return
+	}
+	list, err := qu.findCallbackAttempt(uid)
+	if err != nil {
+		return
+	}
+	for _, item := range list {
+		rp = append(rp, resp.DelayCallbackAttempt{
+			Uid:        item.Uid,
+			Name:       item.Name,
+			Url:        item.Url,
+			Body:       item.Body,
+			Attempt:    item.Attempt,
+			Replay:     item.Replay,
+			StatusCode: item.StatusCode,
+			Response:   item.Response,
+			Error:      item.Error,
+			StartAt: carbon.DateTime{
+				Carbon: carbon.CreateFromTimestamp(item.StartAt),
+			},
+			Duration: item.Duration,
+		})
+	}
+	return
+}
+
+// ReplayCallback deliver the latest http callback of task again
+func (qu Queue) ReplayCallback(ctx context.Context, uid string) (err error) {
+	if qu.Error != nil {
+		err = qu.Error
+		return
+	}
+	list, err := qu.findCallbackAttempt(uid)
+	if err != nil {
+		return
+	}
+	if len(list) == 0 {
+		err = errors.WithStack(ErrHttpCallbackNotFound)
+		return
+	}
+	last := list[0]
+	attempt := callbackAttempt{
+		Uid:     last.Uid,
+		Name:    last.Name,
+		Url:     last.Url,
+		Header:  last.Header,
+		Body:    last.Body,
+		Attempt: last.Attempt + 1,
+		Replay:  true,
+	}
+	err = qu.deliver(ctx, &attempt)
+	return
+}
+
+func (qu Queue) findCallbackAttempt(uid string) (list []callbackAttempt, err error) {
+	list = make([]callbackAttempt, 0)
+	if uid == "" {
+		err = errors.WithStack(ErrUuidNil)
+		return
+	}
+	arr, err := qu.redis.LRange(context.Background(), qu.callbackHistoryKey(uid), 0, -1).Result()
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	for _, v := range arr {
+		var item callbackAttempt
+		utils.Json2Struct(v, &item)
+		list = append(list, item)
+	}
+	return
+}
+
+func (qu Queue) saveCallback(uid string, cb taskCallback) (err error) {
+	if cb.Url == "" {
+		return
+	}
+	_, err = qu.redis.HSet(context.Background(), qu.ops.redisCallbackKey, uid, utils.Struct2Json(cb)).Result()
+	if err != nil {
+		err = errors.WithStack(ErrSaveCallback)
+	}
+	return
+}
+
+func (qu Queue) getCallback(uid string) (cb *taskCallback) {
+	t, err := qu.redis.HGet(context.Background(), qu.ops.redisCallbackKey, uid).Result()
+	if err != nil {
+		return
+	}
+	cb = &taskCallback{}
+	utils.Json2Struct(t, cb)
+	return
+}
+
+func (qu Queue) removeCallback(uid string) {
+	qu.redis.HDel(context.Background(), qu.ops.redisCallbackKey, uid)
+}
+
+func (qu Queue) callbackHistoryKey(uid string) string {
+	return qu.ops.redisCallbackKey + ".history." + uid
+}
+
+// http callback of task, the attempt is saved whether it succeeds or not
+func (p periodTaskHandler) httpCallback(ctx context.Context, task Task, cb taskCallback) (err error) {
+	retried, _ := asynq.GetRetryCount(ctx)
+	attempt := callbackAttempt{
+		Uid:     task.Uid,
+		Name:    task.Name,
+		Url:     cb.Url,
+		Header:  cb.Header,
+		Body:    utils.Struct2Json(task),
+		Attempt: retried + 1,
+	}
+	err = p.qu.deliver(ctx, &attempt)
+	if attempt.Response != "" {
+		task.WriteResult([]byte(attempt.Response))
+	}
+	return
+}
+
+func (qu Queue) deliver(ctx context.Context, attempt *callbackAttempt) (err error) {
+	start := time.Now()
+	defer func() {
+		attempt.StartAt = start.Unix()
+		attempt.Duration = time.Since(start).Milliseconds()
+		if err != nil {
+			attempt.Error = err.Error()
+		}
+		qu.saveCallbackAttempt(*attempt)
+	}()
+	u, err := url.Parse(attempt.Url)
+	if err != nil {
+		err = errors.WithStack(ErrHttpCallback)
+		return
+	}
+	client := &http.Client{
+		Timeout: time.Duration(qu.ops.callbackTimeout) * time.Second,
+	}
+	var r *http.Request
+	r, _ = http.NewRequest(http.MethodPost, attempt.Url, bytes.NewReader([]byte(attempt.Body)))
+	r.Header.Add("Content-Type", gin.MIMEJSON)
+	for k, v := range attempt.Header {
+		r.Header.Set(k, v)
+	}
+	if qu.ops.callbackSecret != "" {
+		// nonce is required by Sign with redis, every attempt has new nonce
+		var token string
+		token, err = utils.SignTokenWithNonce(qu.ops.callbackAppId, constant.MiddlewareSignTypeHmac, qu.ops.callbackSecret, http.MethodPost, u.RequestURI(), attempt.Body)
+		if err != nil {
+			err = errors.WithStack(err)
+			return
+		}
+		r.Header.Set(constant.MiddlewareSignTokenHeaderKey, token)
+	}
+	var res *http.Response
+	res, err = client.Do(r)
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		log.
+			WithContext(ctx).
+			WithFields(map[string]interface{}{
+				"Task": attempt.Body,
+			}).
+			WithError(err).
+			Error(ErrHttpCallbackTimeout)
+		err = ErrHttpCallbackTimeout
+		return
+	}
+	if err != nil {
+		log.
+			WithContext(ctx).
+			WithFields(map[string]interface{}{
+				"Task": attempt.Body,
+			}).
+			WithError(err).
+			Error(ErrHttpCallback)
+		err = ErrHttpCallback
+		return
+	}
+	defer res.Body.Close()
+	attempt.StatusCode = res.StatusCode
+	b, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+	attempt.Response = string(b)
+	if res.StatusCode != http.StatusOK {
+		log.
+			WithContext(ctx).
+			WithFields(map[string]interface{}{
+				"Task":       attempt.Body,
+				"StatusCode": res.StatusCode,
+			}).
+			Error(ErrHttpCallbackInvalidStatusCode)
+		err = ErrHttpCallbackInvalidStatusCode
+	}
+	return
+}
+
+func (qu Queue) saveCallbackAttempt(attempt callbackAttempt) {
+	ctx := context.Background()
+	key := qu.callbackHistoryKey(attempt.Uid)
+	p := qu.redis.Pipeline()
+	p.LPush(ctx, key, utils.Struct2Json(attempt))
+	p.LTrim(ctx, key, 0, int64(qu.ops.callbackHistory-1))
+	p.Expire(ctx, key, time.Duration(qu.ops.callbackHistoryExpire)*time.Second)
+	_, err := p.Exec(ctx)
+	if err != nil && err != redis.Nil {
+		log.WithError(err).Warn("save callback attempt failed")
+	}
+}
+
+// exponential backoff of http callback, other errors use asynq default
+func callbackRetryDelay(backoff int) asynq.RetryDelayFunc {
+	return func(n int, e error, t *asynq.Task) time.Duration {
+		if !errors.Is(e, ErrHttpCallback) && !errors.Is(e, ErrHttpCallbackTimeout) && !errors.Is(e, ErrHttpCallbackInvalidStatusCode) {
+			return asynq.DefaultRetryDelayFunc(n, e, t)
+		}
+		delay := time.Duration(backoff) * time.Second
+		for i := 0; i < n && delay < time.Hour; i++ {
+			delay *= 2
+		}
+		if delay > time.Hour {
+			// max delay 1h
+			delay = time.Hour
+		}
+		return delay
+	}
+}
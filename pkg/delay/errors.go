@@ -12,8 +12,15 @@ var (
 	ErrRedisNil                      = fmt.Errorf("redis is empty")
 	ErrRedisInvalid                  = fmt.Errorf("redis is invalid")
 	ErrExprInvalid                   = fmt.Errorf("expr is invalid")
+	ErrTimezoneInvalid               = fmt.Errorf("timezone is invalid")
+	ErrMisfireInvalid                = fmt.Errorf("misfire policy is invalid")
 	ErrSaveCron                      = fmt.Errorf("save cron failed")
+	ErrCronNotFound                  = fmt.Errorf("cron not found")
 	ErrHttpCallbackTimeout           = fmt.Errorf("http callback timeout")
 	ErrHttpCallback                  = fmt.Errorf("http callback err")
 	ErrHttpCallbackInvalidStatusCode = fmt.Errorf("http callback invalid status code")
+	ErrHttpCallbackNotFound          = fmt.Errorf("http callback history not found")
+	ErrSaveCallback                  = fmt.Errorf("save callback failed")
+	ErrQueueInvalid                  = fmt.Errorf("queue is invalid")
+	ErrHistoryDisabled               = fmt.Errorf("task history is disabled")
 )
@@ -0,0 +1,291 @@
+package delay
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-module/carbon/v2"
+	"github.com/hibiken/asynq"
+	"github.com/piupuer/go-helper/pkg/log"
+	"github.com/piupuer/go-helper/pkg/req"
+	"github.com/piupuer/go-helper/pkg/resp"
+	"github.com/piupuer/go-helper/pkg/utils"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"strings"
+	"time"
+)
+
+// result of task written by handler
+type taskResult struct {
+	writer *asynq.ResultWriter
+	data   []byte
+}
+
+// WriteResult save result of task by asynq ResultWriter, the last result is kept in history
+func (t Task) WriteResult(data []byte) (err error) {
+	if t.result == nil {
+		return
+	}
+	t.result.data = data
+	if t.result.writer != nil {
+		_, err = t.result.writer.Write(data)
+	}
+	return
+}
+
+// history storage of task execution
+type historyStore interface {
+	save(h QueueHistory) error
+	find(r *req.DelayHistory) ([]QueueHistory, error)
+	// delete histories started before timestamp
+	clear(before int64) error
+}
+
+// FindHistory find task execution histories, the latest is first
+func (qu Queue) FindHistory(r *req.DelayHistory) (rp []resp.DelayHistory, err error) {
+	rp = make([]resp.DelayHistory, 0)
+	if qu.Error != nil {
+		err = qu.Error
+		return
+	}
+	if qu.history == nil {
+		err = errors.WithStack(ErrHistoryDisabled)
+		return
+	}
+	list, err := qu.history.find(r)
+	if err != nil {
+		return
+	}
+	utils.Struct2StructByJson(list, &rp)
+	return
+}
+
+// MigrateHistory create history table if WithQueueHistoryDb
+func (qu Queue) MigrateHistory() (err error) {
+	if qu.Error != nil {
+		err = qu.Error
+		return
+	}
+	if h, ok := qu.history.(gormHistory); ok {
+		err = h.session().AutoMigrate(new(QueueHistory))
+	}
+	return
+}
+
+// save execution history of task
+func (qu Queue) saveHistory(ctx context.Context, task Task, start, end time.Time, err error) {
+	if qu.history == nil {
+		return
+	}
+	retried, _ := asynq.GetRetryCount(ctx)
+	h := QueueHistory{
+		Uid:     task.Uid,
+		Name:    task.Name,
+		Attempt: retried + 1,
+		StartAt: carbon.DateTime{
+			Carbon: carbon.Time2Carbon(start),
+		},
+		EndAt: carbon.DateTime{
+			Carbon: carbon.Time2Carbon(end),
+		},
+		Duration: end.Sub(start).Milliseconds(),
+	}
+	if err != nil {
+		h.Error = err.Error()
+	}
+	if task.result != nil {
+		h.Result = string(task.result.data)
+	}
+	e := qu.history.save(h)
+	if e != nil {
+		log.WithContext(ctx).WithError(e).Warn("save task history failed")
+	}
+}
+
+func (qu Queue) clearHistory() {
+	if qu.history == nil {
+		return
+	}
+	qu.history.clear(time.Now().Add(-time.Duration(qu.ops.historyRetention) * time.Second).Unix())
+}
+
+// redisHistory save histories in sorted set scored by start time,
+// the same member is also saved in index sets of uid and name so that find pages in redis
+type redisHistory struct {
+	redis redis.UniversalClient
+	key   string
+}
+
+func (h redisHistory) save(item QueueHistory) (err error) {
+	z := &redis.Z{
+		Score:  float64(item.StartAt.Carbon.TimestampMilli()),
+		Member: utils.Struct2Json(item),
+	}
+	ctx := context.Background()
+	_, err = h.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range h.keys(item) {
+			pipe.ZAdd(ctx, key, z)
+		}
+		return nil
+	})
+	err = errors.WithStack(err)
+	return
+}
+
+// find by index set of uid or name(name must be equal), uid takes precedence and name is only checked in page
+func (h redisHistory) find(r *req.DelayHistory) (list []QueueHistory, err error) {
+	list = make([]QueueHistory, 0)
+	uid := strings.TrimSpace(r.Uid)
+	name := strings.TrimSpace(r.Name)
+	key := h.key
+	if uid != "" {
+		key = h.uidKey(uid)
+	} else if name != "" {
+		key = h.nameKey(name)
+	}
+	ctx := context.Background()
+	total, err := h.redis.ZCard(ctx, key).Result()
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	start, end := pageRange(&r.Page, int(total))
+	if start >= end {
+		return
+	}
+	arr, err := h.redis.ZRevRange(ctx, key, int64(start), int64(end-1)).Result()
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	for _, v := range arr {
+		var item QueueHistory
+		utils.Json2Struct(v, &item)
+		if name != "" && item.Name != name {
+			continue
+		}
+		list = append(list, item)
+	}
+	return
+}
+
+func (h redisHistory) clear(before int64) (err error) {
+	ctx := context.Background()
+	max := fmt.Sprintf("%d", before*1000)
+	// clear runs periodically, expired members are few
+	arr, err := h.redis.ZRangeByScore(ctx, h.key, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: max,
+	}).Result()
+	if err != nil || len(arr) == 0 {
+		err = errors.WithStack(err)
+		return
+	}
+	keys := make(map[string]bool)
+	for _, v := range arr {
+		var item QueueHistory
+		utils.Json2Struct(v, &item)
+		for _, key := range h.keys(item) {
+			keys[key] = true
+		}
+	}
+	_, err = h.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for key := range keys {
+			// empty index set is removed by redis
+			pipe.ZRemRangeByScore(ctx, key, "-inf", max)
+		}
+		return nil
+	})
+	err = errors.WithStack(err)
+	return
+}
+
+// keys of history and its indexes
+func (h redisHistory) keys(item QueueHistory) []string {
+	keys := []string{h.key}
+	if item.Uid != "" {
+		keys = append(keys, h.uidKey(item.Uid))
+	}
+	if item.Name != "" {
+		keys = append(keys, h.nameKey(item.Name))
+	}
+	return keys
+}
+
+func (h redisHistory) uidKey(uid string) string {
+	return fmt.Sprintf("%s.uid.%s", h.key, uid)
+}
+
+func (h redisHistory) nameKey(name string) string {
+	return fmt.Sprintf("%s.name.%s", h.key, name)
+}
+
+// gormHistory save histories in QueueHistory table
+type gormHistory struct {
+	db       *gorm.DB
+	tbPrefix string
+}
+
+func (h gormHistory) save(item QueueHistory) (err error) {
+	err = h.session().Create(&item).Error
+	err = errors.WithStack(err)
+	return
+}
+
+func (h gormHistory) find(r *req.DelayHistory) (list []QueueHistory, err error) {
+	list = make([]QueueHistory, 0)
+	q := h.session().
+		Model(&QueueHistory{}).
+		Order("start_at DESC")
+	uid := strings.TrimSpace(r.Uid)
+	if uid != "" {
+		q.Where("uid = ?", uid)
+	}
+	name := strings.TrimSpace(r.Name)
+	if name != "" {
+		q.Where("name LIKE ?", "%"+name+"%")
+	}
+	page := &r.Page
+	countCache := false
+	if page.CountCache != nil {
+		countCache = *page.CountCache
+	}
+	if !page.NoPagination {
+		if !page.SkipCount {
+			q.Count(&page.Total)
+		}
+		if page.Total > 0 || page.SkipCount {
+			limit, offset := page.GetLimit()
+			err = q.Limit(limit).Offset(offset).Find(&list).Error
+		}
+	} else {
+		// no pagination
+		err = q.Find(&list).Error
+		page.Total = int64(len(list))
+		page.GetLimit()
+	}
+	page.CountCache = &countCache
+	err = errors.WithStack(err)
+	return
+}
+
+func (h gormHistory) clear(before int64) (err error) {
+	err = h.session().
+		Unscoped().
+		Where("start_at < ?", carbon.CreateFromTimestamp(before).ToDateTimeString()).
+		Delete(&QueueHistory{}).Error
+	err = errors.WithStack(err)
+	return
+}
+
+func (h gormHistory) session() *gorm.DB {
+	namingStrategy := schema.NamingStrategy{
+		TablePrefix:   h.tbPrefix,
+		SingularTable: true,
+	}
+	session := h.db.WithContext(context.Background()).Session(&gorm.Session{})
+	session.NamingStrategy = namingStrategy
+	return session
+}
@@ -0,0 +1,153 @@
+package delay
+
+import (
+	"context"
+	"github.com/hibiken/asynq"
+	"github.com/pkg/errors"
+	"sync"
+	"time"
+)
+
+// running state shared by copies of queue
+type queueState struct {
+	lock    sync.Mutex
+	running bool
+	servers []*asynq.Server
+	cancel  context.CancelFunc
+	loops   sync.WaitGroup
+	// tokens of NxLock held by this instance
+	tokenLock sync.Mutex
+	tokens    map[string]bool
+}
+
+// Start run task servers and scanners, it is called by NewQueue unless WithQueueAutoStart(false)
+func (qu Queue) Start() (err error) {
+	if qu.Error != nil {
+		err = qu.Error
+		return
+	}
+	qu.state.lock.Lock()
+	defer qu.state.lock.Unlock()
+	if qu.state.running {
+		return
+	}
+	// server can not be restarted after shutdown, create new ones
+	servers := qu.servers()
+	for i, srv := range servers {
+		err = srv.Start(periodTaskHandler{
+			qu: qu,
+		})
+		if err != nil {
+			for _, started := range servers[:i] {
+				started.Shutdown()
+			}
+			err = errors.WithStack(err)
+			return
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	qu.loop(ctx, time.Second, qu.scan)
+	if qu.ops.clearArchived > 0 {
+		qu.loop(ctx, time.Duration(qu.ops.clearArchived)*time.Second, qu.clearArchived)
+	}
+	if qu.history != nil {
+		qu.loop(ctx, time.Minute, qu.clearHistory)
+	}
+	qu.state.servers = servers
+	qu.state.cancel = cancel
+	qu.state.running = true
+	return
+}
+
+// Shutdown stop scanners, wait for in-flight tasks and release NxLock, it returns ctx.Err() if ctx is done first
+// example: listen.Http(listen.WithHttpShutdown(qu.Shutdown))
+func (qu Queue) Shutdown(ctx context.Context) (err error) {
+	if qu.Error != nil {
+		err = qu.Error
+		return
+	}
+	qu.state.lock.Lock()
+	defer qu.state.lock.Unlock()
+	if !qu.state.running {
+		return
+	}
+	// stop scanners first, no more period task is enqueued by this instance
+	qu.state.cancel()
+	done := make(chan struct{})
+	go func() {
+		qu.state.loops.Wait()
+		var wg sync.WaitGroup
+		for _, srv := range qu.state.servers {
+			wg.Add(1)
+			go func(srv *asynq.Server) {
+				defer wg.Done()
+				// stop pulling new tasks and wait for active workers
+				srv.Shutdown()
+			}(srv)
+		}
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = errors.WithStack(ctx.Err())
+	}
+	// lock is held by unfinished worker, release it so other instances keep scheduling,
+	// the lock which is expired and acquired by other instance is not released
+	qu.state.tokenLock.Lock()
+	for token := range qu.state.tokens {
+		qu.nxLock.UnlockWithToken(token)
+		delete(qu.state.tokens, token)
+	}
+	qu.state.tokenLock.Unlock()
+	qu.state.servers = nil
+	qu.state.cancel = nil
+	qu.state.running = false
+	return
+}
+
+// run fun every interval until ctx is done
+func (qu Queue) loop(ctx context.Context, interval time.Duration, fun func()) {
+	qu.state.loops.Add(1)
+	go func() {
+		defer qu.state.loops.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				fun()
+			}
+		}
+	}()
+}
+
+// spin until lock is acquired
+func (qu Queue) lock() string {
+	for {
+		if token, ok := qu.tryLock(); ok {
+			return token
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (qu Queue) tryLock() (token string, ok bool) {
+	token, ok = qu.nxLock.LockWithToken()
+	if ok {
+		qu.state.tokenLock.Lock()
+		qu.state.tokens[token] = true
+		qu.state.tokenLock.Unlock()
+	}
+	return
+}
+
+func (qu Queue) unlock(token string) {
+	qu.state.tokenLock.Lock()
+	delete(qu.state.tokens, token)
+	qu.state.tokenLock.Unlock()
+	qu.nxLock.UnlockWithToken(token)
+}
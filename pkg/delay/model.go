@@ -1,6 +1,9 @@
 package delay
 
-import "github.com/piupuer/go-helper/ms"
+import (
+	"github.com/golang-module/carbon/v2"
+	"github.com/piupuer/go-helper/ms"
+)
 
 // ExportHistory save export file history
 type ExportHistory struct {
@@ -12,3 +15,16 @@ type ExportHistory struct {
 	End      uint   `gorm:"type:tinyint(1);default:0;comment:0: pending, 1: end)" json:"end"`
 	Url      string `gorm:"comment:cloud file url" json:"url"`
 }
+
+// QueueHistory save task execution history(WithQueueHistoryDb)
+type QueueHistory struct {
+	ms.M
+	Uid      string          `gorm:"index:idx_uid;comment:task uid" json:"uid"`
+	Name     string          `gorm:"index:idx_name;comment:task name" json:"name"`
+	Attempt  int             `gorm:"comment:attempt times, start from 1" json:"attempt"`
+	StartAt  carbon.DateTime `gorm:"index:idx_start_at;comment:start time" json:"startAt"`
+	EndAt    carbon.DateTime `gorm:"comment:end time" json:"endAt"`
+	Duration int64           `gorm:"comment:duration milliseconds" json:"duration"`
+	Error    string          `gorm:"type:text;comment:error message" json:"error"`
+	Result   string          `gorm:"type:text;comment:result written by handler" json:"result"`
+}
@@ -0,0 +1,101 @@
+package delay
+
+import (
+	"context"
+	"github.com/hibiken/asynq"
+	"sort"
+	"strings"
+	"time"
+)
+
+// find handler of task name(without .once/.cron suffix), exact match first, then the longest prefix
+func (qu Queue) match(name string) func(ctx context.Context, t Task) error {
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".once"), ".cron")
+	if h, ok := qu.ops.handlers[name]; ok {
+		return h
+	}
+	var prefix string
+	for k := range qu.ops.handlers {
+		if strings.HasPrefix(name, k) && len(k) > len(prefix) {
+			prefix = k
+		}
+	}
+	if prefix != "" {
+		return qu.ops.handlers[prefix]
+	}
+	return qu.ops.handler
+}
+
+// all queue names, default queue is first
+func (qu Queue) queues() []string {
+	names := []string{qu.ops.name}
+	for k := range qu.ops.queues {
+		if k != qu.ops.name {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+// the queue is served by its own workers if concurrency is set
+func (qu Queue) servers() []*asynq.Server {
+	shared := make(map[string]int)
+	list := make([]*asynq.Server, 0)
+	for _, name := range qu.queues() {
+		weight := qu.ops.queues[name]
+		if weight <= 0 {
+			weight = 10
+		}
+		concurrency := qu.ops.queueConcurrency[name]
+		if concurrency > 0 {
+			list = append(list, asynq.NewServer(qu.redisOpt, asynq.Config{
+				Concurrency: concurrency,
+				Queues: map[string]int{
+					name: weight,
+				},
+				RetryDelayFunc:  callbackRetryDelay(qu.ops.callbackBackoff),
+				ShutdownTimeout: time.Duration(qu.ops.shutdownTimeout) * time.Second,
+			}))
+			continue
+		}
+		shared[name] = weight
+	}
+	if len(shared) > 0 {
+		list = append(list, asynq.NewServer(qu.redisOpt, asynq.Config{
+			Concurrency:     qu.ops.concurrency,
+			Queues:          shared,
+			StrictPriority:  qu.ops.strictPriority,
+			RetryDelayFunc:  callbackRetryDelay(qu.ops.callbackBackoff),
+			ShutdownTimeout: time.Duration(qu.ops.shutdownTimeout) * time.Second,
+		}))
+	}
+	return list
+}
+
+// delete task from the queue it belongs to
+func (qu Queue) deleteTask(uid string) (err error) {
+	for _, name := range qu.queues() {
+		err = qu.inspector.DeleteTask(name, uid)
+		if err == nil {
+			return
+		}
+	}
+	return
+}
+
+func (qu Queue) hasQueue(name string) bool {
+	if name == "" || name == qu.ops.name {
+		return true
+	}
+	_, ok := qu.ops.queues[name]
+	return ok
+}
+
+// queue of task, default queue if it is empty
+func (qu Queue) queueOf(name string) string {
+	if name == "" {
+		return qu.ops.name
+	}
+	return name
+}
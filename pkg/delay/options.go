@@ -121,6 +121,30 @@ type QueueOptions struct {
 	handler        func(ctx context.Context, t Task) error
 	callback       string
 	clearArchived  int
+	// handlers and queues
+	handlers         map[string]func(ctx context.Context, t Task) error
+	concurrency      int
+	queues           map[string]int
+	queueConcurrency map[string]int
+	strictPriority   bool
+	// lifecycle
+	autoStart       bool
+	shutdownTimeout int
+	// period task missed longer than threshold is misfired
+	misfireThreshold int
+	// http callback
+	redisCallbackKey      string
+	callbackTimeout       int
+	callbackAppId         string
+	callbackSecret        string
+	callbackBackoff       int
+	callbackHistory       int
+	callbackHistoryExpire int
+	// task history
+	historyRetention int
+	historyRedisKey  string
+	historyDb        *gorm.DB
+	historyTbPrefix  string
 }
 
 func WithQueueName(s string) func(*QueueOptions) {
@@ -149,6 +173,36 @@ func WithQueueRetention(second int) func(*QueueOptions) {
 	}
 }
 
+// keep task execution history for second, 0 means disabled
+func WithQueueHistoryRetention(second int) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		if second >= 0 {
+			getQueueOptionsOrSetDefault(options).historyRetention = second
+		}
+	}
+}
+
+func WithQueueHistoryRedisKey(s string) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		getQueueOptionsOrSetDefault(options).historyRedisKey = s
+	}
+}
+
+// save task execution history by gorm instead of redis, call Queue.MigrateHistory to create table
+func WithQueueHistoryDb(db *gorm.DB) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		if db != nil {
+			getQueueOptionsOrSetDefault(options).historyDb = db
+		}
+	}
+}
+
+func WithQueueHistoryTbPrefix(prefix string) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		getQueueOptionsOrSetDefault(options).historyTbPrefix = prefix
+	}
+}
+
 func WithQueueRedisMaxRetry(count int) func(*QueueOptions) {
 	return func(options *QueueOptions) {
 		getQueueOptionsOrSetDefault(options).maxRetry = count
@@ -163,12 +217,127 @@ func WithQueueHandler(fun func(ctx context.Context, t Task) error) func(*QueueOp
 	}
 }
 
+// register handler of task name like http mux, the longest prefix matches if no exact handler, WithQueueHandler is default
+func WithQueueHandlerFunc(name string, fun func(ctx context.Context, t Task) error) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		if fun != nil {
+			ops := getQueueOptionsOrSetDefault(options)
+			if ops.handlers == nil {
+				ops.handlers = make(map[string]func(ctx context.Context, t Task) error)
+			}
+			ops.handlers[name] = fun
+		}
+	}
+}
+
+// workers shared by queues which have no concurrency
+func WithQueueConcurrency(count int) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		if count > 0 {
+			getQueueOptionsOrSetDefault(options).concurrency = count
+		}
+	}
+}
+
+// add queue with priority weight, concurrency>0 means the queue is served by its own workers
+func WithQueuePriority(name string, weight, concurrency int) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		if name == "" || weight <= 0 {
+			return
+		}
+		ops := getQueueOptionsOrSetDefault(options)
+		if ops.queues == nil {
+			ops.queues = make(map[string]int)
+		}
+		if ops.queueConcurrency == nil {
+			ops.queueConcurrency = make(map[string]int)
+		}
+		ops.queues[name] = weight
+		ops.queueConcurrency[name] = concurrency
+	}
+}
+
+// tasks in lower priority queue are processed only if all higher priority queues are empty
+func WithQueueStrictPriority(flag bool) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		getQueueOptionsOrSetDefault(options).strictPriority = flag
+	}
+}
+
+// Start is called by NewQueue if flag is true
+func WithQueueAutoStart(flag bool) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		getQueueOptionsOrSetDefault(options).autoStart = flag
+	}
+}
+
+// max time to wait for in-flight tasks when Shutdown
+func WithQueueShutdownTimeout(second int) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		if second > 0 {
+			getQueueOptionsOrSetDefault(options).shutdownTimeout = second
+		}
+	}
+}
+
+// period task is misfired if it is missed longer than second
+func WithQueueMisfireThreshold(second int) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		if second > 0 {
+			getQueueOptionsOrSetDefault(options).misfireThreshold = second
+		}
+	}
+}
+
 func WithQueueCallback(s string) func(*QueueOptions) {
 	return func(options *QueueOptions) {
 		getQueueOptionsOrSetDefault(options).callback = s
 	}
 }
 
+func WithQueueRedisCallbackKey(s string) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		getQueueOptionsOrSetDefault(options).redisCallbackKey = s
+	}
+}
+
+func WithQueueCallbackTimeout(second int) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		if second > 0 {
+			getQueueOptionsOrSetDefault(options).callbackTimeout = second
+		}
+	}
+}
+
+// sign http callback by middleware.Sign scheme
+func WithQueueCallbackSign(appId, secret string) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		getQueueOptionsOrSetDefault(options).callbackAppId = appId
+		getQueueOptionsOrSetDefault(options).callbackSecret = secret
+	}
+}
+
+// retry delay of failed http callback is second*2^n, max 1h
+func WithQueueCallbackBackoff(second int) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		if second > 0 {
+			getQueueOptionsOrSetDefault(options).callbackBackoff = second
+		}
+	}
+}
+
+// keep the latest count attempts of each task for second
+func WithQueueCallbackHistory(count, second int) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		if count > 0 {
+			getQueueOptionsOrSetDefault(options).callbackHistory = count
+		}
+		if second > 0 {
+			getQueueOptionsOrSetDefault(options).callbackHistoryExpire = second
+		}
+	}
+}
+
 func WithQueueClearArchived(second int) func(*QueueOptions) {
 	return func(options *QueueOptions) {
 		if second > 0 {
@@ -180,12 +349,25 @@ func WithQueueClearArchived(second int) func(*QueueOptions) {
 func getQueueOptionsOrSetDefault(options *QueueOptions) *QueueOptions {
 	if options == nil {
 		return &QueueOptions{
-			name:           "delay",
-			redisUri:       "redis://127.0.0.1:6379/0",
-			redisPeriodKey: "delay.queue.period",
-			retention:      60,
-			maxRetry:       3,
-			clearArchived:  300,
+			name:             "delay",
+			redisUri:         "redis://127.0.0.1:6379/0",
+			redisPeriodKey:   "delay.queue.period",
+			retention:        60,
+			maxRetry:         3,
+			clearArchived:    300,
+			concurrency:      10,
+			autoStart:        true,
+			shutdownTimeout:  8,
+			misfireThreshold: 5,
+			// http callback
+			redisCallbackKey:      "delay.queue.callback",
+			callbackTimeout:       10,
+			callbackBackoff:       1,
+			callbackHistory:       20,
+			callbackHistoryExpire: 7 * 24 * 3600,
+			// task history is disabled by default
+			historyRedisKey: "delay.queue.history",
+			historyTbPrefix: constant.DelayQueueTbPrefix,
 		}
 	}
 	return options
@@ -196,10 +378,17 @@ type QueueTaskOptions struct {
 	name      string
 	payload   string
 	expr      string         // only period task
+	timezone  string         // only period task
+	jitter    int            // only period task
+	misfire   uint           // only period task
 	in        *time.Duration // only once task
 	at        *time.Time     // only once task
 	now       bool           // only once task
 	retention int            // only once task
+	queue     string         // default queue if it is empty
+	// http callback of task, it takes precedence over WithQueueHandler/WithQueueCallback
+	callback       string
+	callbackHeader map[string]string
 }
 
 func WithQueueTaskUuid(s string) func(*QueueTaskOptions) {
@@ -252,6 +441,52 @@ func WithQueueTaskRetention(second int) func(*QueueTaskOptions) {
 	}
 }
 
+// IANA timezone of expr such as Asia/Shanghai, the same as CRON_TZ= prefix of expr
+func WithQueueTaskTimezone(s string) func(*QueueTaskOptions) {
+	return func(options *QueueTaskOptions) {
+		getQueueTaskOptionsOrSetDefault(options).timezone = s
+	}
+}
+
+// delay random seconds(0~second) before each run, avoid running many tasks at the same time
+func WithQueueTaskJitter(second int) func(*QueueTaskOptions) {
+	return func(options *QueueTaskOptions) {
+		if second > 0 {
+			getQueueTaskOptionsOrSetDefault(options).jitter = second
+		}
+	}
+}
+
+// constant.DelayMisfireCatchUp/DelayMisfireSkip/DelayMisfireOnce
+func WithQueueTaskMisfire(policy uint) func(*QueueTaskOptions) {
+	return func(options *QueueTaskOptions) {
+		getQueueTaskOptionsOrSetDefault(options).misfire = policy
+	}
+}
+
+// the queue must be added by WithQueuePriority
+func WithQueueTaskQueue(name string) func(*QueueTaskOptions) {
+	return func(options *QueueTaskOptions) {
+		getQueueTaskOptionsOrSetDefault(options).queue = name
+	}
+}
+
+func WithQueueTaskCallback(s string) func(*QueueTaskOptions) {
+	return func(options *QueueTaskOptions) {
+		getQueueTaskOptionsOrSetDefault(options).callback = s
+	}
+}
+
+func WithQueueTaskCallbackHeader(k, v string) func(*QueueTaskOptions) {
+	return func(options *QueueTaskOptions) {
+		ops := getQueueTaskOptionsOrSetDefault(options)
+		if ops.callbackHeader == nil {
+			ops.callbackHeader = make(map[string]string)
+		}
+		ops.callbackHeader[k] = v
+	}
+}
+
 func getQueueTaskOptionsOrSetDefault(options *QueueTaskOptions) *QueueTaskOptions {
 	if options == nil {
 		return &QueueTaskOptions{
@@ -0,0 +1,259 @@
+package delay
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-module/carbon/v2"
+	"github.com/hibiken/asynq"
+	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/req"
+	"github.com/piupuer/go-helper/pkg/resp"
+	"github.com/piupuer/go-helper/pkg/utils"
+	"github.com/pkg/errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FindCron find period tasks order by uid
+func (qu Queue) FindCron(r *req.DelayCron) (rp []resp.DelayCron, err error) {
+	rp = make([]resp.DelayCron, 0)
+	if qu.Error != nil {
+		err = qu.Error
+		return
+	}
+	m, err := qu.redis.HGetAll(context.Background(), qu.ops.redisPeriodKey).Result()
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	uid := strings.TrimSpace(r.Uid)
+	name := strings.TrimSpace(r.Name)
+	for _, v := range m {
+		var item periodTask
+		utils.Json2Struct(v, &item)
+		if uid != "" && !strings.Contains(item.Uid, uid) {
+			continue
+		}
+		info := item.toResp()
+		if name != "" && !strings.Contains(info.Name, name) {
+			continue
+		}
+		rp = append(rp, info)
+	}
+	sort.Slice(rp, func(i, j int) bool {
+		return rp[i].Uid < rp[j].Uid
+	})
+	start, end := pageRange(&r.Page, len(rp))
+	rp = rp[start:end]
+	return
+}
+
+// GetCron get period task by uid
+func (qu Queue) GetCron(uid string) (rp *resp.DelayCron, err error) {
+	if qu.Error != nil {
+		err = qu.Error
+		return
+	}
+	item, err := qu.getPeriod(uid)
+	if err != nil {
+		return
+	}
+	info := item.toResp()
+	rp = &info
+	return
+}
+
+// PauseCron stop scheduling period task, the next run already enqueued will be removed
+func (qu Queue) PauseCron(uid string) (err error) {
+	if qu.Error != nil {
+		err = qu.Error
+		return
+	}
+	token := qu.lock()
+	defer qu.unlock(token)
+	item, err := qu.getPeriod(uid)
+	if err != nil {
+		return
+	}
+	if item.Paused {
+		return
+	}
+	item.Paused = true
+	err = qu.savePeriod(*item)
+	if err != nil {
+		return
+	}
+	qu.deleteScheduled(*item)
+	return
+}
+
+// ResumeCron continue scheduling period task from now
+func (qu Queue) ResumeCron(uid string) (err error) {
+	if qu.Error != nil {
+		err = qu.Error
+		return
+	}
+	token := qu.lock()
+	defer qu.unlock(token)
+	item, err := qu.getPeriod(uid)
+	if err != nil {
+		return
+	}
+	if !item.Paused {
+		return
+	}
+	item.Next, err = getNext(item.Expr, item.Timezone, 0)
+	if err != nil {
+		err = errors.WithStack(ErrExprInvalid)
+		return
+	}
+	item.Paused = false
+	err = qu.savePeriod(*item)
+	return
+}
+
+// UpdateCron update expr/name/payload of period task, processed count and paused status are kept
+func (qu Queue) UpdateCron(uid string, r req.UpdateDelayCron) (err error) {
+	if qu.Error != nil {
+		err = qu.Error
+		return
+	}
+	token := qu.lock()
+	defer qu.unlock(token)
+	item, err := qu.getPeriod(uid)
+	if err != nil {
+		return
+	}
+	if r.Name != nil {
+		item.Name = *r.Name + ".cron"
+	}
+	if r.Payload != nil {
+		item.Payload = *r.Payload
+	}
+	if r.Expr != nil {
+		item.Expr = *r.Expr
+	}
+	if r.Timezone != nil {
+		if *r.Timezone != "" {
+			_, err = time.LoadLocation(*r.Timezone)
+			if err != nil {
+				err = errors.WithStack(ErrTimezoneInvalid)
+				return
+			}
+		}
+		item.Timezone = *r.Timezone
+	}
+	if r.Jitter != nil {
+		item.Jitter = *r.Jitter
+	}
+	if r.Misfire != nil {
+		if !validMisfire(*r.Misfire) {
+			err = errors.WithStack(ErrMisfireInvalid)
+			return
+		}
+		item.Misfire = *r.Misfire
+	}
+	item.Next, err = getNext(item.Expr, item.Timezone, 0)
+	if err != nil {
+		err = errors.WithStack(ErrExprInvalid)
+		return
+	}
+	err = qu.savePeriod(*item)
+	if err != nil {
+		return
+	}
+	// the next run is enqueued by old config, remove it
+	qu.deleteScheduled(*item)
+	return
+}
+
+func (qu Queue) getPeriod(uid string) (item *periodTask, err error) {
+	if uid == "" {
+		err = errors.WithStack(ErrUuidNil)
+		return
+	}
+	t, err := qu.redis.HGet(context.Background(), qu.ops.redisPeriodKey, uid).Result()
+	if err == redis.Nil {
+		err = errors.WithStack(ErrCronNotFound)
+		return
+	}
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	item = &periodTask{}
+	utils.Json2Struct(t, item)
+	return
+}
+
+func (qu Queue) savePeriod(item periodTask) (err error) {
+	_, err = qu.redis.HSet(context.Background(), qu.ops.redisPeriodKey, item.Uid, utils.Struct2Json(item)).Result()
+	if err != nil {
+		err = errors.WithStack(ErrSaveCron)
+	}
+	return
+}
+
+// delete task which is waiting to be processed
+func (qu Queue) deleteScheduled(item periodTask) {
+	queue := qu.queueOf(item.Queue)
+	info, err := qu.inspector.GetTaskInfo(queue, item.Uid)
+	if err != nil || info.State == asynq.TaskStateActive {
+		return
+	}
+	qu.inspector.DeleteTask(queue, item.Uid)
+}
+
+// paginate slice in memory
+func pageRange(page *resp.Page, total int) (start, end int) {
+	countCache := false
+	if page.CountCache != nil {
+		countCache = *page.CountCache
+	}
+	page.Total = int64(total)
+	end = total
+	if !page.NoPagination {
+		limit, offset := page.GetLimit()
+		start = offset
+		if start > total {
+			start = total
+		}
+		if start+limit < end {
+			end = start + limit
+		}
+	} else {
+		page.GetLimit()
+	}
+	page.CountCache = &countCache
+	return
+}
+
+func (p periodTask) toResp() resp.DelayCron {
+	rp := resp.DelayCron{
+		Uid:       p.Uid,
+		Name:      strings.TrimSuffix(p.Name, ".cron"),
+		Expr:      p.Expr,
+		Timezone:  p.Timezone,
+		Jitter:    p.Jitter,
+		Misfire:   p.Misfire,
+		Payload:   p.Payload,
+		Queue:     p.Queue,
+		Processed: p.Processed,
+		Paused:    p.Paused,
+	}
+	if !p.Paused && p.Next > 0 {
+		rp.Next = carbon.DateTime{
+			Carbon: carbon.CreateFromTimestamp(p.Next),
+		}
+	}
+	return rp
+}
+
+func validMisfire(policy uint) bool {
+	switch policy {
+	case constant.DelayMisfireCatchUp, constant.DelayMisfireSkip, constant.DelayMisfireOnce:
+		return true
+	}
+	return false
+}
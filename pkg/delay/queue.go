@@ -1,20 +1,19 @@
 package delay
 
 import (
-	"bytes"
 	"context"
-	"github.com/gin-gonic/gin"
+	"fmt"
 	"github.com/go-redis/redis/v8"
 	"github.com/golang-module/carbon/v2"
 	"github.com/hibiken/asynq"
+	"github.com/piupuer/go-helper/pkg/constant"
 	"github.com/piupuer/go-helper/pkg/lock"
 	"github.com/piupuer/go-helper/pkg/log"
 	"github.com/piupuer/go-helper/pkg/tracing"
 	"github.com/piupuer/go-helper/pkg/utils"
 	"github.com/pkg/errors"
 	"github.com/robfig/cron/v3"
-	"net"
-	"net/http"
+	"math/rand"
 	"strings"
 	"time"
 )
@@ -26,16 +25,23 @@ type Queue struct {
 	nxLock    lock.NxLock
 	client    *asynq.Client
 	inspector *asynq.Inspector
+	history   historyStore
+	state     *queueState
 	Error     error
 }
 
 type periodTask struct {
-	Expr      string `json:"expr"` // cron expr github.com/robfig/cron/v3
+	Expr      string `json:"expr"`     // cron expr github.com/robfig/cron/v3, seconds field is optional
+	Timezone  string `json:"timezone"` // local timezone if it is empty
+	Jitter    int    `json:"jitter"`   // random delay max seconds
+	Misfire   uint   `json:"misfire"`  // misfire policy
 	Name      string `json:"name"`
 	Uid       string `json:"uid"`
 	Payload   string `json:"payload"`
 	Next      int64  `json:"next"`      // next schedule unix timestamp
 	Processed int64  `json:"processed"` // run times
+	Paused    bool   `json:"paused"`    // skip scheduling if paused
+	Queue     string `json:"queue"`     // default queue if it is empty
 }
 
 type periodTaskHandler struct {
@@ -46,6 +52,7 @@ type Task struct {
 	Name    string `json:"name"`
 	Uid     string `json:"uid"`
 	Payload string `json:"payload"`
+	result  *taskResult
 }
 
 func (p periodTaskHandler) ProcessTask(ctx context.Context, t *asynq.Task) (err error) {
@@ -54,11 +61,20 @@ func (p periodTaskHandler) ProcessTask(ctx context.Context, t *asynq.Task) (err
 		Name:    t.Type(),
 		Uid:     t.ResultWriter().TaskID(),
 		Payload: string(t.Payload()),
+		result: &taskResult{
+			writer: t.ResultWriter(),
+		},
 	}
-	if p.qu.ops.handler != nil {
-		err = p.qu.ops.handler(ctx, task)
+	start := time.Now()
+	if cb := p.qu.getCallback(task.Uid); cb != nil {
+		// callback of task first
+		err = p.httpCallback(ctx, task, *cb)
+	} else if h := p.qu.match(task.Name); h != nil {
+		err = h(ctx, task)
 	} else if p.qu.ops.callback != "" {
-		err = p.httpCallback(ctx, task)
+		err = p.httpCallback(ctx, task, taskCallback{
+			Url: p.qu.ops.callback,
+		})
 	} else {
 		log.
 			WithContext(ctx).
@@ -67,57 +83,20 @@ func (p periodTaskHandler) ProcessTask(ctx context.Context, t *asynq.Task) (err
 			}).
 			Info("no task handler")
 	}
+	p.qu.saveHistory(ctx, task, start, time.Now(), err)
+	if strings.HasSuffix(task.Name, ".once") {
+		retried, _ := asynq.GetRetryCount(ctx)
+		maxRetry, _ := asynq.GetMaxRetry(ctx)
+		if err == nil || retried >= maxRetry {
+			// once task will not run again
+			p.qu.removeCallback(task.Uid)
+		}
+	}
 	// save processed count
 	p.qu.processed(task.Uid)
 	return
 }
 
-func (p periodTaskHandler) httpCallback(ctx context.Context, task Task) (err error) {
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-	}
-	body := utils.Struct2Json(task)
-	var r *http.Request
-	r, _ = http.NewRequest(http.MethodPost, p.qu.ops.callback, bytes.NewReader([]byte(body)))
-	r.Header.Add("Content-Type", gin.MIMEJSON)
-	var res *http.Response
-	res, err = client.Do(r)
-	if e, ok := err.(net.Error); ok && e.Timeout() {
-		log.
-			WithContext(ctx).
-			WithFields(map[string]interface{}{
-				"Task": body,
-			}).
-			WithError(err).
-			Error(ErrHttpCallbackTimeout)
-		err = ErrHttpCallbackTimeout
-		return
-	}
-	if err != nil {
-		log.
-			WithContext(ctx).
-			WithFields(map[string]interface{}{
-				"Task": body,
-			}).
-			WithError(err).
-			Error(ErrHttpCallback)
-		err = ErrHttpCallback
-		return
-	}
-	defer res.Body.Close()
-	if res.StatusCode != http.StatusOK {
-		log.
-			WithContext(ctx).
-			WithFields(map[string]interface{}{
-				"Task":       body,
-				"StatusCode": res.StatusCode,
-			}).
-			Error(ErrHttpCallbackInvalidStatusCode)
-		err = ErrHttpCallbackInvalidStatusCode
-	}
-	return
-}
-
 // NewQueue delay queue implemented by asynq: https://github.com/hibiken/asynq
 func NewQueue(options ...func(*QueueOptions)) (qu *Queue) {
 	ops := getQueueOptionsOrSetDefault(nil)
@@ -143,44 +122,34 @@ func NewQueue(options ...func(*QueueOptions)) (qu *Queue) {
 		Redis:      rd,
 		Expiration: 10 * time.Second,
 	}
-	// initialize server
-	srv := asynq.NewServer(
-		rs,
-		asynq.Config{
-			Concurrency: 10,
-			Queues: map[string]int{
-				ops.name: 10,
-			},
-		},
-	)
-	go func() {
-		var h periodTaskHandler
-		h.qu = *qu
-		if e := srv.Run(h); e != nil {
-			log.WithError(err).Error("run task handler failed")
-		}
-	}()
 	qu.ops = *ops
 	qu.redis = rd
 	qu.redisOpt = rs
 	qu.nxLock = nxLock
 	qu.client = client
 	qu.inspector = inspector
-	// initialize scanner
-	go func() {
-		for {
-			time.Sleep(time.Second)
-			qu.scan()
-		}
-	}()
-	if qu.ops.clearArchived > 0 {
-		// initialize clear archived
-		go func() {
-			for {
-				time.Sleep(time.Duration(qu.ops.clearArchived) * time.Second)
-				qu.clearArchived()
+	if ops.historyRetention > 0 {
+		if ops.historyDb != nil {
+			qu.history = gormHistory{
+				db:       ops.historyDb,
+				tbPrefix: ops.historyTbPrefix,
 			}
-		}()
+		} else {
+			qu.history = redisHistory{
+				redis: rd,
+				key:   ops.historyRedisKey,
+			}
+		}
+	}
+	qu.state = &queueState{
+		tokens: make(map[string]bool),
+	}
+	if ops.autoStart {
+		err = qu.Start()
+		if err != nil {
+			log.WithError(err).Error("start queue failed")
+			qu.Error = err
+		}
 	}
 	return
 }
@@ -194,9 +163,13 @@ func (qu Queue) Once(options ...func(*QueueTaskOptions)) (err error) {
 		err = errors.WithStack(ErrUuidNil)
 		return
 	}
+	if !qu.hasQueue(ops.queue) {
+		err = errors.WithStack(ErrQueueInvalid)
+		return
+	}
 	t := asynq.NewTask(ops.name+".once", []byte(ops.payload), asynq.TaskID(ops.uid))
 	taskOpts := []asynq.Option{
-		asynq.Queue(qu.ops.name),
+		asynq.Queue(qu.queueOf(ops.queue)),
 		asynq.MaxRetry(qu.ops.maxRetry),
 	}
 	if ops.retention > 0 {
@@ -211,6 +184,13 @@ func (qu Queue) Once(options ...func(*QueueTaskOptions)) (err error) {
 	} else if ops.now {
 		taskOpts = append(taskOpts, asynq.ProcessIn(time.Second))
 	}
+	err = qu.saveCallback(ops.uid, taskCallback{
+		Url:    ops.callback,
+		Header: ops.callbackHeader,
+	})
+	if err != nil {
+		return
+	}
 	_, err = qu.client.Enqueue(t, taskOpts...)
 	return
 }
@@ -224,18 +204,44 @@ func (qu Queue) Cron(options ...func(*QueueTaskOptions)) (err error) {
 		err = errors.WithStack(ErrUuidNil)
 		return
 	}
+	if !qu.hasQueue(ops.queue) {
+		err = errors.WithStack(ErrQueueInvalid)
+		return
+	}
+	if ops.timezone != "" {
+		_, err = time.LoadLocation(ops.timezone)
+		if err != nil {
+			err = errors.WithStack(ErrTimezoneInvalid)
+			return
+		}
+	}
+	if !validMisfire(ops.misfire) {
+		err = errors.WithStack(ErrMisfireInvalid)
+		return
+	}
 	var next int64
-	next, err = getNext(ops.expr, 0)
+	next, err = getNext(ops.expr, ops.timezone, 0)
 	if err != nil {
 		err = errors.WithStack(ErrExprInvalid)
 		return
 	}
 	t := periodTask{
-		Expr:    ops.expr,
-		Name:    ops.name + ".cron",
-		Uid:     ops.uid,
-		Payload: ops.payload,
-		Next:    next,
+		Expr:     ops.expr,
+		Timezone: ops.timezone,
+		Jitter:   ops.jitter,
+		Misfire:  ops.misfire,
+		Name:     ops.name + ".cron",
+		Uid:      ops.uid,
+		Payload:  ops.payload,
+		Next:     next,
+		Queue:    ops.queue,
+	}
+	err = qu.saveCallback(ops.uid, taskCallback{
+		Url:    ops.callback,
+		Header: ops.callbackHeader,
+	})
+	if err != nil {
+		return
 	}
 	_, err = qu.redis.HSet(context.Background(), qu.ops.redisPeriodKey, ops.uid, utils.Struct2Json(t)).Result()
 	if err != nil {
@@ -246,31 +252,18 @@ func (qu Queue) Cron(options ...func(*QueueTaskOptions)) (err error) {
 }
 
 func (qu Queue) Remove(uid string) (err error) {
-	var ok bool
-	for {
-		ok = qu.nxLock.Lock()
-		if ok {
-			break
-		}
-		time.Sleep(100 * time.Millisecond)
-	}
-	defer qu.nxLock.Unlock()
+	token := qu.lock()
+	defer qu.unlock(token)
 	qu.redis.HDel(context.Background(), qu.ops.redisPeriodKey, uid)
+	qu.removeCallback(uid)
 
-	err = qu.inspector.DeleteTask(qu.ops.name, uid)
+	err = qu.deleteTask(uid)
 	return
 }
 
 func (qu Queue) processed(uid string) {
-	var ok bool
-	for {
-		ok = qu.nxLock.Lock()
-		if ok {
-			break
-		}
-		time.Sleep(100 * time.Millisecond)
-	}
-	defer qu.nxLock.Unlock()
+	token := qu.lock()
+	defer qu.unlock(token)
 	ctx := context.Background()
 	t, e := qu.redis.HGet(ctx, qu.ops.redisPeriodKey, uid).Result()
 	if e == nil || e != redis.Nil {
@@ -284,24 +277,40 @@ func (qu Queue) processed(uid string) {
 
 func (qu Queue) scan() {
 	ctx := context.Background()
-	ok := qu.nxLock.Lock()
+	token, ok := qu.tryLock()
 	if !ok {
 		return
 	}
-	defer qu.nxLock.Unlock()
+	defer qu.unlock(token)
 	m, _ := qu.redis.HGetAll(ctx, qu.ops.redisPeriodKey).Result()
 	p := qu.redis.Pipeline()
 	ops := qu.ops
 	for _, v := range m {
 		var item periodTask
 		utils.Json2Struct(v, &item)
-		next, _ := getNext(item.Expr, item.Next)
+		if item.Paused {
+			continue
+		}
+		runAt := item.Next
+		now := time.Now().Unix()
+		if item.Next < now-int64(ops.misfireThreshold) {
+			// scanner was down past next
+			switch item.Misfire {
+			case constant.DelayMisfireSkip:
+				item.Next, _ = getNext(item.Expr, item.Timezone, now)
+				p.HSet(ctx, qu.ops.redisPeriodKey, item.Uid, utils.Struct2Json(item))
+				continue
+			case constant.DelayMisfireOnce:
+				runAt = now
+			}
+		}
+		next, _ := getNext(item.Expr, item.Timezone, runAt)
 		t := asynq.NewTask(item.Name, []byte(item.Payload), asynq.TaskID(item.Uid))
 		taskOpts := []asynq.Option{
-			asynq.Queue(ops.name),
+			asynq.Queue(qu.queueOf(item.Queue)),
 			asynq.MaxRetry(ops.maxRetry),
 		}
-		diff := next - item.Next
+		diff := next - runAt
 		if diff > 10 {
 			retention := diff / 3
 			if diff > 600 {
@@ -311,7 +320,11 @@ func (qu Queue) scan() {
 			// set retention avoid repeat in short time
 			taskOpts = append(taskOpts, asynq.Retention(time.Duration(retention)*time.Second))
 		}
-		taskOpts = append(taskOpts, asynq.ProcessAt(time.Unix(item.Next, 0)))
+		processAt := time.Unix(runAt, 0)
+		if item.Jitter > 0 {
+			processAt = processAt.Add(time.Duration(rand.Intn(item.Jitter+1)) * time.Second)
+		}
+		taskOpts = append(taskOpts, asynq.ProcessAt(processAt))
 		_, err := qu.client.Enqueue(t, taskOpts...)
 		// enqueue success, update next
 		if err == nil {
@@ -325,7 +338,13 @@ func (qu Queue) scan() {
 }
 
 func (qu Queue) clearArchived() {
-	list, err := qu.inspector.ListArchivedTasks(qu.ops.name, asynq.Page(1), asynq.PageSize(100))
+	for _, name := range qu.queues() {
+		qu.clearArchivedOf(name)
+	}
+}
+
+func (qu Queue) clearArchivedOf(queue string) {
+	list, err := qu.inspector.ListArchivedTasks(queue, asynq.Page(1), asynq.PageSize(100))
 	if err != nil {
 		return
 	}
@@ -343,7 +362,7 @@ func (qu Queue) clearArchived() {
 			if e == nil || e != redis.Nil {
 				var task periodTask
 				utils.Json2Struct(t, &task)
-				next, _ := getNext(task.Expr, task.Next)
+				next, _ := getNext(task.Expr, task.Timezone, task.Next)
 				diff := next - task.Next
 				if diff <= 60 {
 					if carbon.Now().Gt(last.AddMinutes(5)) {
@@ -370,14 +389,21 @@ func (qu Queue) clearArchived() {
 			}
 		}
 		if flag {
-			qu.inspector.DeleteTask(qu.ops.name, uid)
+			qu.inspector.DeleteTask(queue, uid)
 		}
 	}
 }
 
-func getNext(expr string, timestamp int64) (next int64, err error) {
+// seconds field is optional
+var cronParser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// timezone is ignored if expr starts with CRON_TZ= or TZ=
+func getNext(expr, timezone string, timestamp int64) (next int64, err error) {
+	if timezone != "" && !strings.HasPrefix(expr, "CRON_TZ=") && !strings.HasPrefix(expr, "TZ=") {
+		expr = fmt.Sprintf("CRON_TZ=%s %s", timezone, expr)
+	}
 	var schedule cron.Schedule
-	schedule, err = cron.ParseStandard(expr)
+	schedule, err = cronParser.Parse(expr)
 	if err != nil {
 		return
 	}
@@ -1,15 +1,50 @@
 package delay
 
 import (
+	"context"
 	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/hibiken/asynq"
+	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/req"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
 
+const redisUri = "redis://127.0.0.1:6379/0"
+
+// create queue, test is skipped if redis is unavailable
+func getQueue(t *testing.T, options ...func(*QueueOptions)) *Queue {
+	rs, err := asynq.ParseRedisURI(redisUri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rd := rs.MakeRedisClient().(redis.UniversalClient)
+	defer rd.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err = rd.Ping(ctx).Err()
+	if err != nil {
+		t.Skipf("[unit test]initialize redis err: %v", err)
+	}
+	qu := NewQueue(append([]func(*QueueOptions){WithQueueRedisUri(redisUri)}, options...)...)
+	if qu.Error != nil {
+		t.Fatal(qu.Error)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		qu.Shutdown(ctx)
+	})
+	return qu
+}
+
 func TestNewQueue(t *testing.T) {
 	i := 0
 	for i < 10 {
-		qu := NewQueue()
+		qu := getQueue(t)
 		// add cron tasks
 		err := qu.Cron(
 			WithQueueTaskUuid("order1"),
@@ -74,3 +109,245 @@ func TestNewQueue(t *testing.T) {
 	ch := make(chan int)
 	<-ch
 }
+
+func TestQueue_PauseCron(t *testing.T) {
+	qu := getQueue(t)
+	uid := fmt.Sprintf("pause.order.%d", time.Now().UnixNano())
+	err := qu.Cron(
+		WithQueueTaskUuid(uid),
+		WithQueueTaskName("pause.task"),
+		WithQueueTaskExpr("@every 1s"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer qu.Remove(uid)
+	time.Sleep(3 * time.Second)
+	err = qu.PauseCron(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := qu.GetCron(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Paused {
+		t.Errorf("cron %s is not paused", uid)
+	}
+	// the task enqueued before pause may still run
+	processed := info.Processed
+	time.Sleep(3 * time.Second)
+	info, err = qu.GetCron(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Processed > processed+1 {
+		t.Errorf("paused cron processed %d times, want at most %d", info.Processed, processed+1)
+	}
+	processed = info.Processed
+
+	name := "resume.task"
+	err = qu.UpdateCron(uid, req.UpdateDelayCron{
+		Name: &name,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = qu.ResumeCron(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(3 * time.Second)
+	list, err := qu.FindCron(&req.DelayCron{
+		Name: name,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, item := range list {
+		if item.Uid != uid {
+			continue
+		}
+		found = true
+		if item.Paused {
+			t.Errorf("cron %s is not resumed", uid)
+		}
+		if item.Processed <= processed {
+			t.Errorf("resumed cron processed %d times, want more than %d", item.Processed, processed)
+		}
+	}
+	if !found {
+		t.Errorf("cron %s is not found by name %s", uid, name)
+	}
+}
+
+func TestQueue_ReplayCallback(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+	qu := getQueue(t,
+		WithQueueCallbackSign("app1", "secret1"),
+		WithQueueCallbackBackoff(2),
+	)
+	uid := fmt.Sprintf("callback.order.%d", time.Now().UnixNano())
+	err := qu.Once(
+		WithQueueTaskUuid(uid),
+		WithQueueTaskName("callback.task"),
+		WithQueueTaskNow(true),
+		WithQueueTaskCallback(ts.URL),
+		WithQueueTaskCallbackHeader("X-Tenant", "1"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Second)
+	err = qu.ReplayCallback(context.Background(), uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := qu.FindCallbackAttempt(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("attempt count = %d, want 2", len(list))
+	}
+	if !list[0].Replay || list[0].Attempt != list[1].Attempt+1 {
+		t.Errorf("latest attempt = %+v, want replay of attempt %d", list[0], list[1].Attempt)
+	}
+	if list[0].StatusCode != http.StatusOK {
+		t.Errorf("replay status = %d, want %d", list[0].StatusCode, http.StatusOK)
+	}
+}
+
+func TestQueue_FindHistory(t *testing.T) {
+	qu := getQueue(t,
+		WithQueueHistoryRetention(3600),
+		WithQueueHandler(func(ctx context.Context, t Task) error {
+			return t.WriteResult([]byte(`{"code":201}`))
+		}),
+	)
+	uid := fmt.Sprintf("history.order.%d", time.Now().UnixNano())
+	err := qu.Once(
+		WithQueueTaskUuid(uid),
+		WithQueueTaskName("history.task"),
+		WithQueueTaskNow(true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Second)
+	list, err := qu.FindHistory(&req.DelayHistory{
+		Uid: uid,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 {
+		t.Fatalf("history count = %d, want 1", len(list))
+	}
+	if list[0].Result != `{"code":201}` || list[0].Error != "" || list[0].Attempt != 1 {
+		t.Errorf("history = %+v, want result {\"code\":201} of attempt 1", list[0])
+	}
+	list, err = qu.FindHistory(&req.DelayHistory{
+		Uid:  uid,
+		Name: "other.task",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 0 {
+		t.Errorf("history count of other name = %d, want 0", len(list))
+	}
+}
+
+func TestQueue_HandlerFunc(t *testing.T) {
+	qu := getQueue(t,
+		WithQueueConcurrency(20),
+		WithQueuePriority("critical", 6, 0),
+		WithQueuePriority("low", 1, 2),
+		WithQueueHandlerFunc("email:", func(ctx context.Context, t Task) error {
+			fmt.Println("email", t.Uid)
+			return nil
+		}),
+		WithQueueHandlerFunc("email:welcome", func(ctx context.Context, t Task) error {
+			fmt.Println("welcome", t.Uid)
+			return nil
+		}),
+	)
+	qu.Once(
+		WithQueueTaskUuid("email.order1"),
+		WithQueueTaskName("email:welcome"),
+		WithQueueTaskQueue("critical"),
+		WithQueueTaskNow(true),
+	)
+	qu.Once(
+		WithQueueTaskUuid("email.order2"),
+		WithQueueTaskName("email:reset"),
+		WithQueueTaskQueue("low"),
+		WithQueueTaskNow(true),
+	)
+	time.Sleep(5 * time.Second)
+}
+
+func TestQueue_Shutdown(t *testing.T) {
+	qu := getQueue(t,
+		WithQueueAutoStart(false),
+		WithQueueHandler(func(ctx context.Context, t Task) error {
+			time.Sleep(3 * time.Second)
+			fmt.Println("done", t.Uid)
+			return nil
+		}),
+	)
+	err := qu.Start()
+	fmt.Println(err)
+	qu.Once(
+		WithQueueTaskUuid("shutdown.order"),
+		WithQueueTaskName("shutdown.task"),
+		WithQueueTaskNow(true),
+	)
+	time.Sleep(2 * time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = qu.Shutdown(ctx)
+	fmt.Println(err)
+}
+
+func TestGetNext(t *testing.T) {
+	base := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+	cases := []struct {
+		expr     string
+		timezone string
+		next     time.Time
+	}{
+		{"*/10 * * * * *", "", time.Date(2022, 1, 1, 0, 0, 10, 0, time.UTC)},
+		{"0 9 * * *", "Asia/Shanghai", time.Date(2022, 1, 1, 1, 0, 0, 0, time.UTC)},
+		{"CRON_TZ=America/New_York 0 9 * * *", "Asia/Shanghai", time.Date(2022, 1, 1, 14, 0, 0, 0, time.UTC)},
+		{"@every 5s", "", time.Date(2022, 1, 1, 0, 0, 5, 0, time.UTC)},
+	}
+	for _, item := range cases {
+		next, err := getNext(item.expr, item.timezone, base)
+		if err != nil || next != item.next.Unix() {
+			t.Errorf("getNext(%s, %s) = %v, %v, want %v", item.expr, item.timezone, time.Unix(next, 0).UTC(), err, item.next)
+		}
+	}
+}
+
+func TestValidMisfire(t *testing.T) {
+	cases := []struct {
+		policy uint
+		valid  bool
+	}{
+		{constant.DelayMisfireCatchUp, true},
+		{constant.DelayMisfireSkip, true},
+		{constant.DelayMisfireOnce, true},
+		{constant.DelayMisfireOnce + 1, false},
+	}
+	for _, item := range cases {
+		if valid := validMisfire(item.policy); valid != item.valid {
+			t.Errorf("validMisfire(%d) = %v, want %v", item.policy, valid, item.valid)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package fsm
+
+import (
+	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/req"
+	"github.com/piupuer/go-helper/pkg/resp"
+	"github.com/piupuer/go-helper/pkg/utils"
+	"github.com/pkg/errors"
+)
+
+// find the next event to be processed from level, levels whose condition is not satisfied are skipped
+// progress is the virtual progress after skipping(the skipped level is considered approved/refused)
+// event is nil when there is no next event(the process is ended)
+func (fs Fsm) routeEvent(machineId, version, level, approved uint, progress string, params map[string]interface{}) (event *Event, newProgress string, err error) {
+	newProgress = progress
+	for {
+		if approved == constant.FsmLogStatusRefused {
+			event, err = fs.getPrevEvent(machineId, version, level)
+		} else {
+			event, err = fs.getNextEvent(machineId, version, level)
+		}
+		if err != nil {
+			if approved != constant.FsmLogStatusRefused && errors.Is(err, ErrRecordNotFound) {
+				event = nil
+				err = nil
+			}
+			return
+		}
+		if eventActive(*event, params) {
+			return
+		}
+		newProgress = getNextItemName(approved, event.Name.Name)
+		level = event.Level
+	}
+}
+
+// bind event approvers to log, parallel branches whose condition is satisfied are bound at the same time
+func (fs Fsm) bindApprover(log *Log, event Event, params map[string]interface{}) {
+	if len(event.Branches) == 0 {
+		log.CanApprovalRoles = event.Roles
+		log.CanApprovalUsers = event.Users
+		log.Votes = getEventSeats(event)
+		return
+	}
+	branches := make([]EventBranch, 0)
+	log.Branches = make([]LogBranch, 0)
+	for _, branch := range event.Branches {
+		if !matchCondition(branch.Condition, params) {
+			continue
+		}
+		branches = append(branches, branch)
+		log.Branches = append(log.Branches, LogBranch{
+			BranchId: branch.Id,
+			Name:     branch.Name,
+		})
+	}
+	log.CanApprovalRoles, log.CanApprovalUsers = getBranchApprovers(branches)
+}
+
+// save approver vote to log branch, joined is true when the join condition of parallel branches is met
+// approved is the final status of the current level when joined
+func (fs Fsm) approveBranch(log Log, r req.FsmApproveLog) (approved uint, joined bool, err error) {
+	approved = uint(r.Approved)
+	if approved != constant.FsmLogStatusApproved && approved != constant.FsmLogStatusRefused {
+		err = errors.Wrap(ErrParams, "approved")
+		return
+	}
+	index := fs.findLogBranch(log, r)
+	if index < 0 {
+		err = errors.WithStack(ErrNoPermissionApprove)
+		return
+	}
+	log.Branches[index].Approved = approved
+	log.Branches[index].ApprovalRoleId = r.ApprovalRoleId
+	log.Branches[index].ApprovalUserId = r.ApprovalUserId
+	log.Branches[index].ApprovalOpinion = r.ApprovalOpinion
+	err = fs.store.SaveLogBranch(&log.Branches[index])
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+
+	total := len(log.Branches)
+	approvedCount := 0
+	refusedCount := 0
+	pending := make([]LogBranch, 0)
+	for _, item := range log.Branches {
+		switch item.Approved {
+		case constant.FsmLogStatusApproved:
+			approvedCount++
+		case constant.FsmLogStatusRefused:
+			refusedCount++
+		case constant.FsmLogStatusWaiting:
+			pending = append(pending, item)
+		}
+	}
+	switch log.NextEvent.Join {
+	case constant.FsmJoinAny:
+		if approvedCount > 0 {
+			approved = constant.FsmLogStatusApproved
+			joined = true
+		} else if refusedCount == total {
+			approved = constant.FsmLogStatusRefused
+			joined = true
+		}
+	default:
+		if refusedCount > 0 {
+			approved = constant.FsmLogStatusRefused
+			joined = true
+		} else if approvedCount == total {
+			approved = constant.FsmLogStatusApproved
+			joined = true
+		}
+	}
+
+	if joined {
+		// the remaining branches no longer need to be approved
+		err = fs.cancelPendingBranch(log)
+		return
+	}
+	// only approvers of pending branches can approve
+	log.CanApprovalRoles, log.CanApprovalUsers = getLogBranchApprovers(pending)
+	err = fs.store.ReplaceLogApprover(&log)
+	if err != nil {
+		err = errors.WithStack(err)
+	}
+	return
+}
+
+// find pending branch of approver, approver can act for delegators
+func (fs Fsm) findLogBranch(log Log, r req.FsmApproveLog) int {
+	approverUserIds := fs.getApproverUserIds(log.Category, r.ApprovalUserId)
+	for i, item := range log.Branches {
+		if item.Approved != constant.FsmLogStatusWaiting {
+			continue
+		}
+		roles, users := getLogBranchApprovers([]LogBranch{item})
+		if containsRole(roles, r.ApprovalRoleId) || containsAnyUser(users, approverUserIds) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (fs Fsm) cancelPendingBranch(log Log) error {
+	for _, item := range log.Branches {
+		if item.Approved != constant.FsmLogStatusWaiting {
+			continue
+		}
+		item.Approved = constant.FsmLogStatusCancelled
+		err := fs.store.SaveLogBranch(&item)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// the event is active when its condition is satisfied and at least one branch is satisfied(if it has branches)
+func eventActive(event Event, params map[string]interface{}) bool {
+	if !matchCondition(event.Condition, params) {
+		return false
+	}
+	if len(event.Branches) == 0 {
+		return true
+	}
+	for _, branch := range event.Branches {
+		if matchCondition(branch.Condition, params) {
+			return true
+		}
+	}
+	return false
+}
+
+// merge approvers of branches(remove repeat)
+func getBranchApprovers(branches []EventBranch) ([]Role, []User) {
+	roles := make([]Role, 0)
+	users := make([]User, 0)
+	for _, branch := range branches {
+		for _, role := range branch.Roles {
+			if !containsRole(roles, role.Id) {
+				roles = append(roles, role)
+			}
+		}
+		for _, user := range branch.Users {
+			if !containsUser(users, user.Id) {
+				users = append(users, user)
+			}
+		}
+	}
+	return roles, users
+}
+
+// merge approvers of log branches, transferred branch is approved by its transfer target instead of branch approvers
+func getLogBranchApprovers(items []LogBranch) ([]Role, []User) {
+	roles := make([]Role, 0)
+	users := make([]User, 0)
+	for _, item := range items {
+		var branchRoles []Role
+		var branchUsers []User
+		if item.RoleId > constant.Zero || item.UserId > constant.Zero {
+			branchRoles, branchUsers = toRoles([]uint{item.RoleId}), toUsers([]uint{item.UserId})
+		} else {
+			branchRoles, branchUsers = getBranchApprovers([]EventBranch{item.Branch})
+		}
+		for _, role := range branchRoles {
+			if role.Id > constant.Zero && !containsRole(roles, role.Id) {
+				roles = append(roles, role)
+			}
+		}
+		for _, user := range branchUsers {
+			if user.Id > constant.Zero && !containsUser(users, user.Id) {
+				users = append(users, user)
+			}
+		}
+	}
+	return roles, users
+}
+
+func getLogBranches(log Log) []resp.FsmLogBranch {
+	branches := make([]resp.FsmLogBranch, 0)
+	for _, item := range log.Branches {
+		branches = append(branches, resp.FsmLogBranch{
+			Name:           item.Name,
+			Status:         item.Approved,
+			Opinion:        item.ApprovalOpinion,
+			ApprovalRoleId: item.ApprovalRoleId,
+			ApprovalUserId: item.ApprovalUserId,
+		})
+	}
+	return branches
+}
+
+func containsRole(roles []Role, id uint) bool {
+	ids := make([]uint, 0)
+	for _, role := range roles {
+		ids = append(ids, role.Id)
+	}
+	return id > constant.Zero && utils.ContainsUint(ids, id)
+}
+
+func containsAnyUser(users []User, ids []uint) bool {
+	for _, id := range ids {
+		if containsUser(users, id) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsUser(users []User, id uint) bool {
+	ids := make([]uint, 0)
+	for _, user := range users {
+		ids = append(ids, user.Id)
+	}
+	return id > constant.Zero && utils.ContainsUint(ids, id)
+}
@@ -0,0 +1,111 @@
+package fsm
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+)
+
+// condition operators, two chars must be checked first
+var conditionOperators = []string{">=", "<=", "!=", "==", ">", "<"}
+
+// check condition expression syntax
+// expression example: amount > 10000 && type == 'leave' || vip == true
+func checkCondition(expr string) error {
+	if strings.TrimSpace(expr) == "" {
+		return nil
+	}
+	for _, or := range strings.Split(expr, "||") {
+		for _, and := range strings.Split(or, "&&") {
+			_, _, _, err := parseConditionClause(and)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// match condition expression with log params, empty expression is always matched
+func matchCondition(expr string, params map[string]interface{}) bool {
+	if strings.TrimSpace(expr) == "" {
+		return true
+	}
+	for _, or := range strings.Split(expr, "||") {
+		match := true
+		for _, and := range strings.Split(or, "&&") {
+			if !matchConditionClause(and, params) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+func matchConditionClause(clause string, params map[string]interface{}) bool {
+	key, op, val, err := parseConditionClause(clause)
+	if err != nil {
+		return false
+	}
+	v, ok := params[key]
+	if !ok || v == nil {
+		return false
+	}
+	param := fmt.Sprint(v)
+	n1, err1 := strconv.ParseFloat(param, 64)
+	n2, err2 := strconv.ParseFloat(val, 64)
+	if err1 == nil && err2 == nil {
+		switch op {
+		case ">=":
+			return n1 >= n2
+		case "<=":
+			return n1 <= n2
+		case "!=":
+			return n1 != n2
+		case "==":
+			return n1 == n2
+		case ">":
+			return n1 > n2
+		case "<":
+			return n1 < n2
+		}
+		return false
+	}
+	switch op {
+	case ">=":
+		return param >= val
+	case "<=":
+		return param <= val
+	case "!=":
+		return param != val
+	case "==":
+		return param == val
+	case ">":
+		return param > val
+	case "<":
+		return param < val
+	}
+	return false
+}
+
+func parseConditionClause(clause string) (key, op, val string, err error) {
+	clause = strings.TrimSpace(clause)
+	for _, item := range conditionOperators {
+		index := strings.Index(clause, item)
+		if index > 0 {
+			key = strings.TrimSpace(clause[:index])
+			op = item
+			val = strings.Trim(strings.TrimSpace(clause[index+len(item):]), `'"`)
+			break
+		}
+	}
+	if key == "" || op == "" {
+		err = errors.Wrap(ErrCondition, clause)
+	}
+	return
+}
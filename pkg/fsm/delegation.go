@@ -0,0 +1,210 @@
+package fsm
+
+import (
+	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/log"
+	"github.com/piupuer/go-helper/pkg/req"
+	"github.com/piupuer/go-helper/pkg/resp"
+	"github.com/piupuer/go-helper/pkg/utils"
+	"github.com/pkg/errors"
+)
+
+// create delegation, delegate user can approve logs of user in [StartAt, EndAt]
+func (fs Fsm) CreateDelegation(r req.FsmCreateDelegation) error {
+	if fs.Error != nil {
+		return fs.Error
+	}
+	if r.UserId == constant.Zero || uint(r.DelegateUserId) == constant.Zero || r.UserId == uint(r.DelegateUserId) {
+		return errors.Wrap(ErrDelegation, "user")
+	}
+	if r.StartAt.IsZero() || r.EndAt.IsZero() || !r.EndAt.Gt(r.StartAt.Carbon) {
+		return errors.Wrap(ErrDelegation, "time")
+	}
+	var delegation Delegation
+	utils.Struct2StructByJson(r, &delegation)
+	err := fs.store.CreateDelegation(&delegation)
+	return errors.WithStack(err)
+}
+
+// find delegations
+func (fs Fsm) FindDelegation(r *req.FsmDelegation) ([]resp.FsmDelegation, error) {
+	if fs.Error != nil {
+		return nil, fs.Error
+	}
+	list, err := fs.store.FindDelegation(r)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	newList := make([]resp.FsmDelegation, 0)
+	utils.Struct2StructByJson(list, &newList)
+	return newList, nil
+}
+
+// delete delegations, only delegations created by user are deleted
+func (fs Fsm) DeleteDelegationByIds(userId uint, ids []uint) error {
+	if fs.Error != nil {
+		return fs.Error
+	}
+	if userId == constant.Zero {
+		return errors.Wrap(ErrDelegation, "user")
+	}
+	err := fs.store.DeleteDelegation(userId, ids)
+	return errors.WithStack(err)
+}
+
+// transfer pending log to other roles/users, current approver permission is required
+func (fs Fsm) TransferLog(r req.FsmTransferLog) error {
+	if fs.Error != nil {
+		return fs.Error
+	}
+	return fs.transaction(func(f Fsm) error {
+		roleIds := r.Roles.Uints()
+		userIds := r.Users.Uints()
+		if len(roleIds) == 0 && len(userIds) == 0 {
+			return errors.WithStack(ErrTransferTargetNil)
+		}
+		oldLog, err := f.CheckLogPermission(req.FsmPermissionLog{
+			Category:       r.Category,
+			Uuid:           r.Uuid,
+			ApprovalRoleId: r.ApprovalRoleId,
+			ApprovalUserId: r.ApprovalUserId,
+			Approved:       constant.FsmLogStatusApproved,
+		})
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		approval := req.FsmApproveLog{
+			ApprovalRoleId:  r.ApprovalRoleId,
+			ApprovalUserId:  r.ApprovalUserId,
+			ApprovalOpinion: r.ApprovalOpinion,
+		}
+		var newLog *Log
+		if len(oldLog.Votes) == 0 && len(oldLog.Branches) == 0 {
+			newLog, err = f.transferLog(*oldLog, toRoles(roleIds), toUsers(userIds), constant.FsmMsgTransferred, approval)
+		} else {
+			// countersign seat or parallel branch belongs to one approver
+			if len(roleIds)+len(userIds) != 1 {
+				return errors.WithStack(ErrTransferTargetNotUnique)
+			}
+			var roleId, userId uint
+			if len(roleIds) > 0 {
+				roleId = roleIds[0]
+			} else {
+				userId = userIds[0]
+			}
+			newLog, err = f.transferSeat(*oldLog, roleId, userId, approval)
+		}
+		if err != nil {
+			return err
+		}
+		// tasks of old log are expired, new approvers have their own timeout/remind
+		return f.scheduleSla(*newLog, oldLog.NextEvent, true)
+	})
+}
+
+// move seat/branch of current approver to target role/user, other seats/branches are carried to the new log as they are
+func (fs Fsm) transferSeat(oldLog Log, roleId, userId uint, r req.FsmApproveLog) (*Log, error) {
+	newLog := newTransferredLog(oldLog, constant.FsmMsgTransferred)
+	if len(oldLog.Votes) > 0 {
+		index := fs.findVoteSeat(oldLog, r)
+		if index < 0 {
+			return nil, errors.WithStack(ErrNoPermissionApprove)
+		}
+		newLog.Votes = make([]LogVote, 0)
+		for i, item := range oldLog.Votes {
+			vote := LogVote{
+				RoleId:          item.RoleId,
+				UserId:          item.UserId,
+				Approved:        item.Approved,
+				ApprovalRoleId:  item.ApprovalRoleId,
+				ApprovalUserId:  item.ApprovalUserId,
+				ApprovalOpinion: item.ApprovalOpinion,
+			}
+			if i == index {
+				vote.RoleId = roleId
+				vote.UserId = userId
+			}
+			newLog.Votes = append(newLog.Votes, vote)
+		}
+		newLog.CanApprovalRoles, newLog.CanApprovalUsers = getVoteApprovers(newLog.Votes)
+		oldLog.Votes[index].Approved = constant.FsmLogStatusTransferred
+		oldLog.Votes[index].ApprovalRoleId = r.ApprovalRoleId
+		oldLog.Votes[index].ApprovalUserId = r.ApprovalUserId
+		oldLog.Votes[index].ApprovalOpinion = r.ApprovalOpinion
+		err := fs.store.SaveLogVote(&oldLog.Votes[index])
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	} else {
+		index := fs.findLogBranch(oldLog, r)
+		if index < 0 {
+			return nil, errors.WithStack(ErrNoPermissionApprove)
+		}
+		newLog.Branches = make([]LogBranch, 0)
+		pending := make([]LogBranch, 0)
+		for i, item := range oldLog.Branches {
+			branch := LogBranch{
+				BranchId:        item.BranchId,
+				Name:            item.Name,
+				RoleId:          item.RoleId,
+				UserId:          item.UserId,
+				Approved:        item.Approved,
+				ApprovalRoleId:  item.ApprovalRoleId,
+				ApprovalUserId:  item.ApprovalUserId,
+				ApprovalOpinion: item.ApprovalOpinion,
+			}
+			if i == index {
+				branch.RoleId = roleId
+				branch.UserId = userId
+			}
+			newLog.Branches = append(newLog.Branches, branch)
+			if branch.Approved == constant.FsmLogStatusWaiting {
+				// event branch is only used to get approvers, it is not saved with log
+				branch.Branch = item.Branch
+				pending = append(pending, branch)
+			}
+		}
+		newLog.CanApprovalRoles, newLog.CanApprovalUsers = getLogBranchApprovers(pending)
+		oldLog.Branches[index].Approved = constant.FsmLogStatusTransferred
+		oldLog.Branches[index].ApprovalRoleId = r.ApprovalRoleId
+		oldLog.Branches[index].ApprovalUserId = r.ApprovalUserId
+		oldLog.Branches[index].ApprovalOpinion = r.ApprovalOpinion
+		err := fs.store.SaveLogBranch(&oldLog.Branches[index])
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	err := fs.store.CreateLog(&newLog)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	err = fs.closeTransferredLog(oldLog, r)
+	if err != nil {
+		return nil, err
+	}
+	return &newLog, nil
+}
+
+// get user ids which approver can act for(include approver self)
+func (fs Fsm) getApproverUserIds(category, userId uint) []uint {
+	ids := []uint{userId}
+	for _, item := range fs.getDelegations(category, userId) {
+		if !utils.ContainsUint(ids, item.UserId) {
+			ids = append(ids, item.UserId)
+		}
+	}
+	return ids
+}
+
+// get active delegations of delegate user, category=0 means all categories
+func (fs Fsm) getDelegations(category, delegateUserId uint) []Delegation {
+	if delegateUserId == constant.Zero {
+		return make([]Delegation, 0)
+	}
+	list, err := fs.store.FindActiveDelegation(category, delegateUserId)
+	if err != nil {
+		log.WithContext(fs.ops.ctx).WithError(err).Warn("find delegation failed")
+		return make([]Delegation, 0)
+	}
+	return list
+}
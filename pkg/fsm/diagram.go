@@ -0,0 +1,335 @@
+package fsm
+
+import (
+	"fmt"
+	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/req"
+	"github.com/piupuer/go-helper/pkg/resp"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+type diagram struct {
+	name        string
+	states      []string
+	start       string
+	end         string
+	transitions []diagramTransition
+	// states which log has passed
+	visited map[string]bool
+	// states which log is waiting at
+	current map[string]bool
+	// fork/join pseudo states of parallel branches
+	forks map[string]bool
+	joins map[string]bool
+}
+
+type diagramTransition struct {
+	src     string
+	dst     string
+	label   string
+	current bool
+	// level is skipped when its condition is not satisfied
+	skip bool
+}
+
+// render machine to DOT/Mermaid text, the position of log is marked if uuid is not empty
+func (fs Fsm) Diagram(r req.FsmDiagram) (*resp.FsmDiagram, error) {
+	if fs.Error != nil {
+		return nil, fs.Error
+	}
+	format := r.Format
+	if format == "" {
+		format = constant.FsmDiagramMermaid
+	}
+	if format != constant.FsmDiagramDot && format != constant.FsmDiagramMermaid {
+		return nil, errors.Wrap(ErrDiagramFormat, format)
+	}
+	machine, err := fs.GetMachineByCategory(uint(r.Category))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	version := machine.Version
+	if uint(r.Version) > constant.Zero {
+		version = uint(r.Version)
+	}
+	track := make([]resp.FsmLogTrack, 0)
+	progress := ""
+	if r.Uuid != "" {
+		logs, err := fs.FindLog(req.FsmLog{
+			Category: r.Category,
+			Uuid:     r.Uuid,
+		})
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if len(logs) == 0 {
+			return nil, errors.WithStack(ErrRecordNotFound)
+		}
+		// log is pinned to its version
+		last := logs[len(logs)-1]
+		version = last.Version
+		track, err = fs.FindLogTrack(logs)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if last.Approved == constant.FsmLogStatusWaiting {
+			progress = last.Progress.Name
+		}
+	}
+	events, err := fs.store.FindEvent(machine.Id, version)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(events) == 0 {
+		return nil, errors.WithStack(ErrEventsNil)
+	}
+
+	d := newDiagram(machine.Name, events, track, progress)
+	rp := resp.FsmDiagram{
+		Format:  format,
+		Version: version,
+	}
+	if format == constant.FsmDiagramDot {
+		rp.Content = d.dot()
+	} else {
+		rp.Content = d.mermaid()
+	}
+	return &rp, nil
+}
+
+// progress is the state which log is waiting at(empty if log is not pending)
+func newDiagram(name string, events []Event, track []resp.FsmLogTrack, progress string) diagram {
+	d := diagram{
+		name:    name,
+		visited: make(map[string]bool),
+		current: make(map[string]bool),
+		forks:   make(map[string]bool),
+		joins:   make(map[string]bool),
+	}
+	for _, item := range track {
+		d.visited[item.Name] = true
+	}
+	// the last track is the event which log is waiting for
+	pending := ""
+	if l := len(track); l > 0 && progress != "" {
+		pending = track[l-1].Name
+		d.visited[progress] = true
+	}
+	outgoing := make(map[string]bool)
+	for _, event := range events {
+		approve := strings.HasSuffix(event.Dst.Name, constant.FsmSuffixApproved)
+		label := event.Name.Name
+		if event.Condition != "" {
+			label = fmt.Sprintf("%s [%s]", label, event.Condition)
+		}
+		if rule := eventRule(event, approve); rule != "" {
+			label = fmt.Sprintf("%s (%s)", label, rule)
+		}
+		// approvals of parallel branches are forked and joined to the destination
+		dst := event.Dst.Name
+		if approve && len(event.Branches) > 0 {
+			dst = d.addBranches(event)
+			outgoing[dst] = true
+		}
+		for _, src := range event.Src {
+			d.addState(src.Name)
+			d.addState(dst)
+			outgoing[src.Name] = true
+			current := event.Name.Name == pending && src.Name == progress
+			if current {
+				d.current[src.Name] = true
+			}
+			d.transitions = append(d.transitions, diagramTransition{
+				src:     src.Name,
+				dst:     dst,
+				label:   label,
+				current: current,
+			})
+			if approve && event.Condition != "" {
+				d.transitions = append(d.transitions, diagramTransition{
+					src:   src.Name,
+					dst:   event.Dst.Name,
+					label: fmt.Sprintf("skip if not [%s]", event.Condition),
+					skip:  true,
+				})
+			}
+		}
+	}
+	// start event is sort=0, the process starts from its destination(submitted)
+	d.start = events[0].Dst.Name
+	d.addState(d.start)
+	for _, state := range d.states {
+		if !outgoing[state] && !d.forks[state] && !d.joins[state] {
+			d.end = state
+		}
+	}
+	return d
+}
+
+// fork => branches => join => destination, return fork state
+func (d *diagram) addBranches(event Event) string {
+	fork := fmt.Sprintf("%s fork", event.Name.Name)
+	join := fmt.Sprintf("%s join", event.Name.Name)
+	d.addState(fork)
+	d.forks[fork] = true
+	for _, branch := range event.Branches {
+		state := fmt.Sprintf("%s: %s", event.Name.Name, branch.Name)
+		d.addState(state)
+		label := ""
+		if branch.Condition != "" {
+			label = fmt.Sprintf("[%s]", branch.Condition)
+		}
+		d.transitions = append(d.transitions, diagramTransition{
+			src:   fork,
+			dst:   state,
+			label: label,
+		}, diagramTransition{
+			src: state,
+			dst: join,
+		})
+	}
+	d.addState(join)
+	d.joins[join] = true
+	label := "all of"
+	if event.Join == constant.FsmJoinAny {
+		label = "any of"
+	}
+	d.addState(event.Dst.Name)
+	d.transitions = append(d.transitions, diagramTransition{
+		src:   join,
+		dst:   event.Dst.Name,
+		label: label,
+	})
+	return fork
+}
+
+// countersign rule of approval, refuse rule of refusal
+func eventRule(event Event, approve bool) string {
+	rule := ""
+	switch event.SignMode {
+	case constant.FsmSignAll:
+		rule = "all sign"
+	case constant.FsmSignQuorum:
+		rule = fmt.Sprintf("quorum %d", event.Quorum)
+		// approvers of roles are unknown until approving
+		if len(event.Roles) == 0 {
+			rule = fmt.Sprintf("quorum %d/%d", event.Quorum, len(event.Users))
+		}
+	default:
+		return ""
+	}
+	if approve {
+		return rule
+	}
+	if event.RefuseMode == constant.FsmRefuseMajority {
+		return "majority refuse"
+	}
+	return "one refuse"
+}
+
+func (d *diagram) addState(name string) {
+	for _, item := range d.states {
+		if item == name {
+			return
+		}
+	}
+	d.states = append(d.states, name)
+}
+
+func (d diagram) dot() string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("digraph %s {\n", dotQuote(d.name)))
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box, style=rounded];\n")
+	for _, state := range d.states {
+		if d.forks[state] || d.joins[state] {
+			b.WriteString(fmt.Sprintf("  %s [shape=box, label=\"\", style=filled, fillcolor=black, width=0.05, height=0.5];\n", dotQuote(state)))
+			continue
+		}
+		attrs := make([]string, 0)
+		if state == d.start || state == d.end {
+			attrs = append(attrs, "peripheries=2")
+		}
+		if d.current[state] {
+			attrs = append(attrs, `style="rounded,filled"`, "fillcolor=orange")
+		} else if d.visited[state] {
+			attrs = append(attrs, `style="rounded,filled"`, "fillcolor=lightgrey")
+		}
+		b.WriteString(fmt.Sprintf("  %s", dotQuote(state)))
+		if len(attrs) > 0 {
+			b.WriteString(fmt.Sprintf(" [%s]", strings.Join(attrs, ", ")))
+		}
+		b.WriteString(";\n")
+	}
+	for _, item := range d.transitions {
+		b.WriteString(fmt.Sprintf("  %s -> %s [label=%s", dotQuote(item.src), dotQuote(item.dst), dotQuote(item.label)))
+		if item.current {
+			b.WriteString(", color=orange, penwidth=2")
+		}
+		if item.skip {
+			b.WriteString(", style=dashed")
+		}
+		b.WriteString("];\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func (d diagram) mermaid() string {
+	ids := make(map[string]string, len(d.states))
+	var b strings.Builder
+	b.WriteString("stateDiagram-v2\n")
+	for i, state := range d.states {
+		ids[state] = fmt.Sprintf("s%d", i)
+		if d.forks[state] {
+			b.WriteString(fmt.Sprintf("  state %s <<fork>>\n", ids[state]))
+		} else if d.joins[state] {
+			b.WriteString(fmt.Sprintf("  state %s <<join>>\n", ids[state]))
+		} else {
+			b.WriteString(fmt.Sprintf("  state %s as %s\n", mermaidQuote(state), ids[state]))
+		}
+	}
+	b.WriteString(fmt.Sprintf("  [*] --> %s\n", ids[d.start]))
+	for _, item := range d.transitions {
+		b.WriteString(fmt.Sprintf("  %s --> %s", ids[item.src], ids[item.dst]))
+		if item.label != "" {
+			b.WriteString(fmt.Sprintf(": %s", mermaidLabel(item.label)))
+		}
+		b.WriteString("\n")
+	}
+	if d.end != "" {
+		b.WriteString(fmt.Sprintf("  %s --> [*]\n", ids[d.end]))
+	}
+	visited := make([]string, 0)
+	current := make([]string, 0)
+	for _, state := range d.states {
+		if d.current[state] {
+			current = append(current, ids[state])
+		} else if d.visited[state] {
+			visited = append(visited, ids[state])
+		}
+	}
+	if len(visited) > 0 {
+		b.WriteString("  classDef visited fill:#d3d3d3\n")
+		b.WriteString(fmt.Sprintf("  class %s visited\n", strings.Join(visited, ",")))
+	}
+	if len(current) > 0 {
+		b.WriteString("  classDef current fill:#ffa500\n")
+		b.WriteString(fmt.Sprintf("  class %s current\n", strings.Join(current, ",")))
+	}
+	return b.String()
+}
+
+func dotQuote(s string) string {
+	return fmt.Sprintf(`"%s"`, strings.ReplaceAll(s, `"`, `\"`))
+}
+
+func mermaidQuote(s string) string {
+	return fmt.Sprintf(`"%s"`, strings.ReplaceAll(s, `"`, "'"))
+}
+
+// mermaid transition label cannot contain colon
+func mermaidLabel(s string) string {
+	return strings.ReplaceAll(s, ":", " ")
+}
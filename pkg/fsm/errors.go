@@ -1,8 +1,13 @@
 package fsm
 
-import "fmt"
+import (
+	"fmt"
+	"gorm.io/gorm"
+)
 
 var (
+	// same as gorm.ErrRecordNotFound, so that errors.Is works for all stores
+	ErrRecordNotFound            = gorm.ErrRecordNotFound
 	ErrDbNil                     = fmt.Errorf("db instance is empty")
 	ErrTransitionNil             = fmt.Errorf("transition handler is empty")
 	ErrEventsNil                 = fmt.Errorf("events is empty")
@@ -15,4 +20,17 @@ var (
 	ErrNoEditLogDetailPermission = fmt.Errorf("no permission to edit log detail")
 	ErrOnlySubmitterCancel       = fmt.Errorf("only the submitter can cancel")
 	ErrStartedCannotCancel       = fmt.Errorf("the process is already in progress and cannot be cancelled halfway")
+	ErrCondition                 = fmt.Errorf("illegal condition expression")
+	ErrJoin                      = fmt.Errorf("illegal branch join mode")
+	ErrExpireAction              = fmt.Errorf("illegal expire action")
+	ErrEscalateNil               = fmt.Errorf("escalate roles/users is empty")
+	ErrQueueNil                  = fmt.Errorf("delay queue is empty")
+	ErrDelegation                = fmt.Errorf("illegal delegation")
+	ErrTransferTargetNil         = fmt.Errorf("transfer target roles/users is empty")
+	ErrTransferTargetNotUnique   = fmt.Errorf("countersign seat or parallel branch can only be transferred to one role/user")
+	ErrDiagramFormat             = fmt.Errorf("illegal diagram format")
+	ErrSignMode                  = fmt.Errorf("illegal sign mode")
+	ErrQuorum                    = fmt.Errorf("illegal quorum")
+	ErrRefuseMode                = fmt.Errorf("illegal refuse mode")
+	ErrRepeatVote                = fmt.Errorf("approver has already voted")
 )
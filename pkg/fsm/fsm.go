@@ -2,6 +2,7 @@ package fsm
 
 import (
 	"fmt"
+	"github.com/golang-module/carbon/v2"
 	"github.com/looplab/fsm"
 	"github.com/piupuer/go-helper/pkg/constant"
 	"github.com/piupuer/go-helper/pkg/log"
@@ -15,9 +16,11 @@ import (
 )
 
 type Fsm struct {
-	ops     Options
-	session *gorm.DB
-	Error   error
+	ops   Options
+	store Store
+	// delay tasks enqueued after transaction committed
+	afterCommit *[]func() error
+	Error       error
 }
 
 // mysql DDL migrate rollback is not supported, Migrate before New
@@ -27,17 +30,7 @@ func Migrate(options ...func(*Options)) (err error) {
 		err = fs.Error
 		return
 	}
-	session := fs.initSession()
-	err = session.AutoMigrate(
-		new(Machine),
-		new(Event),
-		new(User),
-		new(EventSrcItemRelation),
-		new(EventUserRelation),
-		new(EventItem),
-		new(Log),
-		new(LogApprovalUserRelation),
-	)
+	err = fs.store.Migrate()
 	return
 }
 
@@ -49,8 +42,10 @@ func New(options ...func(*Options)) *Fsm {
 	fs := &Fsm{
 		ops: *ops,
 	}
-	if ops.db != nil {
-		fs.session = fs.initSession()
+	if ops.store != nil {
+		fs.store = ops.store
+	} else if ops.db != nil {
+		fs.store = newGormStore(fs.initSession())
 	} else {
 		fs.Error = errors.WithStack(ErrDbNil)
 	}
@@ -61,31 +56,29 @@ func (fs Fsm) DeleteMachineByIds(ids []uint) error {
 	if fs.Error != nil {
 		return fs.Error
 	}
-	machines := make([]Machine, 0)
-	fs.session.
-		Model(&Machine{}).
-		Where("id IN (?)", ids).
-		Preload("Events").
-		Find(&machines)
-	eventIds := make([]uint, 0)
-	for _, machine := range machines {
-		for _, event := range machine.Events {
-			eventIds = append(eventIds, event.Id)
+	for _, id := range ids {
+		machine, err := fs.store.GetMachine(id)
+		if errors.Is(err, ErrRecordNotFound) {
+			continue
 		}
-	}
-	if len(eventIds) > 0 {
-		logs := make([]Log, 0)
-		fs.session.
-			Model(&Log{}).
-			Where("next_event_id IN (?)", eventIds).
-			Find(&logs)
-		if len(logs) > 0 {
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		page := resp.Page{
+			PageSize: constant.One,
+		}
+		_, err = fs.store.FindLog(LogQuery{
+			Category: machine.Category,
+			Page:     &page,
+		})
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if page.Total > 0 {
 			return errors.Errorf("remove machine so that old approve log cannot be displayed normally")
 		}
 	}
-	return fs.session.
-		Where("id IN (?)", ids).
-		Delete(&Machine{}).Error
+	return fs.store.DeleteMachine(ids)
 }
 
 func (fs Fsm) CreateMachine(r req.FsmCreateMachine) (*Machine, error) {
@@ -95,75 +88,107 @@ func (fs Fsm) CreateMachine(r req.FsmCreateMachine) (*Machine, error) {
 	var machine Machine
 	utils.Struct2StructByJson(r, &machine)
 	// category is unique
-	var count int64
-	fs.session.
-		Model(&machine).
-		Where("category = ?", machine.Category).
-		Count(&count)
-	if count > 0 {
+	_, err := fs.store.GetMachineByCategory(machine.Category)
+	if err == nil {
 		return nil, errors.Errorf("fsm category %d already exists", machine.Category)
 	}
+	if !errors.Is(err, ErrRecordNotFound) {
+		return nil, errors.WithStack(err)
+	}
 	// save json for query
 	machine.EventsJson = utils.Struct2Json(r.Levels)
-	err := fs.session.Create(&machine).Error
+	machine.Version = constant.One
+	err = fs.store.CreateMachine(&machine)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 	// batch fsm event
-	err = fs.batchCreateEvent(machine.Id, r.Levels)
+	err = fs.batchCreateEvent(machine.Id, machine.Version, r.Levels)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	_, err = fs.findEventDesc(machine.Id, machine.Version)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	_, err = fs.findEventDesc(machine.Id)
+	err = fs.saveMachineVersion(machine)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 	return &machine, nil
 }
 
+// every update creates a new version, pending logs are still pinned to their old version(use MigrateLog to move them),
+// they are cancelled like before versioning if WithCancelLogOnUpdate
 func (fs Fsm) UpdateMachineById(id uint, r req.FsmUpdateMachine) (*Machine, error) {
 	if fs.Error != nil {
 		return nil, fs.Error
 	}
-	var oldMachine Machine
-	err := fs.session.
-		Model(&Machine{}).
-		Where("id = ?", id).
-		First(&oldMachine).Error
+	var machine *Machine
+	err := fs.transaction(func(f Fsm) (err error) {
+		machine, err = f.updateMachineById(id, r)
+		return
+	})
+	return machine, err
+}
+
+func (fs Fsm) updateMachineById(id uint, r req.FsmUpdateMachine) (*Machine, error) {
+	machine, err := fs.store.GetMachine(id)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	// cancel log when machine config change
-	err = fs.CancelLog(oldMachine.Category)
+	if fs.ops.cancelLogOnUpdate {
+		err = fs.cancelLog(machine.Category)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	// machine created before versioning has no snapshot, save it before the first new version
+	_, err = fs.store.GetMachineVersion(machine.Id, machine.Version)
+	if errors.Is(err, ErrRecordNotFound) {
+		err = fs.saveMachineVersion(*machine)
+	}
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	levels := make([]req.FsmCreateEvent, len(r.Levels))
-	copy(levels, r.Levels)
-	r.Levels = make([]req.FsmCreateEvent, 0)
-	eventsJson := utils.Struct2Json(levels)
-	m := make(map[string]interface{}, 0)
-	utils.CompareDiff2SnakeKey(oldMachine, r, &m)
-	if oldMachine.EventsJson != eventsJson {
-		m["events_json"] = eventsJson
-	}
-	err = fs.session.
-		Model(&Machine{}).
-		Where("id = ?", id).
-		Updates(&m).Error
+	if r.Category != nil {
+		machine.Category = uint(*r.Category)
+	}
+	if r.Name != nil {
+		machine.Name = *r.Name
+	}
+	if r.SubmitterName != nil {
+		machine.SubmitterName = *r.SubmitterName
+	}
+	if r.SubmitterEditFields != nil {
+		machine.SubmitterEditFields = *r.SubmitterEditFields
+	}
+	if r.SubmitterConfirm != nil {
+		machine.SubmitterConfirm = uint(*r.SubmitterConfirm)
+	}
+	if r.SubmitterConfirmEditFields != nil {
+		machine.SubmitterConfirmEditFields = *r.SubmitterConfirmEditFields
+	}
+	machine.EventsJson = utils.Struct2Json(r.Levels)
+	machine.Version++
+	err = fs.store.UpdateMachine(machine)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 	// batch fsm event
-	err = fs.batchCreateEvent(oldMachine.Id, levels)
+	err = fs.batchCreateEvent(machine.Id, machine.Version, r.Levels)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	_, err = fs.findEventDesc(machine.Id, machine.Version)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	_, err = fs.findEventDesc(oldMachine.Id)
+	err = fs.saveMachineVersion(*machine)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	return &oldMachine, nil
+	return machine, nil
 }
 
 // =======================================================
@@ -174,6 +199,15 @@ func (fs Fsm) SubmitLog(r req.FsmCreateLog) ([]EventItem, error) {
 	if fs.Error != nil {
 		return nil, fs.Error
 	}
+	var items []EventItem
+	err := fs.transaction(func(f Fsm) (err error) {
+		items, err = f.submitLog(r)
+		return
+	})
+	return items, err
+}
+
+func (fs Fsm) submitLog(r req.FsmCreateLog) ([]EventItem, error) {
 	machine, err := fs.GetMachineByCategory(uint(r.Category))
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -183,44 +217,88 @@ func (fs Fsm) SubmitLog(r req.FsmCreateLog) ([]EventItem, error) {
 		Category: r.Category,
 		Uuid:     r.Uuid,
 	})
-	if !errors.Is(err, gorm.ErrRecordNotFound) {
+	if errors.Is(err, ErrParams) {
+		return nil, err
+	}
+	if !errors.Is(err, ErrRecordNotFound) {
 		return nil, errors.WithStack(ErrRepeatSubmit)
 	}
-	startEvent, err := fs.getStartEvent(machine.Id)
+	err = fs.beforeSubmit(r)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	startEvent, err := fs.getStartEvent(machine.Id, machine.Version)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	params := r.Params
+	if params == nil {
+		params = make(map[string]interface{})
+	}
 
 	// first create log
 	var log Log
 	log.Category = uint(r.Category)
 	log.Uuid = r.Uuid
-	nextEvent, err := fs.getNextEvent(machine.Id, startEvent.Level)
+	log.Version = machine.Version
+	log.Params = utils.Struct2Json(params)
+	// levels whose condition is not satisfied will be skipped
+	nextEvent, progress, err := fs.routeEvent(machine.Id, machine.Version, startEvent.Level, constant.FsmLogStatusApproved, startEvent.Dst.Name, params)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	log.ProgressId = startEvent.DstId
-	log.CanApprovalRoles = nextEvent.Roles
-	log.CanApprovalUsers = nextEvent.Users
 	log.SubmitterRoleId = r.SubmitterRoleId
 	log.SubmitterUserId = r.SubmitterUserId
 	log.PrevDetail = startEvent.Dst.Name
-	log.Detail = nextEvent.Name.Name
 	log.CurrentEventId = startEvent.Id
-	log.NextEventId = nextEvent.Id
-	err = fs.session.Create(&log).Error
+	items := []EventItem{
+		startEvent.Dst,
+	}
+	if nextEvent != nil {
+		progressItem, err := fs.getEventItemByName(progress)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		log.ProgressId = progressItem.Id
+		fs.bindApprover(&log, *nextEvent, params)
+		log.Detail = nextEvent.Name.Name
+		log.NextEventId = nextEvent.Id
+		items = append(items, nextEvent.Name)
+	} else {
+		// all levels are skipped
+		log.Approved = constant.FsmLogStatusApproved
+		log.Detail = constant.FsmMsgEnded
+	}
+	err = fs.store.CreateLog(&log)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	if nextEvent != nil {
+		err = fs.scheduleSla(log, *nextEvent, true)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
 
-	return []EventItem{
-		startEvent.Dst,
-		nextEvent.Name,
-	}, nil
+	return items, nil
 }
 
 // start approve log
 func (fs Fsm) ApproveLog(r req.FsmApproveLog) (*resp.FsmApprovalLog, error) {
+	if fs.Error != nil {
+		return nil, fs.Error
+	}
+	var rp *resp.FsmApprovalLog
+	err := fs.transaction(func(f Fsm) (err error) {
+		rp, err = f.approveLog(r, false)
+		return
+	})
+	return rp, err
+}
+
+// approve log, auto=true means approved by system(timeout etc.), approver permission will not be checked
+func (fs Fsm) approveLog(r req.FsmApproveLog, auto bool) (*resp.FsmApprovalLog, error) {
 	if fs.Error != nil {
 		return nil, fs.Error
 	}
@@ -233,39 +311,80 @@ func (fs Fsm) ApproveLog(r req.FsmApproveLog) (*resp.FsmApprovalLog, error) {
 		Uuid:     r.Uuid,
 		Category: uint(r.Category),
 	}
-	// check current user/role permission
-	oldLog, err := fs.CheckLogPermission(req.FsmPermissionLog{
-		Category:       r.Category,
-		Uuid:           r.Uuid,
-		ApprovalRoleId: r.ApprovalRoleId,
-		ApprovalUserId: r.ApprovalUserId,
-		Approved:       approved,
-	})
+	var oldLog *Log
+	if auto {
+		oldLog, err = fs.getLastPendingLog(req.FsmLog{
+			Category: r.Category,
+			Uuid:     r.Uuid,
+		})
+	} else {
+		// check current user/role permission
+		oldLog, err = fs.CheckLogPermission(req.FsmPermissionLog{
+			Category:       r.Category,
+			Uuid:           r.Uuid,
+			ApprovalRoleId: r.ApprovalRoleId,
+			ApprovalUserId: r.ApprovalUserId,
+			Approved:       approved,
+		})
+	}
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
 	// submitter cancel
 	if approved == constant.FsmLogStatusCancelled {
-		m := make(map[string]interface{}, 0)
-		m["approved"] = constant.FsmLogStatusCancelled
-		m["approval_role_id"] = r.ApprovalRoleId
-		m["approval_user_id"] = r.ApprovalUserId
-		m["approval_opinion"] = r.ApprovalOpinion
-		m["next_event_id"] = constant.Zero
-		m["detail"] = constant.FsmMsgSubmitterCancel
+		oldLog.Approved = constant.FsmLogStatusCancelled
+		oldLog.ApprovalRoleId = r.ApprovalRoleId
+		oldLog.ApprovalUserId = r.ApprovalUserId
+		oldLog.ApprovalOpinion = r.ApprovalOpinion
+		oldLog.NextEventId = constant.Zero
+		oldLog.Detail = constant.FsmMsgSubmitterCancel
 		rp.Cancel = constant.One
-		err = fs.session.
-			Model(&Log{}).
-			Where("id = ?", oldLog.Id).
-			Updates(&m).Error
+		err = fs.store.UpdateLog(oldLog)
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
-		return &rp, nil
+		return &rp, fs.afterChange(approved, rp)
+	}
+	// business checks of the approval
+	err = fs.beforeApprove(*oldLog, r)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	// parallel branches/countersign vote, the log will not move on until the join condition is met
+	if auto && (len(oldLog.Branches) > 0 || len(oldLog.Votes) > 0) {
+		// system decides the whole level
+		err = fs.cancelPendingBranch(*oldLog)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		err = fs.cancelPendingVote(*oldLog)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	} else if len(oldLog.Branches) > 0 {
+		var joined bool
+		approved, joined, err = fs.approveBranch(*oldLog, r)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if !joined {
+			return &rp, nil
+		}
+	} else if len(oldLog.Votes) > 0 {
+		var joined bool
+		approved, joined, err = fs.approveVote(*oldLog, r)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if !joined {
+			return &rp, nil
+		}
 	}
 
-	desc, err := fs.findEventDesc(machine.Id)
+	// log is pinned to the machine version which it submitted
+	desc, err := fs.findEventDesc(machine.Id, oldLog.Version)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -297,35 +416,38 @@ func (fs Fsm) ApproveLog(r req.FsmApproveLog) (*resp.FsmApprovalLog, error) {
 	}
 	nextName := getNextItemName(approved, eventName)
 	f.SetState(nextName)
-	event, err := fs.getEvent(machine.Id, eventName)
-	if err != nil {
-		return nil, errors.WithStack(err)
-	}
-	progressItem, err := fs.getEventItemByName(nextName)
+	event, err := fs.getEvent(machine.Id, oldLog.Version, eventName)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	params := make(map[string]interface{})
+	utils.Json2Struct(oldLog.Params, &params)
 	var newLog Log
 	newLog.Category = uint(r.Category)
 	newLog.Uuid = r.Uuid
+	newLog.Version = oldLog.Version
 	newLog.SubmitterRoleId = oldLog.SubmitterRoleId
 	newLog.SubmitterUserId = oldLog.SubmitterUserId
 	newLog.PrevDetail = nextName
 	newLog.CurrentEventId = event.Id
+	newLog.Params = oldLog.Params
+	var nextEvent *Event
+	progress := nextName
 	if len(f.AvailableTransitions()) != 0 {
-		// bind next approver
-		var nextEvent *Event
-		if approved == constant.FsmLogStatusApproved {
-			nextEvent, err = fs.getNextEvent(machine.Id, event.Level)
-		} else {
-			nextEvent, err = fs.getPrevEvent(machine.Id, event.Level)
+		// levels whose condition is not satisfied will be skipped
+		nextEvent, progress, err = fs.routeEvent(machine.Id, oldLog.Version, event.Level, approved, nextName, params)
+		if err != nil {
+			return nil, errors.WithStack(err)
 		}
+	}
+	if nextEvent != nil {
+		progressItem, err := fs.getEventItemByName(progress)
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
 		// no users/roles, maybe submitter resubmit/confirm
 		noUser := false
-		if len(nextEvent.Roles) == 0 && len(nextEvent.Users) == 0 {
+		if len(nextEvent.Roles) == 0 && len(nextEvent.Users) == 0 && len(nextEvent.Branches) == 0 {
 			noUser = true
 			if strings.HasSuffix(nextEvent.Name.Name, constant.FsmSuffixConfirm) {
 				rp.Confirm = constant.One
@@ -353,8 +475,7 @@ func (fs Fsm) ApproveLog(r req.FsmApproveLog) (*resp.FsmApprovalLog, error) {
 				},
 			}
 		} else {
-			newLog.CanApprovalRoles = nextEvent.Roles
-			newLog.CanApprovalUsers = nextEvent.Users
+			fs.bindApprover(&newLog, *nextEvent, params)
 		}
 		newLog.Detail = nextEvent.Name.Name
 	} else {
@@ -362,23 +483,29 @@ func (fs Fsm) ApproveLog(r req.FsmApproveLog) (*resp.FsmApprovalLog, error) {
 		newLog.Approved = constant.FsmLogStatusApproved
 		newLog.Detail = constant.FsmMsgEnded
 	}
-	err = fs.session.Create(&newLog).Error
+	err = fs.store.CreateLog(&newLog)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	m := make(map[string]interface{}, 0)
-	m["approved"] = constant.FsmLogStatusApproved
+	if nextEvent != nil {
+		err = fs.scheduleSla(newLog, *nextEvent, true)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	oldLog.Approved = constant.FsmLogStatusApproved
 	if approved == constant.FsmLogStatusRefused {
-		m["approved"] = constant.FsmLogStatusRefused
+		oldLog.Approved = constant.FsmLogStatusRefused
 	}
-	m["approval_role_id"] = r.ApprovalRoleId
-	m["approval_user_id"] = r.ApprovalUserId
-	m["approval_opinion"] = r.ApprovalOpinion
+	oldLog.ApprovalRoleId = r.ApprovalRoleId
+	oldLog.ApprovalUserId = r.ApprovalUserId
+	oldLog.ApprovalOpinion = r.ApprovalOpinion
 	// update oldLog approved
-	err = fs.session.
-		Model(&Log{}).
-		Where("id = ?", oldLog.Id).
-		Updates(&m).Error
+	err = fs.store.UpdateLog(oldLog)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	err = fs.afterChange(approved, rp)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -395,16 +522,19 @@ func (fs Fsm) CancelLog(category uint) error {
 	if fs.Error != nil {
 		return fs.Error
 	}
-	m := make(map[string]interface{}, 0)
-	m["approved"] = constant.FsmLogStatusCancelled
-	m["next_event_id"] = constant.Zero
-	m["detail"] = constant.FsmMsgConfigChanged
-	q := fs.session.
-		Model(&Log{}).
-		Where("category = ?", category).
-		Where("approved = ?", constant.FsmLogStatusWaiting)
-	oldLogs := make([]Log, 0)
-	q.Find(&oldLogs)
+	return fs.transaction(func(f Fsm) error {
+		return f.cancelLog(category)
+	})
+}
+
+func (fs Fsm) cancelLog(category uint) error {
+	oldLogs, err := fs.store.FindLog(LogQuery{
+		Category: category,
+		Waiting:  true,
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
 	list := make([]resp.FsmApprovalLog, 0)
 	for i, l := 0, len(oldLogs); i < l; i++ {
 		list = append(list, resp.FsmApprovalLog{
@@ -414,11 +544,20 @@ func (fs Fsm) CancelLog(category uint) error {
 		})
 	}
 	// status transition
-	err := fs.ops.transition(fs.ops.ctx, list...)
+	err = fs.ops.transition(fs.ops.ctx, list...)
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	err = q.Updates(&m).Error
+	for i := range oldLogs {
+		oldLogs[i].Approved = constant.FsmLogStatusCancelled
+		oldLogs[i].NextEventId = constant.Zero
+		oldLogs[i].Detail = constant.FsmMsgConfigChanged
+		err = fs.store.UpdateLog(&oldLogs[i])
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	err = fs.afterChange(constant.FsmLogStatusCancelled, list...)
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -434,21 +573,22 @@ func (fs Fsm) CancelLogByUuids(r req.FsmCancelLog) error {
 	if fs.Error != nil {
 		return fs.Error
 	}
+	return fs.transaction(func(f Fsm) error {
+		return f.cancelLogByUuids(r)
+	})
+}
+
+func (fs Fsm) cancelLogByUuids(r req.FsmCancelLog) error {
 	if len(r.Uuids) == 0 {
 		return errors.Wrap(ErrParams, "uuids")
 	}
-	m := make(map[string]interface{}, 0)
-	m["approved"] = constant.FsmLogStatusCancelled
-	m["approval_role_id"] = r.ApprovalRoleId
-	m["approval_user_id"] = r.ApprovalUserId
-	m["next_event_id"] = constant.Zero
-	m["detail"] = constant.FsmMsgManualCancel
-	q := fs.session.
-		Model(&Log{}).
-		Where("uuid IN (?)", r.Uuids).
-		Where("approved = ?", constant.FsmLogStatusWaiting)
-	oldLogs := make([]Log, 0)
-	q.Find(&oldLogs)
+	oldLogs, err := fs.store.FindLog(LogQuery{
+		Uuids:   r.Uuids,
+		Waiting: true,
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
 	if len(oldLogs) == 0 {
 		return errors.WithStack(ErrNoPermissionOrEnded)
 	}
@@ -460,7 +600,18 @@ func (fs Fsm) CancelLogByUuids(r req.FsmCancelLog) error {
 			Cancel:   constant.One,
 		})
 	}
-	err := q.Updates(&m).Error
+	for i := range oldLogs {
+		oldLogs[i].Approved = constant.FsmLogStatusCancelled
+		oldLogs[i].ApprovalRoleId = r.ApprovalRoleId
+		oldLogs[i].ApprovalUserId = r.ApprovalUserId
+		oldLogs[i].NextEventId = constant.Zero
+		oldLogs[i].Detail = constant.FsmMsgManualCancel
+		err = fs.store.UpdateLog(&oldLogs[i])
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	err = fs.afterChange(constant.FsmLogStatusCancelled, list...)
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -506,10 +657,16 @@ func (fs Fsm) CheckLogPermission(r req.FsmPermissionLog) (*Log, error) {
 	for _, user := range log.CanApprovalUsers {
 		users = append(users, user.Id)
 	}
-	if !utils.Contains(roles, r.ApprovalRoleId) && !utils.Contains(users, r.ApprovalUserId) {
-		return nil, errors.WithStack(ErrNoPermissionApprove)
+	if utils.Contains(roles, r.ApprovalRoleId) {
+		return log, nil
 	}
-	return log, nil
+	// approver can act for delegators
+	for _, id := range fs.getApproverUserIds(log.Category, r.ApprovalUserId) {
+		if utils.Contains(users, id) {
+			return log, nil
+		}
+	}
+	return nil, errors.WithStack(ErrNoPermissionApprove)
 }
 
 // check verify whether the current user/role has permission to edit log detail
@@ -544,11 +701,17 @@ func (fs Fsm) CheckEditLogDetailPermission(r req.FsmCheckEditLogDetailPermission
 		if err != nil {
 			return errors.WithStack(err)
 		}
+		// submitter fields of the version which log is pinned to
+		var version *MachineVersion
+		version, err = fs.getMachineVersion(*machine, log.Version)
+		if err != nil {
+			return errors.WithStack(err)
+		}
 		edit = true
 		if submitter {
-			editFields = machine.SubmitterEditFields
+			editFields = version.SubmitterEditFields
 		} else {
-			editFields = machine.SubmitterConfirmEditFields
+			editFields = version.SubmitterConfirmEditFields
 		}
 	} else {
 		edit = log.NextEvent.Edit == constant.One
@@ -575,15 +738,11 @@ func (fs Fsm) GetMachineByCategory(category uint) (*Machine, error) {
 	if fs.Error != nil {
 		return nil, fs.Error
 	}
-	var machine Machine
-	err := fs.session.
-		Model(&Machine{}).
-		Where("category = ?", category).
-		First(&machine).Error
+	machine, err := fs.store.GetMachineByCategory(category)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	return &machine, nil
+	return machine, nil
 }
 
 // find machines
@@ -591,42 +750,10 @@ func (fs Fsm) FindMachine(r *req.FsmMachine) ([]resp.FsmMachine, error) {
 	if fs.Error != nil {
 		return nil, fs.Error
 	}
-	list := make([]Machine, 0)
-	q := fs.session.Model(&Machine{})
-	name := strings.TrimSpace(r.Name)
-	if r.Category != nil {
-		q.Where("category = ?", *r.Category)
-	}
-	if name != "" {
-		q.Where("name LIKE ?", fmt.Sprintf("%%%s%%", name))
-	}
-	submitterName := strings.TrimSpace(r.SubmitterName)
-	if submitterName != "" {
-		q.Where("submitter_name LIKE ?", fmt.Sprintf("%%%s%%", submitterName))
-	}
-	if r.SubmitterConfirm != nil {
-		q.Where("submitter_confirm = ?", *r.SubmitterConfirm)
-	}
-	page := &r.Page
-	countCache := false
-	if page.CountCache != nil {
-		countCache = *page.CountCache
-	}
-	if !page.NoPagination {
-		if !page.SkipCount {
-			q.Count(&page.Total)
-		}
-		if page.Total > 0 || page.SkipCount {
-			limit, offset := page.GetLimit()
-			q.Limit(limit).Offset(offset).Find(&list)
-		}
-	} else {
-		// no pagination
-		q.Find(&list)
-		page.Total = int64(len(list))
-		page.GetLimit()
+	list, err := fs.store.FindMachine(r)
+	if err != nil {
+		return nil, errors.WithStack(err)
 	}
-	page.CountCache = &countCache
 	newList := make([]resp.FsmMachine, 0)
 	utils.Struct2StructByJson(list, &newList)
 	return newList, nil
@@ -637,20 +764,16 @@ func (fs Fsm) FindLog(r req.FsmLog) ([]Log, error) {
 	if fs.Error != nil {
 		return nil, fs.Error
 	}
-	var logs []Log
-	fs.session.
-		Preload("CurrentEvent").
-		Preload("CurrentEvent.Roles").
-		Preload("CurrentEvent.Users").
-		Preload("NextEvent").
-		Preload("NextEvent.Roles").
-		Preload("NextEvent.Users").
-		Preload("NextEvent.Name").
-		Preload("CanApprovalRoles").
-		Preload("CanApprovalUsers").
-		Where("category = ?", r.Category).
-		Where("uuid = ?", r.Uuid).
-		Find(&logs)
+	if uint(r.Category) == constant.Zero || r.Uuid == "" {
+		return nil, errors.WithStack(ErrParams)
+	}
+	logs, err := fs.store.FindLog(LogQuery{
+		Category: uint(r.Category),
+		Uuid:     r.Uuid,
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
 	return logs, nil
 }
 
@@ -668,6 +791,10 @@ func (fs Fsm) FindLogTrack(logs []Log) ([]resp.FsmLogTrack, error) {
 		prevApproved := constant.FsmLogStatusWaiting
 		prevCancel := constant.Zero
 		prevOpinion := ""
+		prevBranches := make([]resp.FsmLogBranch, 0)
+		prevVotes := make([]resp.FsmLogVote, 0)
+		var prevRoleId, prevUserId, prevReminded uint
+		var prevRemindedAt carbon.DateTime
 		end := constant.Zero
 		cancel := constant.Zero
 		if log.Approved == constant.FsmLogStatusCancelled {
@@ -679,6 +806,12 @@ func (fs Fsm) FindLogTrack(logs []Log) ([]resp.FsmLogTrack, error) {
 				prevCancel = constant.One
 			}
 			prevOpinion = logs[i-1].ApprovalOpinion
+			prevBranches = getLogBranches(logs[i-1])
+			prevVotes = getLogVotes(logs[i-1])
+			prevRoleId = logs[i-1].ApprovalRoleId
+			prevUserId = logs[i-1].ApprovalUserId
+			prevReminded = logs[i-1].Reminded
+			prevRemindedAt = logs[i-1].RemindedAt
 		}
 		if i == l-1 && log.NextEventId == constant.Zero {
 			end = constant.One
@@ -689,20 +822,32 @@ func (fs Fsm) FindLogTrack(logs []Log) ([]resp.FsmLogTrack, error) {
 					CreatedAt: log.CreatedAt,
 					UpdatedAt: log.UpdatedAt,
 				},
-				Name:    log.PrevDetail,
-				Opinion: prevOpinion,
-				Status:  prevApproved,
-				Cancel:  prevCancel,
+				Name:           log.PrevDetail,
+				Opinion:        prevOpinion,
+				Status:         prevApproved,
+				Cancel:         prevCancel,
+				Branches:       prevBranches,
+				Votes:          prevVotes,
+				ApprovalRoleId: prevRoleId,
+				ApprovalUserId: prevUserId,
+				Reminded:       prevReminded,
+				RemindedAt:     prevRemindedAt,
 			}, resp.FsmLogTrack{
 				Time: resp.Time{
 					CreatedAt: log.CreatedAt,
 					UpdatedAt: log.UpdatedAt,
 				},
-				Name:    log.Detail,
-				Opinion: log.ApprovalOpinion,
-				Status:  log.Approved,
-				End:     end,
-				Cancel:  cancel,
+				Name:           log.Detail,
+				Opinion:        log.ApprovalOpinion,
+				Status:         log.Approved,
+				End:            end,
+				Cancel:         cancel,
+				Branches:       getLogBranches(log),
+				Votes:          getLogVotes(log),
+				ApprovalRoleId: log.ApprovalRoleId,
+				ApprovalUserId: log.ApprovalUserId,
+				Reminded:       log.Reminded,
+				RemindedAt:     log.RemindedAt,
 			})
 		} else {
 			track = append(track, resp.FsmLogTrack{
@@ -710,18 +855,28 @@ func (fs Fsm) FindLogTrack(logs []Log) ([]resp.FsmLogTrack, error) {
 					CreatedAt: log.CreatedAt,
 					UpdatedAt: log.UpdatedAt,
 				},
-				Name:    log.PrevDetail,
-				Opinion: prevOpinion,
-				Status:  prevApproved,
-				End:     end,
-				Cancel:  cancel,
+				Name:           log.PrevDetail,
+				Opinion:        prevOpinion,
+				Status:         prevApproved,
+				End:            end,
+				Cancel:         cancel,
+				Branches:       prevBranches,
+				Votes:          prevVotes,
+				ApprovalRoleId: prevRoleId,
+				ApprovalUserId: prevUserId,
+				Reminded:       prevReminded,
+				RemindedAt:     prevRemindedAt,
 			})
 		}
 		if i == l-1 && log.Approved == constant.FsmLogStatusWaiting {
 			track = append(track, resp.FsmLogTrack{
-				Name:     logs[i].Detail,
-				Resubmit: log.Resubmit,
-				Confirm:  log.Confirm,
+				Name:       logs[i].Detail,
+				Resubmit:   log.Resubmit,
+				Confirm:    log.Confirm,
+				Branches:   getLogBranches(log),
+				Votes:      getLogVotes(log),
+				Reminded:   log.Reminded,
+				RemindedAt: log.RemindedAt,
 			})
 		}
 	}
@@ -733,52 +888,29 @@ func (fs Fsm) FindPendingLogByApprover(r *req.FsmPendingLog) ([]resp.FsmApprovin
 	if fs.Error != nil {
 		return nil, fs.Error
 	}
-	// get user relation
-	logIds1 := make([]uint, 0)
-	fs.session.
-		Model(&LogApprovalUserRelation{}).
-		Where("user_id = ?", r.ApprovalUserId).
-		Pluck("log_id", &logIds1)
-	// get role relation
-	logIds2 := make([]uint, 0)
-	fs.session.
-		Model(&LogApprovalRoleRelation{}).
-		Where("role_id = ?", r.ApprovalRoleId).
-		Pluck("log_id", &logIds2)
-	list := make([]Log, 0)
-	newList := make([]resp.FsmApprovingLog, 0)
-	ids := append(logIds1, logIds2...)
-	if len(ids) > 0 {
-		q := fs.session.
-			Model(&Log{}).
-			Preload("CanApprovalRoles").
-			Preload("CanApprovalUsers").
-			Where("approved = ?", constant.FsmLogStatusWaiting).
-			Where("id IN (?)", ids)
-		if uint(r.Category) > constant.Zero {
-			q.Where("category = ?", r.Category)
-		}
-		page := &r.Page
-		countCache := false
-		if page.CountCache != nil {
-			countCache = *page.CountCache
-		}
-		if !page.NoPagination {
-			if !page.SkipCount {
-				q.Count(&page.Total)
-			}
-			if page.Total > 0 || page.SkipCount {
-				limit, offset := page.GetLimit()
-				q.Limit(limit).Offset(offset).Find(&list)
-			}
-		} else {
-			// no pagination
-			q.Find(&list)
-			page.Total = int64(len(list))
-			page.GetLimit()
-		}
-		page.CountCache = &countCache
+	approvers := []LogApprover{
+		{
+			RoleId: r.ApprovalRoleId,
+			UserId: r.ApprovalUserId,
+		},
+	}
+	// get delegators user relation
+	for _, item := range fs.getDelegations(uint(r.Category), r.ApprovalUserId) {
+		approvers = append(approvers, LogApprover{
+			Category: item.Category,
+			UserId:   item.UserId,
+		})
+	}
+	list, err := fs.store.FindLog(LogQuery{
+		Category:  uint(r.Category),
+		Waiting:   true,
+		Approvers: approvers,
+		Page:      &r.Page,
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
 	}
+	newList := make([]resp.FsmApprovingLog, 0)
 	utils.Struct2StructByJson(list, &newList)
 	return newList, nil
 }
@@ -791,142 +923,129 @@ func (fs Fsm) getLastPendingLog(r req.FsmLog) (*Log, error) {
 	if fs.Error != nil {
 		return nil, fs.Error
 	}
-	var log Log
-	err := fs.session.
-		Preload("CanApprovalRoles").
-		Preload("CanApprovalUsers").
-		Preload("Progress").
-		Preload("CurrentEvent").
-		Preload("NextEvent").
-		Where("category = ?", r.Category).
-		Where("uuid = ?", r.Uuid).
-		Where("approved = ?", constant.FsmLogStatusWaiting).
-		First(&log).Error
+	// zero value is ignored by LogQuery, empty category/uuid must not match logs of others
+	if uint(r.Category) == constant.Zero || r.Uuid == "" {
+		return nil, errors.WithStack(ErrParams)
+	}
+	logs, err := fs.store.FindLog(LogQuery{
+		Category: uint(r.Category),
+		Uuid:     r.Uuid,
+		Waiting:  true,
+	})
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	return &log, nil
+	if len(logs) == 0 {
+		return nil, errors.WithStack(ErrRecordNotFound)
+	}
+	return &logs[0], nil
 }
 
-func (fs Fsm) getEvent(machineId uint, name string) (*Event, error) {
+func (fs Fsm) getEvent(machineId, version uint, name string) (*Event, error) {
 	if fs.Error != nil {
 		return nil, fs.Error
 	}
-	var events []Event
-	fs.session.
-		Preload("Name").
-		Preload("Dst").
-		Where("machine_id = ?", machineId).
-		Find(&events)
+	events, err := fs.store.FindEvent(machineId, version)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
 	for _, event := range events {
 		if event.Name.Name == name {
 			return &event, nil
 		}
 	}
-	return nil, errors.WithStack(gorm.ErrRecordNotFound)
+	return nil, errors.WithStack(ErrRecordNotFound)
 }
 
 func (fs Fsm) getEventItemByName(name string) (*EventItem, error) {
 	if fs.Error != nil {
 		return nil, fs.Error
 	}
-	var item EventItem
-	err := fs.session.
-		Where("name = ?", name).
-		First(&item).Error
-	return &item, err
+	items, err := fs.store.SaveEventItem([]string{name})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(items) == 0 {
+		return nil, errors.WithStack(ErrRecordNotFound)
+	}
+	return &items[0], nil
 }
 
-func (fs Fsm) getStartEvent(machineId uint) (*Event, error) {
+func (fs Fsm) getStartEvent(machineId, version uint) (*Event, error) {
 	if fs.Error != nil {
 		return nil, fs.Error
 	}
-	var event Event
-	err := fs.session.
-		Preload("Name").
-		Preload("Src").
-		Preload("Dst").
-		Where("machine_id = ?", machineId).
-		Where("sort = ?", constant.Zero).
-		First(&event).Error
-	return &event, err
+	events, err := fs.store.FindEvent(machineId, version)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(events) == 0 || events[0].Sort != constant.Zero {
+		return nil, errors.WithStack(ErrRecordNotFound)
+	}
+	return &events[0], nil
 }
 
-func (fs Fsm) getPrevEvent(machineId uint, level uint) (*Event, error) {
+func (fs Fsm) getPrevEvent(machineId, version, level uint) (*Event, error) {
 	if fs.Error != nil {
 		return nil, fs.Error
 	}
-
-	var events []Event
-	fs.session.
-		Preload("Name").
-		Preload("Src").
-		Preload("Dst").
-		Preload("Roles").
-		Preload("Users").
-		Where("machine_id = ?", machineId).
-		Where("level = ?", level-1).
-		Order("sort").
-		Find(&events)
+	events, err := fs.store.FindEvent(machineId, version)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
 	for _, event := range events {
+		if event.Level != level-1 {
+			continue
+		}
 		if strings.HasSuffix(event.Name.Name, constant.FsmSuffixWaiting) || strings.HasSuffix(event.Name.Name, constant.FsmSuffixResubmit) {
 			return &event, nil
 		}
 	}
-	return nil, errors.WithStack(gorm.ErrRecordNotFound)
+	return nil, errors.WithStack(ErrRecordNotFound)
 }
 
-func (fs Fsm) getNextEvent(machineId uint, level uint) (*Event, error) {
+func (fs Fsm) getNextEvent(machineId, version, level uint) (*Event, error) {
 	if fs.Error != nil {
 		return nil, fs.Error
 	}
-	var events []Event
-	fs.session.
-		Preload("Name").
-		Preload("Src").
-		Preload("Dst").
-		Preload("Roles").
-		Preload("Users").
-		Where("machine_id = ?", machineId).
-		Where("level = ?", level+1).
-		Order("sort").
-		Find(&events)
+	events, err := fs.store.FindEvent(machineId, version)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
 	for _, event := range events {
+		if event.Level != level+1 {
+			continue
+		}
 		if strings.HasSuffix(event.Name.Name, constant.FsmSuffixWaiting) || strings.HasSuffix(event.Name.Name, constant.FsmSuffixConfirm) {
 			return &event, nil
 		}
 	}
-	return nil, errors.WithStack(gorm.ErrRecordNotFound)
+	return nil, errors.WithStack(ErrRecordNotFound)
 }
 
-func (fs Fsm) getEndEvent(machineId uint) (*Event, error) {
+func (fs Fsm) getEndEvent(machineId, version uint) (*Event, error) {
 	if fs.Error != nil {
 		return nil, fs.Error
 	}
-	var event Event
-	err := fs.session.
-		Preload("Name").
-		Preload("Src").
-		Preload("Dst").
-		Where("machine_id = ?", machineId).
-		Order("sort DESC").
-		First(&event).Error
-	return &event, err
+	events, err := fs.store.FindEvent(machineId, version)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(events) == 0 {
+		return nil, errors.WithStack(ErrRecordNotFound)
+	}
+	return &events[len(events)-1], nil
 }
 
-func (fs Fsm) findEventDesc(machineId uint) ([]fsm.EventDesc, error) {
+func (fs Fsm) findEventDesc(machineId, version uint) ([]fsm.EventDesc, error) {
 	if fs.Error != nil {
 		return nil, fs.Error
 	}
-	events := make([]Event, 0)
+	events, err := fs.store.FindEvent(machineId, version)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
 	desc := make([]fsm.EventDesc, 0)
-	fs.session.
-		Preload("Name").
-		Preload("Src").
-		Preload("Dst").
-		Where("machine_id = ?", machineId).
-		Order("sort").
-		Find(&events)
 	for _, event := range events {
 		var src []string
 		for _, item := range event.Src {
@@ -938,7 +1057,7 @@ func (fs Fsm) findEventDesc(machineId uint) ([]fsm.EventDesc, error) {
 			Dst:  event.Dst.Name,
 		})
 	}
-	err := checkEvent(desc)
+	err = checkEvent(desc)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -974,27 +1093,39 @@ func (fs Fsm) findEventDesc(machineId uint) ([]fsm.EventDesc, error) {
 // L2 waiting refuse  / L1 approved               / L2 refused
 // L0 waiting confirm / L2 approved               / L0 confirmed
 // end
-func (fs Fsm) batchCreateEvent(machineId uint, r []req.FsmCreateEvent) (err error) {
+func (fs Fsm) batchCreateEvent(machineId, version uint, r []req.FsmCreateEvent) (err error) {
 	if fs.Error != nil {
 		return fs.Error
 	}
 	if len(r) == 0 {
 		return errors.WithStack(ErrEventsNil)
 	}
-	// clear old machine
-	err = fs.session.
-		Unscoped().
-		Where("machine_id = ?", machineId).
-		Delete(&Event{}).Error
-	if err != nil {
-		return errors.WithStack(err)
+	for _, item := range r {
+		if uint(item.Join) > constant.FsmJoinAny {
+			return errors.Wrap(ErrJoin, item.Name)
+		}
+		if uint(item.ExpireAction) > constant.FsmExpireActionRefuse {
+			return errors.Wrap(ErrExpireAction, item.Name)
+		}
+		if uint(item.ExpireAction) == constant.FsmExpireActionEscalate && len(item.EscalateRoles.Uints()) == 0 && len(item.EscalateUsers.Uints()) == 0 {
+			return errors.Wrap(ErrEscalateNil, item.Name)
+		}
+		err = checkSign(item)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		err = checkCondition(item.Condition)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		for _, branch := range item.Branches {
+			err = checkCondition(branch.Condition)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
 	}
-
-	var machine Machine
-	err = fs.session.
-		Model(&Machine{}).
-		Where("id = ?", machineId).
-		First(&machine).Error
+	machine, err := fs.store.GetMachine(machineId)
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -1096,34 +1227,10 @@ func (fs Fsm) batchCreateEvent(machineId uint, r []req.FsmCreateEvent) (err erro
 
 	// remove repeat name
 	names = utils.RemoveRepeat(names)
-	oldItems := make([]EventItem, 0)
-	fs.session.
-		Where("name IN (?)", names).
-		Find(&oldItems)
-	items := make([]EventItem, 0)
-	for _, name := range names {
-		exists := false
-		for _, item := range oldItems {
-			if name == item.Name {
-				exists = true
-			}
-		}
-		if !exists {
-			items = append(items, EventItem{
-				Name: name,
-			})
-		}
-	}
-	if len(items) > 0 {
-		err = fs.session.Create(&items).Error
-		if err != nil {
-			return errors.WithStack(err)
-		}
+	newItems, err := fs.store.SaveEventItem(names)
+	if err != nil {
+		return errors.WithStack(err)
 	}
-	newItems := make([]EventItem, 0)
-	fs.session.
-		Where("name IN (?)", names).
-		Find(&newItems)
 	events := make([]Event, 0)
 	for i, d := range desc {
 		nameId := uint(0)
@@ -1146,6 +1253,13 @@ func (fs Fsm) batchCreateEvent(machineId uint, r []req.FsmCreateEvent) (err erro
 		editFields := ""
 		roles := make([]Role, 0)
 		users := make([]User, 0)
+		condition := ""
+		join := constant.FsmJoinAll
+		var signMode, quorum, refuseMode uint
+		branches := make([]EventBranch, 0)
+		var timeout, remind, expireAction uint
+		escalateRoles := make([]Role, 0)
+		escalateUsers := make([]User, 0)
 		if i == 0 {
 			// submitter has edit permission
 			edit = constant.One
@@ -1156,8 +1270,26 @@ func (fs Fsm) batchCreateEvent(machineId uint, r []req.FsmCreateEvent) (err erro
 			edit = uint(r[index].Edit)
 			editFields = r[index].EditFields
 			// find roles/users
-			roles = fs.findRole(r[index].Roles.Uints())
-			users = fs.findUser(r[index].Users.Uints())
+			roles = toRoles(r[index].Roles.Uints())
+			users = toUsers(r[index].Users.Uints())
+			condition = r[index].Condition
+			join = uint(r[index].Join)
+			signMode = uint(r[index].SignMode)
+			quorum = uint(r[index].Quorum)
+			refuseMode = uint(r[index].RefuseMode)
+			timeout = uint(r[index].Timeout)
+			remind = uint(r[index].Remind)
+			expireAction = uint(r[index].ExpireAction)
+			escalateRoles = toRoles(r[index].EscalateRoles.Uints())
+			escalateUsers = toUsers(r[index].EscalateUsers.Uints())
+			for _, branch := range r[index].Branches {
+				branches = append(branches, EventBranch{
+					Name:      branch.Name,
+					Condition: branch.Condition,
+					Roles:     toRoles(branch.Roles.Uints()),
+					Users:     toUsers(branch.Users.Uints()),
+				})
+			}
 		} else if i == len(desc)-1 && machine.SubmitterConfirm == constant.One {
 			// save submitter confirm edit fields
 			edit = constant.One
@@ -1165,69 +1297,46 @@ func (fs Fsm) batchCreateEvent(machineId uint, r []req.FsmCreateEvent) (err erro
 		}
 
 		events = append(events, Event{
-			MachineId:  machineId,
-			Sort:       uint(i),
-			Level:      levels[d.Name],
-			NameId:     nameId,
-			Src:        src,
-			DstId:      dstId,
-			Edit:       edit,
-			EditFields: editFields,
-			Roles:      roles,
-			Users:      users,
+			MachineId:     machineId,
+			Version:       version,
+			Sort:          uint(i),
+			Level:         levels[d.Name],
+			NameId:        nameId,
+			Src:           src,
+			DstId:         dstId,
+			Edit:          edit,
+			EditFields:    editFields,
+			Roles:         roles,
+			Users:         users,
+			Condition:     condition,
+			Join:          join,
+			Branches:      branches,
+			SignMode:      signMode,
+			Quorum:        quorum,
+			RefuseMode:    refuseMode,
+			Timeout:       timeout,
+			Remind:        remind,
+			ExpireAction:  expireAction,
+			EscalateRoles: escalateRoles,
+			EscalateUsers: escalateUsers,
 		})
 	}
-	if len(events) > 0 {
-		err = fs.session.Create(&events).Error
-		if err != nil {
-			return errors.WithStack(err)
-		}
-	}
-	return
+	err = fs.store.CreateEvent(events)
+	return errors.WithStack(err)
 }
 
-func (fs Fsm) findUser(ids []uint) []User {
+func toUsers(ids []uint) []User {
 	users := make([]User, 0)
-	fs.session.
-		Model(&User{}).
-		Where("id IN (?)", ids).
-		Find(&users)
-	oldIds := make([]uint, 0)
-	newUsers := make([]User, 0)
-	for _, user := range users {
-		oldIds = append(oldIds, user.Id)
-	}
 	for _, id := range ids {
-		if !utils.Contains(oldIds, id) {
-			newUsers = append(newUsers, User{Id: id})
-		}
-	}
-	if len(newUsers) > 0 {
-		fs.session.Create(&newUsers)
-		users = append(users, newUsers...)
+		users = append(users, User{Id: id})
 	}
 	return users
 }
 
-func (fs Fsm) findRole(ids []uint) []Role {
+func toRoles(ids []uint) []Role {
 	roles := make([]Role, 0)
-	fs.session.
-		Model(&Role{}).
-		Where("id IN (?)", ids).
-		Find(&roles)
-	oldIds := make([]uint, 0)
-	newRoles := make([]Role, 0)
-	for _, user := range roles {
-		oldIds = append(oldIds, user.Id)
-	}
 	for _, id := range ids {
-		if !utils.Contains(oldIds, id) {
-			newRoles = append(newRoles, Role{Id: id})
-		}
-	}
-	if len(newRoles) > 0 {
-		fs.session.Create(&newRoles)
-		roles = append(roles, newRoles...)
+		roles = append(roles, Role{Id: id})
 	}
 	return roles
 }
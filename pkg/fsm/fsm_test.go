@@ -2,40 +2,55 @@ package fsm
 
 import (
 	"fmt"
+	"github.com/golang-module/carbon/v2"
+	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/delay"
 	"github.com/piupuer/go-helper/pkg/req"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"gorm.io/gorm/schema"
+	"sync"
 	"testing"
 )
 
-var db *gorm.DB
+var (
+	db     *gorm.DB
+	dbOnce sync.Once
+	dbErr  error
+)
 
-func init() {
-	dsn := "root:root@tcp(127.0.0.1:4306)/gin_web_stage?charset=utf8mb4&parseTime=True&loc=Local&timeout=10000ms"
-	var err error
-	db, err = gorm.Open(mysql.Open(dsn), &gorm.Config{
-		DisableForeignKeyConstraintWhenMigrating: true,
-		NamingStrategy: schema.NamingStrategy{
-			TablePrefix: "tb_",
-		},
+// mysql is opened by the first test which needs it, tests are skipped if mysql is unavailable
+func getDb(t *testing.T) *gorm.DB {
+	dbOnce.Do(func() {
+		dsn := "root:root@tcp(127.0.0.1:4306)/gin_web_stage?charset=utf8mb4&parseTime=True&loc=Local&timeout=10000ms"
+		db, dbErr = gorm.Open(mysql.Open(dsn), &gorm.Config{
+			DisableForeignKeyConstraintWhenMigrating: true,
+			NamingStrategy: schema.NamingStrategy{
+				TablePrefix: "tb_",
+			},
+		})
+		if dbErr != nil {
+			return
+		}
+		sqlDb, err := db.DB()
+		if err == nil {
+			err = sqlDb.Ping()
+		}
+		dbErr = err
+		db = db.Debug()
 	})
-	if err != nil {
-		panic(fmt.Sprintf("[unit test]initialize mysql err: %v", err))
+	if dbErr != nil {
+		t.Skipf("[unit test]initialize mysql err: %v", dbErr)
 	}
-	db = db.Debug()
-
-	// db.AutoMigrate(
-	// 	new(Test),
-	// )
+	return db
 }
 
 func TestMigrate(t *testing.T) {
-	Migrate(WithDb(db), WithPrefix("tb_fsm"))
+	Migrate(WithDb(getDb(t)), WithPrefix("tb_fsm"))
 }
 
 func TestFsm_CreateMachine(t *testing.T) {
-	tx := db.Begin()
+	tx := getDb(t).Begin()
 	f := New(WithDb(tx))
 	f.CreateMachine(req.FsmCreateMachine{
 		Name:                       "Leave Approval",
@@ -69,7 +84,7 @@ func TestFsm_CreateMachine(t *testing.T) {
 
 func TestFsm_SubmitLog(t *testing.T) {
 	uid := "log1"
-	tx := db.Begin()
+	tx := getDb(t).Begin()
 	f := New(WithDb(tx))
 	_, err := f.SubmitLog(req.FsmCreateLog{
 		Category:        1,   // custom category
@@ -85,7 +100,7 @@ func TestFsm_SubmitLog(t *testing.T) {
 
 func TestFsm_ApproveLog(t *testing.T) {
 	uid := "log1"
-	tx := db.Begin()
+	tx := getDb(t).Begin()
 	f := New(WithDb(tx))
 	var err error
 	// approved
@@ -179,7 +194,7 @@ func TestFsm_ApproveLog(t *testing.T) {
 
 func TestFsm_ApproveLog1(t *testing.T) {
 	uid := "log2"
-	tx := db.Begin()
+	tx := getDb(t).Begin()
 	f := New(WithDb(tx))
 	var err error
 	_, err = f.SubmitLog(req.FsmCreateLog{
@@ -237,7 +252,7 @@ func TestFsm_ApproveLog1(t *testing.T) {
 }
 
 func TestFsm_CancelLogs(t *testing.T) {
-	tx := db.Begin()
+	tx := getDb(t).Begin()
 	f := New(WithDb(tx))
 	var err error
 	_, err = f.SubmitLog(req.FsmCreateLog{
@@ -270,7 +285,7 @@ func TestFsm_CancelLogs(t *testing.T) {
 }
 
 func TestFsm_FindPendingLogsByApprover(t *testing.T) {
-	tx := db.Begin()
+	tx := getDb(t).Begin()
 	f := New(WithDb(tx))
 	fmt.Println(f.FindPendingLogByApprover(&req.FsmPendingLog{
 		ApprovalRoleId: 1,
@@ -281,7 +296,7 @@ func TestFsm_FindPendingLogsByApprover(t *testing.T) {
 }
 
 func TestFsm_FindLogs(t *testing.T) {
-	tx := db.Begin()
+	tx := getDb(t).Begin()
 	f := New(WithDb(tx))
 	fmt.Println(f.FindLog(req.FsmLog{
 		Category: 1,
@@ -291,7 +306,7 @@ func TestFsm_FindLogs(t *testing.T) {
 }
 
 func TestFsm_GetLogTrack(t *testing.T) {
-	tx := db.Begin()
+	tx := getDb(t).Begin()
 	f := New(WithDb(tx))
 	logs, _ := f.FindLog(req.FsmLog{
 		Category: 1,
@@ -300,3 +315,279 @@ func TestFsm_GetLogTrack(t *testing.T) {
 	fmt.Println(f.FindLogTrack(logs))
 	tx.Commit()
 }
+
+func TestFsm_CreateBranchMachine(t *testing.T) {
+	tx := getDb(t).Begin()
+	f := New(WithDb(tx))
+	_, err := f.CreateMachine(req.FsmCreateMachine{
+		Category:      2,
+		Name:          "Purchase Approval",
+		SubmitterName: "purchaser",
+		Levels: []req.FsmCreateEvent{
+			{
+				Name: "Finance and Legal",
+				Join: req.NullUint(constant.FsmJoinAll),
+				Branches: []req.FsmCreateBranch{
+					{
+						Name:  "Finance",
+						Users: "4",
+					},
+					{
+						Name:  "Legal",
+						Users: "5",
+					},
+				},
+			},
+			{
+				Name:      "VP",
+				Condition: "amount > 10000",
+				Users:     "6",
+			},
+			{
+				Name:  "CEO",
+				Roles: "1",
+			},
+		},
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	tx.Commit()
+}
+
+func TestFsm_ApproveBranchLog(t *testing.T) {
+	uid := "log6"
+	tx := getDb(t).Begin()
+	f := New(WithDb(tx))
+	var err error
+	_, err = f.SubmitLog(req.FsmCreateLog{
+		Category:        2,
+		Uuid:            uid,
+		SubmitterUserId: 123,
+		Params: map[string]interface{}{
+			"amount": 500,
+		},
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+	// finance approved, waiting legal
+	_, err = f.ApproveLog(req.FsmApproveLog{
+		Category:       2,
+		Uuid:           uid,
+		ApprovalUserId: 4,
+		Approved:       1,
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+	// legal approved, VP is skipped(amount <= 10000), waiting CEO
+	_, err = f.ApproveLog(req.FsmApproveLog{
+		Category:       2,
+		Uuid:           uid,
+		ApprovalUserId: 5,
+		Approved:       1,
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+	logs, _ := f.FindLog(req.FsmLog{
+		Category: 2,
+		Uuid:     uid,
+	})
+	fmt.Println(f.FindLogTrack(logs))
+
+	tx.Commit()
+}
+
+func TestFsm_ProcessTask(t *testing.T) {
+	qu := delay.NewQueue()
+	tx := getDb(t).Begin()
+	f := New(WithDb(tx), WithQueue(qu))
+	_, err := f.CreateMachine(req.FsmCreateMachine{
+		Category:      3,
+		Name:          "Reimburse Approval",
+		SubmitterName: "applicant",
+		Levels: []req.FsmCreateEvent{
+			{
+				Name:          "Manager",
+				Users:         "4",
+				Timeout:       3600,
+				Remind:        600,
+				ExpireAction:  req.NullUint(constant.FsmExpireActionEscalate),
+				EscalateUsers: "8",
+			},
+		},
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+	_, err = f.SubmitLog(req.FsmCreateLog{
+		Category:        3,
+		Uuid:            "log7",
+		SubmitterUserId: 123,
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+	logs, _ := f.FindLog(req.FsmLog{
+		Category: 3,
+		Uuid:     "log7",
+	})
+	if len(logs) > 0 {
+		// simulate timeout task
+		err = f.ProcessTask(delay.Task{
+			Name:    constant.FsmTaskName + ".once",
+			Payload: fmt.Sprintf(`{"logId":%d,"action":"%s"}`, logs[0].Id, constant.FsmTaskActionTimeout),
+		})
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	tx.Commit()
+}
+
+func TestFsm_Delegation(t *testing.T) {
+	tx := getDb(t).Begin()
+	f := New(WithDb(tx))
+	// user 4 is on leave, delegate category 1 to user 9
+	err := f.CreateDelegation(req.FsmCreateDelegation{
+		Category:       1,
+		UserId:         4,
+		DelegateUserId: 9,
+		StartAt:        carbon.DateTime{Carbon: carbon.Now().SubDay()},
+		EndAt:          carbon.DateTime{Carbon: carbon.Now().AddDays(7)},
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+	fmt.Println(f.FindPendingLogByApprover(&req.FsmPendingLog{
+		ApprovalUserId: 9,
+		Category:       1,
+	}))
+	// transfer to user 10
+	err = f.TransferLog(req.FsmTransferLog{
+		Category:        1,
+		Uuid:            "log3",
+		ApprovalUserId:  9,
+		ApprovalOpinion: "please help to approve",
+		Users:           "10",
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	tx.Commit()
+}
+
+func TestFsm_MachineVersion(t *testing.T) {
+	tx := getDb(t).Begin()
+	f := New(WithDb(tx))
+	machine, err := f.GetMachineByCategory(1)
+	if err != nil {
+		fmt.Println(err)
+		tx.Rollback()
+		return
+	}
+	// pending logs are still pinned to the old version
+	_, err = f.UpdateMachineById(machine.Id, req.FsmUpdateMachine{
+		Levels: []req.FsmCreateEvent{
+			{
+				Name:       "L1",
+				Edit:       1,
+				EditFields: "status",
+				Users:      "4,5,6",
+			},
+			{
+				Name:  "L3",
+				Edit:  0,
+				Roles: "5",
+			},
+		},
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+	fmt.Println(f.FindMachineVersion(&req.FsmMachineVersion{
+		MachineId: machine.Id,
+	}))
+	fmt.Println(f.DiffMachineVersion(req.FsmDiffMachineVersion{
+		MachineId: machine.Id,
+	}))
+	fmt.Println(f.MigrateLog(req.FsmMigrateLog{
+		Category: 1,
+	}))
+
+	tx.Commit()
+}
+
+func TestFsm_Diagram(t *testing.T) {
+	f := New(WithDb(getDb(t)))
+	fmt.Println(f.Diagram(req.FsmDiagram{
+		Category: 1,
+		Format:   constant.FsmDiagramDot,
+	}))
+	// mark log position
+	fmt.Println(f.Diagram(req.FsmDiagram{
+		Category: 2,
+		Uuid:     "log6",
+	}))
+}
+
+func TestFsm_CountersignLog(t *testing.T) {
+	uid := "log8"
+	tx := getDb(t).Begin()
+	f := New(WithDb(tx))
+	_, err := f.CreateMachine(req.FsmCreateMachine{
+		Category:      4,
+		Name:          "Contract Approval",
+		SubmitterName: "salesman",
+		Levels: []req.FsmCreateEvent{
+			{
+				// 2 of 3 directors
+				Name:       "Directors",
+				Users:      "4,5,6",
+				SignMode:   req.NullUint(constant.FsmSignQuorum),
+				Quorum:     2,
+				RefuseMode: req.NullUint(constant.FsmRefuseMajority),
+			},
+			{
+				// all of managers
+				Name:     "Managers",
+				Users:    "7,8",
+				SignMode: req.NullUint(constant.FsmSignAll),
+			},
+		},
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+	_, err = f.SubmitLog(req.FsmCreateLog{
+		Category:        4,
+		Uuid:            uid,
+		SubmitterUserId: 123,
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+	for _, userId := range []uint{4, 5, 7, 8} {
+		_, err = f.ApproveLog(req.FsmApproveLog{
+			Category:       4,
+			Uuid:           uid,
+			ApprovalUserId: userId,
+			Approved:       1,
+		})
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+	logs, _ := f.FindLog(req.FsmLog{
+		Category: 4,
+		Uuid:     uid,
+	})
+	fmt.Println(f.FindLogTrack(logs))
+
+	tx.Commit()
+}
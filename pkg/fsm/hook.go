@@ -0,0 +1,209 @@
+package fsm
+
+import (
+	"context"
+	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/req"
+	"github.com/piupuer/go-helper/pkg/resp"
+	"github.com/pkg/errors"
+)
+
+// BeforeSubmitHook is called before log is submitted, returning error aborts the submission
+type BeforeSubmitHook func(ctx context.Context, r req.FsmCreateLog) error
+
+// BeforeApproveHook is called before approver approves/refuses the pending log(auto approval by sla included), returning error vetoes it
+type BeforeApproveHook func(ctx context.Context, log Log, r req.FsmApproveLog) error
+
+// AfterHook is called after log status changed, returning error rollbacks the change
+type AfterHook func(ctx context.Context, log resp.FsmApprovalLog) error
+
+// Hooks typed hooks of machine categories, category=0 means all categories
+// all hooks are called in the same transaction as the log change, ctx of hooks carries the transaction(refer to GetStore), example:
+// hooks := fsm.NewHooks().
+//   BeforeApprove(1, func(ctx context.Context, log fsm.Log, r req.FsmApproveLog) error {
+//     return errors.New("budget is not enough")
+//   }).
+//   OnComplete(1, func(ctx context.Context, log resp.FsmApprovalLog) error {
+//     return nil
+//   })
+// fsm.New(fsm.WithDb(db), fsm.WithHooks(hooks))
+type Hooks struct {
+	beforeSubmit  map[uint][]BeforeSubmitHook
+	beforeApprove map[uint][]BeforeApproveHook
+	afterApprove  map[uint][]AfterHook
+	afterRefuse   map[uint][]AfterHook
+	complete      map[uint][]AfterHook
+	cancel        map[uint][]AfterHook
+}
+
+func NewHooks() *Hooks {
+	return &Hooks{
+		beforeSubmit:  make(map[uint][]BeforeSubmitHook),
+		beforeApprove: make(map[uint][]BeforeApproveHook),
+		afterApprove:  make(map[uint][]AfterHook),
+		afterRefuse:   make(map[uint][]AfterHook),
+		complete:      make(map[uint][]AfterHook),
+		cancel:        make(map[uint][]AfterHook),
+	}
+}
+
+// before log submitted
+func (h *Hooks) BeforeSubmit(category uint, fun BeforeSubmitHook) *Hooks {
+	if fun != nil {
+		h.beforeSubmit[category] = append(h.beforeSubmit[category], fun)
+	}
+	return h
+}
+
+// before approver approves/refuses, it can veto the approval
+func (h *Hooks) BeforeApprove(category uint, fun BeforeApproveHook) *Hooks {
+	if fun != nil {
+		h.beforeApprove[category] = append(h.beforeApprove[category], fun)
+	}
+	return h
+}
+
+// after a level is approved(countersign/parallel branches are joined)
+func (h *Hooks) AfterApprove(category uint, fun AfterHook) *Hooks {
+	return h.after(h.afterApprove, category, fun)
+}
+
+// after a level is refused
+func (h *Hooks) AfterRefuse(category uint, fun AfterHook) *Hooks {
+	return h.after(h.afterRefuse, category, fun)
+}
+
+// after the process is ended
+func (h *Hooks) OnComplete(category uint, fun AfterHook) *Hooks {
+	return h.after(h.complete, category, fun)
+}
+
+// after log is cancelled(by submitter, manual, config changed)
+func (h *Hooks) OnCancel(category uint, fun AfterHook) *Hooks {
+	return h.after(h.cancel, category, fun)
+}
+
+func (h *Hooks) after(m map[uint][]AfterHook, category uint, fun AfterHook) *Hooks {
+	if fun != nil {
+		m[category] = append(m[category], fun)
+	}
+	return h
+}
+
+func (fs Fsm) beforeSubmit(r req.FsmCreateLog) error {
+	if fs.ops.hooks == nil {
+		return nil
+	}
+	for _, category := range hookCategories(uint(r.Category)) {
+		for _, fun := range fs.ops.hooks.beforeSubmit[category] {
+			err := fun(fs.ops.ctx, r)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+	return nil
+}
+
+func (fs Fsm) beforeApprove(log Log, r req.FsmApproveLog) error {
+	if fs.ops.hooks == nil {
+		return nil
+	}
+	for _, category := range hookCategories(log.Category) {
+		for _, fun := range fs.ops.hooks.beforeApprove[category] {
+			err := fun(fs.ops.ctx, log, r)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+	return nil
+}
+
+// call after hooks by status of logs
+func (fs Fsm) afterChange(approved uint, logs ...resp.FsmApprovalLog) error {
+	if fs.ops.hooks == nil {
+		return nil
+	}
+	for _, log := range logs {
+		list := make([]map[uint][]AfterHook, 0)
+		switch {
+		case log.Cancel == constant.One:
+			list = append(list, fs.ops.hooks.cancel)
+		case approved == constant.FsmLogStatusRefused:
+			list = append(list, fs.ops.hooks.afterRefuse)
+		case approved == constant.FsmLogStatusApproved:
+			list = append(list, fs.ops.hooks.afterApprove)
+			if log.End == constant.One {
+				list = append(list, fs.ops.hooks.complete)
+			}
+		}
+		for _, m := range list {
+			for _, category := range hookCategories(log.Category) {
+				for _, fun := range m[category] {
+					err := fun(fs.ops.ctx, log)
+					if err != nil {
+						return errors.WithStack(err)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// run fn in store transaction, hooks can rollback log change by returning error
+func (fs Fsm) transaction(fn func(f Fsm) error) error {
+	nested := fs.afterCommit != nil
+	afterCommit := fs.afterCommit
+	if !nested {
+		afterCommit = &[]func() error{}
+	}
+	err := fs.store.Transaction(func(s Store) error {
+		f := fs
+		f.store = s
+		f.afterCommit = afterCommit
+		// hooks/transition read or write business data in the same transaction by ctx
+		f.ops.ctx = context.WithValue(f.ops.ctx, constant.FsmStoreCtxKey, s)
+		if gs, ok := s.(gormStore); ok {
+			f.ops.ctx = context.WithValue(f.ops.ctx, constant.MiddlewareTransactionTxCtxKey, gs.db)
+		}
+		return fn(f)
+	})
+	if err != nil || nested {
+		return err
+	}
+	// tasks of rolled back logs are never enqueued, if the store is WithDb(tx) of caller, tasks run after savepoint released
+	for _, task := range *afterCommit {
+		err = task()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// run task after transaction committed, it runs at once outside of transaction
+func (fs Fsm) runAfterCommit(task func() error) error {
+	if fs.afterCommit == nil {
+		return task()
+	}
+	*fs.afterCommit = append(*fs.afterCommit, task)
+	return nil
+}
+
+// GetStore get store of current transaction in hooks, *gorm.DB of WithDb is also saved by constant.MiddlewareTransactionTxCtxKey
+func GetStore(ctx context.Context) Store {
+	if s, ok := ctx.Value(constant.FsmStoreCtxKey).(Store); ok {
+		return s
+	}
+	return nil
+}
+
+// hooks of all categories are called first
+func hookCategories(category uint) []uint {
+	if category == constant.Zero {
+		return []uint{constant.Zero}
+	}
+	return []uint{constant.Zero, category}
+}
@@ -1,6 +1,9 @@
 package fsm
 
-import "github.com/piupuer/go-helper/ms"
+import (
+	"github.com/golang-module/carbon/v2"
+	"github.com/piupuer/go-helper/ms"
+)
 
 // finite state machine
 type Machine struct {
@@ -9,28 +12,66 @@ type Machine struct {
 	Name                       string  `gorm:"comment:fsm name" json:"name"`
 	SubmitterName              string  `gorm:"comment:submitter username or role name" json:"submitterName"`
 	SubmitterEditFields        string  `gorm:"comment:submitter can edit fields" json:"submitterEditFields"`
-	SubmitterConfirm           uint    `gorm:"type:tinyint(1);default:0;comment:submitter confirm(0: no, 1: yes)" json:"submitterConfirm"`
+	SubmitterConfirm           uint    `gorm:"size:8;default:0;comment:submitter confirm(0: no, 1: yes)" json:"submitterConfirm"`
 	SubmitterConfirmEditFields string  `gorm:"comment:submitter can edit fields when confirm" json:"submitterConfirmEditFields"`
 	EventsJson                 string  `gorm:"comment:event json str" json:"eventsJson"`
+	Version                    uint    `gorm:"default:0;comment:current version(increase when machine updated)" json:"version"`
 	Events                     []Event `gorm:"foreignKey:MachineId" json:"events"`
 }
 
+// fsm machine version snapshot(immutable)
+type MachineVersion struct {
+	ms.M
+	MachineId                  uint   `gorm:"index:idx_m_id_version,unique;comment:machine id" json:"machineId"`
+	Version                    uint   `gorm:"index:idx_m_id_version,unique;comment:machine version" json:"version"`
+	Name                       string `gorm:"comment:fsm name" json:"name"`
+	SubmitterName              string `gorm:"comment:submitter username or role name" json:"submitterName"`
+	SubmitterEditFields        string `gorm:"comment:submitter can edit fields" json:"submitterEditFields"`
+	SubmitterConfirm           uint   `gorm:"size:8;default:0;comment:submitter confirm(0: no, 1: yes)" json:"submitterConfirm"`
+	SubmitterConfirmEditFields string `gorm:"comment:submitter can edit fields when confirm" json:"submitterConfirmEditFields"`
+	EventsJson                 string `gorm:"comment:event json str" json:"eventsJson"`
+}
+
 // fsm event
 type Event struct {
 	ms.M
-	MachineId  uint        `gorm:"index:idx_m_id_sort,unique;" json:"machineId"`
-	Machine    Machine     `gorm:"foreignKey:MachineId" json:"machine"`
-	Sort       uint        `gorm:"index:idx_m_id_sort,unique;comment:sort by level" json:"sort"`
-	Level      uint        `gorm:"comment:level for query" json:"level"`
-	NameId     uint        `gorm:"comment:current event" json:"name"`
-	Name       EventItem   `gorm:"foreignKey:NameId" json:"nameId"`
-	Src        []EventItem `gorm:"many2many:event_src_item_relation;" json:"src"`
-	DstId      uint        `gorm:"comment:destination event" json:"dstId"`
-	Dst        EventItem   `gorm:"foreignKey:DstId" json:"dst"`
-	Edit       uint        `gorm:"type:tinyint(1);comment:approver can edit(0: no, 1: yes)" json:"edit"`
-	EditFields string      `gorm:"comment:approver can edit fields(split by comma, can edit all field if it empty, edit=1 take effect)" json:"editFields"`
-	Roles      []Role      `gorm:"many2many:event_role_relation;comment:approver role ids" json:"roles"`
-	Users      []User      `gorm:"many2many:event_user_relation;comment:approver user ids" json:"users"`
+	MachineId  uint          `gorm:"index:idx_m_id_version_sort,unique;" json:"machineId"`
+	Machine    Machine       `gorm:"foreignKey:MachineId" json:"machine"`
+	Version    uint          `gorm:"index:idx_m_id_version_sort,unique;default:0;comment:machine version" json:"version"`
+	Sort       uint          `gorm:"index:idx_m_id_version_sort,unique;comment:sort by level" json:"sort"`
+	Level      uint          `gorm:"comment:level for query" json:"level"`
+	NameId     uint          `gorm:"comment:current event" json:"name"`
+	Name       EventItem     `gorm:"foreignKey:NameId" json:"nameId"`
+	Src        []EventItem   `gorm:"many2many:event_src_item_relation;" json:"src"`
+	DstId      uint          `gorm:"comment:destination event" json:"dstId"`
+	Dst        EventItem     `gorm:"foreignKey:DstId" json:"dst"`
+	Edit       uint          `gorm:"size:8;comment:approver can edit(0: no, 1: yes)" json:"edit"`
+	EditFields string        `gorm:"comment:approver can edit fields(split by comma, can edit all field if it empty, edit=1 take effect)" json:"editFields"`
+	Roles      []Role        `gorm:"many2many:event_role_relation;comment:approver role ids" json:"roles"`
+	Users      []User        `gorm:"many2many:event_user_relation;comment:approver user ids" json:"users"`
+	Condition  string        `gorm:"comment:condition expression, the level is skipped when it is not satisfied" json:"condition"`
+	Join       uint          `gorm:"default:0;comment:parallel branches join mode(0: all-of, 1: any-of)" json:"join"`
+	Branches   []EventBranch `gorm:"foreignKey:EventId" json:"branches"`
+	// countersign
+	SignMode   uint `gorm:"default:0;comment:sign mode(0: any approver, 1: all approvers, 2: quorum approvers)" json:"signMode"`
+	Quorum     uint `gorm:"default:0;comment:approvals required when sign mode is quorum" json:"quorum"`
+	RefuseMode uint `gorm:"default:0;comment:refuse mode when sign mode is not any(0: one refusal rejects, 1: majority)" json:"refuseMode"`
+	// approval sla
+	Timeout       uint   `gorm:"default:0;comment:approval timeout seconds(0: never)" json:"timeout"`
+	Remind        uint   `gorm:"default:0;comment:remind approvers interval seconds(0: never)" json:"remind"`
+	ExpireAction  uint   `gorm:"default:0;comment:action on timeout(0: none, 1: escalate, 2: auto approve, 3: auto refuse)" json:"expireAction"`
+	EscalateRoles []Role `gorm:"many2many:event_escalate_role_relation;comment:escalate role ids" json:"escalateRoles"`
+	EscalateUsers []User `gorm:"many2many:event_escalate_user_relation;comment:escalate user ids" json:"escalateUsers"`
+}
+
+// fsm event parallel branch
+type EventBranch struct {
+	ms.M
+	EventId   uint   `gorm:"comment:event id" json:"eventId"`
+	Name      string `gorm:"comment:branch name" json:"name"`
+	Condition string `gorm:"comment:condition expression, the branch is skipped when it is not satisfied" json:"condition"`
+	Roles     []Role `gorm:"many2many:event_branch_role_relation;comment:approver role ids" json:"roles"`
+	Users     []User `gorm:"many2many:event_branch_user_relation;comment:approver user ids" json:"users"`
 }
 
 // fsm event user
@@ -67,26 +108,61 @@ type EventItem struct {
 // fsm log(save every operation)
 type Log struct {
 	ms.M
-	Category         uint      `gorm:"comment:custom category(>0)" json:"category"`
-	Uuid             string    `gorm:"comment:unique str" json:"uuid"`
-	Approved         uint      `gorm:"type:tinyint(1);default:0;comment:approval status" json:"approved"`
-	ProgressId       uint      `gorm:"comment:current progress" json:"progressId"`
-	Progress         EventItem `gorm:"foreignKey:ProgressId" json:"progress"`
-	SubmitterRoleId  uint      `gorm:"comment:custom submitter role id" json:"submitterRoleId"`
-	SubmitterUserId  uint      `gorm:"comment:custom submitter user id" json:"submitterUserId"`
-	ApprovalRoleId   uint      `gorm:"comment:approver role id" json:"approvalRoleId"`
-	ApprovalUserId   uint      `gorm:"comment:approver user id" json:"approvalUserId"`
-	ApprovalOpinion  string    `gorm:"comment:approver approval opinion" json:"approvalOpinion"`
-	PrevDetail       string    `gorm:"comment:last approver detail" json:"prevDetail"`
-	Detail           string    `gorm:"comment:current approver detail" json:"detail"`
-	CurrentEventId   uint      `gorm:"comment:current event id" json:"currentEventId"`
-	CurrentEvent     Event     `gorm:"foreignKey:CurrentEventId;comment:current event" json:"currentEvent"`
-	Resubmit         uint      `gorm:"type:tinyint(1);default:0;comment:waiting submitter resubmit" json:"resubmit"`
-	Confirm          uint      `gorm:"type:tinyint(1);default:0;comment:waiting submitter confirm" json:"confirm"`
-	NextEventId      uint      `gorm:"comment:next event id" json:"nextEventId"`
-	NextEvent        Event     `gorm:"foreignKey:NextEventId;comment:next event" json:"nextEvent"`
-	CanApprovalRoles []Role    `gorm:"many2many:log_approval_role_relation;comment:can approve roles" json:"canApprovalRoles"`
-	CanApprovalUsers []User    `gorm:"many2many:log_approval_user_relation;comment:can approve users" json:"canApprovalUsers"`
+	Category         uint        `gorm:"comment:custom category(>0)" json:"category"`
+	Uuid             string      `gorm:"comment:unique str" json:"uuid"`
+	Version          uint        `gorm:"default:0;comment:machine version which log is pinned to" json:"version"`
+	Approved         uint        `gorm:"size:8;default:0;comment:approval status" json:"approved"`
+	ProgressId       uint        `gorm:"comment:current progress" json:"progressId"`
+	Progress         EventItem   `gorm:"foreignKey:ProgressId" json:"progress"`
+	SubmitterRoleId  uint        `gorm:"comment:custom submitter role id" json:"submitterRoleId"`
+	SubmitterUserId  uint        `gorm:"comment:custom submitter user id" json:"submitterUserId"`
+	ApprovalRoleId   uint        `gorm:"comment:approver role id" json:"approvalRoleId"`
+	ApprovalUserId   uint        `gorm:"comment:approver user id" json:"approvalUserId"`
+	ApprovalOpinion  string      `gorm:"comment:approver approval opinion" json:"approvalOpinion"`
+	PrevDetail       string      `gorm:"comment:last approver detail" json:"prevDetail"`
+	Detail           string      `gorm:"comment:current approver detail" json:"detail"`
+	CurrentEventId   uint        `gorm:"comment:current event id" json:"currentEventId"`
+	CurrentEvent     Event       `gorm:"foreignKey:CurrentEventId;comment:current event" json:"currentEvent"`
+	Resubmit         uint        `gorm:"size:8;default:0;comment:waiting submitter resubmit" json:"resubmit"`
+	Confirm          uint        `gorm:"size:8;default:0;comment:waiting submitter confirm" json:"confirm"`
+	NextEventId      uint        `gorm:"comment:next event id" json:"nextEventId"`
+	NextEvent        Event       `gorm:"foreignKey:NextEventId;comment:next event" json:"nextEvent"`
+	CanApprovalRoles []Role      `gorm:"many2many:log_approval_role_relation;comment:can approve roles" json:"canApprovalRoles"`
+	CanApprovalUsers []User      `gorm:"many2many:log_approval_user_relation;comment:can approve users" json:"canApprovalUsers"`
+	Params           string      `gorm:"comment:submit params json for condition expression" json:"params"`
+	Branches         []LogBranch `gorm:"foreignKey:LogId" json:"branches"`
+	Votes            []LogVote   `gorm:"foreignKey:LogId" json:"votes"`
+
+	// remind of approvers(WithRemind), it is displayed in the track
+	Reminded   uint            `gorm:"default:0;comment:remind times of approvers" json:"reminded"`
+	RemindedAt carbon.DateTime `gorm:"comment:last remind time" json:"remindedAt"`
+}
+
+// fsm log parallel branch progress
+type LogBranch struct {
+	ms.M
+	LogId           uint        `gorm:"comment:log id" json:"logId"`
+	BranchId        uint        `gorm:"comment:event branch id" json:"branchId"`
+	Branch          EventBranch `gorm:"foreignKey:BranchId" json:"branch"`
+	Name            string      `gorm:"comment:branch name" json:"name"`
+	RoleId          uint        `gorm:"comment:transferred approver role id(replace branch approvers)" json:"roleId"`
+	UserId          uint        `gorm:"comment:transferred approver user id(replace branch approvers)" json:"userId"`
+	Approved        uint        `gorm:"size:8;default:0;comment:approval status" json:"approved"`
+	ApprovalRoleId  uint        `gorm:"comment:approver role id" json:"approvalRoleId"`
+	ApprovalUserId  uint        `gorm:"comment:approver user id" json:"approvalUserId"`
+	ApprovalOpinion string      `gorm:"comment:approver approval opinion" json:"approvalOpinion"`
+}
+
+// fsm log countersign vote(one row per approver seat)
+type LogVote struct {
+	ms.M
+	LogId           uint   `gorm:"index:idx_log_id;comment:log id" json:"logId"`
+	RoleId          uint   `gorm:"comment:seat role id(any user of the role can vote)" json:"roleId"`
+	UserId          uint   `gorm:"comment:seat user id" json:"userId"`
+	Approved        uint   `gorm:"size:8;default:0;comment:approval status" json:"approved"`
+	ApprovalRoleId  uint   `gorm:"comment:approver role id" json:"approvalRoleId"`
+	ApprovalUserId  uint   `gorm:"comment:approver user id" json:"approvalUserId"`
+	ApprovalOpinion string `gorm:"comment:approver approval opinion" json:"approvalOpinion"`
 }
 
 type LogApprovalRoleRelation struct {
@@ -98,3 +174,13 @@ type LogApprovalUserRelation struct {
 	LogId  uint `json:"logId"`
 	UserId uint `json:"userId"`
 }
+
+// fsm approver delegation(user delegates approval of category to other user in a period)
+type Delegation struct {
+	ms.M
+	Category       uint            `gorm:"comment:custom category(0: all categories)" json:"category"`
+	UserId         uint            `gorm:"index:idx_user_id;comment:delegator user id" json:"userId"`
+	DelegateUserId uint            `gorm:"index:idx_delegate_user_id;comment:delegate user id" json:"delegateUserId"`
+	StartAt        carbon.DateTime `gorm:"comment:start time" json:"startAt"`
+	EndAt          carbon.DateTime `gorm:"comment:end time" json:"endAt"`
+}
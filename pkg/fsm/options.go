@@ -3,6 +3,7 @@ package fsm
 import (
 	"context"
 	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/delay"
 	"github.com/piupuer/go-helper/pkg/resp"
 	"github.com/piupuer/go-helper/pkg/utils"
 	"gorm.io/gorm"
@@ -11,8 +12,14 @@ import (
 type Options struct {
 	ctx        context.Context
 	db         *gorm.DB
+	store      Store
 	prefix     string
 	transition func(ctx context.Context, logs ...resp.FsmApprovalLog) error
+	queue      *delay.Queue
+	remind     func(ctx context.Context, logs ...resp.FsmApprovingLog) error
+	hooks      *Hooks
+	// cancel pending logs of category when machine is updated
+	cancelLogOnUpdate bool
 }
 
 func WithCtx(ctx context.Context) func(*Options) {
@@ -29,6 +36,15 @@ func WithDb(db *gorm.DB) func(*Options) {
 	}
 }
 
+// WithStore custom storage, it takes precedence over WithDb
+func WithStore(s Store) func(*Options) {
+	return func(options *Options) {
+		if s != nil {
+			getOptionsOrSetDefault(options).store = s
+		}
+	}
+}
+
 func WithPrefix(prefix string) func(*Options) {
 	return func(options *Options) {
 		getOptionsOrSetDefault(options).prefix = prefix
@@ -43,6 +59,37 @@ func WithTransition(fun func(ctx context.Context, logs ...resp.FsmApprovalLog) e
 	}
 }
 
+func WithQueue(qu *delay.Queue) func(*Options) {
+	return func(options *Options) {
+		if qu != nil {
+			getOptionsOrSetDefault(options).queue = qu
+		}
+	}
+}
+
+func WithRemind(fun func(ctx context.Context, logs ...resp.FsmApprovingLog) error) func(*Options) {
+	return func(options *Options) {
+		if fun != nil {
+			getOptionsOrSetDefault(options).remind = fun
+		}
+	}
+}
+
+func WithHooks(hooks *Hooks) func(*Options) {
+	return func(options *Options) {
+		if hooks != nil {
+			getOptionsOrSetDefault(options).hooks = hooks
+		}
+	}
+}
+
+// WithCancelLogOnUpdate pending logs are cancelled when machine is updated instead of pinned to their old version
+func WithCancelLogOnUpdate(flag bool) func(*Options) {
+	return func(options *Options) {
+		getOptionsOrSetDefault(options).cancelLogOnUpdate = flag
+	}
+}
+
 func getOptionsOrSetDefault(options *Options) *Options {
 	if options == nil {
 		return &Options{
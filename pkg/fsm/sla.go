@@ -0,0 +1,228 @@
+package fsm
+
+import (
+	"fmt"
+	"github.com/golang-module/carbon/v2"
+	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/delay"
+	"github.com/piupuer/go-helper/pkg/log"
+	"github.com/piupuer/go-helper/pkg/req"
+	"github.com/piupuer/go-helper/pkg/resp"
+	"github.com/piupuer/go-helper/pkg/utils"
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+)
+
+// sla task payload
+type slaTask struct {
+	LogId  uint   `json:"logId"`
+	Action string `json:"action"`
+	Times  uint   `json:"times"`
+}
+
+// ProcessTask handle sla task(timeout/remind) scheduled by WithQueue, other tasks will be ignored
+// it should be called in delay queue handler, example:
+// delay.WithQueueHandler(func(ctx context.Context, t delay.Task) error {
+//   return fsm.New(fsm.WithCtx(ctx), fsm.WithDb(db), fsm.WithQueue(qu)).ProcessTask(t)
+// })
+func (fs Fsm) ProcessTask(t delay.Task) error {
+	if fs.Error != nil {
+		return fs.Error
+	}
+	if !strings.HasPrefix(t.Name, constant.FsmTaskName) {
+		return nil
+	}
+	var task slaTask
+	utils.Json2Struct(t.Payload, &task)
+	return fs.transaction(func(f Fsm) error {
+		pending, err := f.getPendingLogById(task.LogId)
+		if errors.Is(err, ErrRecordNotFound) {
+			// log has been approved, the task is expired
+			return nil
+		}
+		if err != nil {
+			// retried by delay queue
+			return err
+		}
+		switch task.Action {
+		case constant.FsmTaskActionTimeout:
+			return f.timeoutLog(*pending, pending.NextEvent)
+		case constant.FsmTaskActionRemind:
+			return f.remindLog(*pending, pending.NextEvent, task.Times)
+		}
+		return nil
+	})
+}
+
+// schedule timeout/remind task of the event which log is waiting for
+func (fs Fsm) scheduleSla(l Log, event Event, timeout bool) (err error) {
+	if event.Timeout == 0 && event.Remind == 0 {
+		return
+	}
+	if fs.ops.queue == nil {
+		log.WithContext(fs.ops.ctx).Warn("%s, sla of event %d is ignored", ErrQueueNil, event.Id)
+		return
+	}
+	if timeout && event.Timeout > 0 {
+		qu := fs.ops.queue
+		err = fs.runAfterCommit(func() error {
+			err := qu.Once(
+				delay.WithQueueTaskUuid(fmt.Sprintf(constant.FsmTaskUidTimeoutTmpl, l.Id)),
+				delay.WithQueueTaskName(constant.FsmTaskName),
+				delay.WithQueueTaskPayload(utils.Struct2Json(slaTask{
+					LogId:  l.Id,
+					Action: constant.FsmTaskActionTimeout,
+				})),
+				delay.WithQueueTaskIn(time.Duration(event.Timeout)*time.Second),
+			)
+			return errors.WithStack(err)
+		})
+		if err != nil {
+			return
+		}
+	}
+	if event.Remind > 0 {
+		err = fs.scheduleRemind(l.Id, event, constant.One)
+	}
+	return
+}
+
+func (fs Fsm) scheduleRemind(logId uint, event Event, times uint) error {
+	qu := fs.ops.queue
+	return fs.runAfterCommit(func() error {
+		err := qu.Once(
+			delay.WithQueueTaskUuid(fmt.Sprintf(constant.FsmTaskUidRemindTmpl, logId, times)),
+			delay.WithQueueTaskName(constant.FsmTaskName),
+			delay.WithQueueTaskPayload(utils.Struct2Json(slaTask{
+				LogId:  logId,
+				Action: constant.FsmTaskActionRemind,
+				Times:  times,
+			})),
+			delay.WithQueueTaskIn(time.Duration(event.Remind)*time.Second),
+		)
+		return errors.WithStack(err)
+	})
+}
+
+func (fs Fsm) timeoutLog(pending Log, event Event) (err error) {
+	switch event.ExpireAction {
+	case constant.FsmExpireActionEscalate:
+		var newLog *Log
+		newLog, err = fs.transferLog(pending, event.EscalateRoles, event.EscalateUsers, constant.FsmMsgTimeoutEscalate, req.FsmApproveLog{
+			ApprovalOpinion: constant.FsmMsgTimeoutEscalate,
+		})
+		if err != nil {
+			return
+		}
+		// escalated log will not be escalated again, only remind
+		err = fs.scheduleSla(*newLog, event, false)
+	case constant.FsmExpireActionApprove, constant.FsmExpireActionRefuse:
+		r := req.FsmApproveLog{
+			Category:        req.NullUint(pending.Category),
+			Uuid:            pending.Uuid,
+			Approved:        req.NullUint(constant.FsmLogStatusApproved),
+			ApprovalOpinion: constant.FsmMsgTimeoutApprove,
+		}
+		if event.ExpireAction == constant.FsmExpireActionRefuse {
+			r.Approved = req.NullUint(constant.FsmLogStatusRefused)
+			r.ApprovalOpinion = constant.FsmMsgTimeoutRefuse
+		}
+		_, err = fs.approveLog(r, true)
+	}
+	return
+}
+
+func (fs Fsm) remindLog(pending Log, event Event, times uint) (err error) {
+	if fs.ops.remind != nil {
+		var rp resp.FsmApprovingLog
+		utils.Struct2StructByJson(pending, &rp)
+		err = fs.ops.remind(fs.ops.ctx, rp)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		// reminders are displayed in the track
+		pending.Reminded++
+		pending.RemindedAt = carbon.DateTime{
+			Carbon: carbon.Now(),
+		}
+		err = fs.store.UpdateLog(&pending)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	if event.Remind > 0 && fs.ops.queue != nil {
+		err = fs.scheduleRemind(pending.Id, event, times+1)
+	}
+	return
+}
+
+// move pending log to other approvers, the new approvers decide the whole level(escalation),
+// the old log is marked transferred so that it can be displayed in the track
+func (fs Fsm) transferLog(oldLog Log, roles []Role, users []User, detail string, r req.FsmApproveLog) (*Log, error) {
+	newLog := newTransferredLog(oldLog, detail)
+	newLog.CanApprovalRoles = roles
+	newLog.CanApprovalUsers = users
+	err := fs.store.CreateLog(&newLog)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	err = fs.closeTransferredLog(oldLog, r)
+	if err != nil {
+		return nil, err
+	}
+	return &newLog, nil
+}
+
+// pending seats/branches of old log are cancelled, they are carried to the new log or decided by new approvers
+func (fs Fsm) closeTransferredLog(oldLog Log, r req.FsmApproveLog) error {
+	err := fs.cancelPendingBranch(oldLog)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = fs.cancelPendingVote(oldLog)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	oldLog.Approved = constant.FsmLogStatusTransferred
+	oldLog.ApprovalRoleId = r.ApprovalRoleId
+	oldLog.ApprovalUserId = r.ApprovalUserId
+	oldLog.ApprovalOpinion = r.ApprovalOpinion
+	err = fs.store.UpdateLog(&oldLog)
+	return errors.WithStack(err)
+}
+
+func newTransferredLog(oldLog Log, detail string) Log {
+	var newLog Log
+	newLog.Category = oldLog.Category
+	newLog.Uuid = oldLog.Uuid
+	newLog.Version = oldLog.Version
+	newLog.Params = oldLog.Params
+	newLog.ProgressId = oldLog.ProgressId
+	newLog.SubmitterRoleId = oldLog.SubmitterRoleId
+	newLog.SubmitterUserId = oldLog.SubmitterUserId
+	newLog.PrevDetail = detail
+	newLog.Detail = oldLog.Detail
+	newLog.CurrentEventId = oldLog.CurrentEventId
+	newLog.NextEventId = oldLog.NextEventId
+	newLog.Resubmit = oldLog.Resubmit
+	newLog.Confirm = oldLog.Confirm
+	return newLog
+}
+
+func (fs Fsm) getPendingLogById(id uint) (*Log, error) {
+	if id == constant.Zero {
+		return nil, errors.WithStack(ErrRecordNotFound)
+	}
+	logs, err := fs.store.FindLog(LogQuery{
+		Id:      id,
+		Waiting: true,
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(logs) == 0 {
+		return nil, errors.WithStack(ErrRecordNotFound)
+	}
+	return &logs[0], nil
+}
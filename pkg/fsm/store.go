@@ -0,0 +1,110 @@
+package fsm
+
+import (
+	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/req"
+	"github.com/piupuer/go-helper/pkg/resp"
+	"github.com/piupuer/go-helper/pkg/utils"
+)
+
+// Store storage backend of fsm, ErrRecordNotFound should be returned when Get* does not find any record
+// gorm(WithDb) and memory(NewMemoryStore) are built in
+type Store interface {
+	// create/update tables
+	Migrate() error
+	// run fn in a transaction, fn must use the store passed in
+	Transaction(fn func(s Store) error) error
+
+	CreateMachine(machine *Machine) error
+	// update all columns of machine
+	UpdateMachine(machine *Machine) error
+	DeleteMachine(ids []uint) error
+	GetMachine(id uint) (*Machine, error)
+	GetMachineByCategory(category uint) (*Machine, error)
+	FindMachine(r *req.FsmMachine) ([]Machine, error)
+
+	CreateMachineVersion(version *MachineVersion) error
+	GetMachineVersion(machineId, version uint) (*MachineVersion, error)
+	FindMachineVersion(r *req.FsmMachineVersion) ([]MachineVersion, error)
+
+	// create items which do not exist and return all items of names
+	SaveEventItem(names []string) ([]EventItem, error)
+	// create events with src/roles/users/branches/escalate roles/escalate users
+	CreateEvent(events []Event) error
+	// find all events of machine version order by sort, associations should be loaded
+	FindEvent(machineId, version uint) ([]Event, error)
+
+	// create log with can approval roles/users, branches and votes
+	CreateLog(log *Log) error
+	// update all columns of log(associations are ignored)
+	UpdateLog(log *Log) error
+	// replace can approval roles/users of log
+	ReplaceLogApprover(log *Log) error
+	// find logs order by id, associations(progress, events, approvers, branches, votes) should be loaded
+	FindLog(q LogQuery) ([]Log, error)
+	// create log branch if id is 0, otherwise update all columns
+	SaveLogBranch(branch *LogBranch) error
+	DeleteLogBranch(logId uint) error
+	// create log vote if id is 0, otherwise update all columns
+	SaveLogVote(vote *LogVote) error
+	DeleteLogVote(logId uint) error
+
+	CreateDelegation(delegation *Delegation) error
+	// delete delegations of user
+	DeleteDelegation(userId uint, ids []uint) error
+	FindDelegation(r *req.FsmDelegation) ([]Delegation, error)
+	// find delegations of delegate user which are active now, category=0 means all categories
+	FindActiveDelegation(category, delegateUserId uint) ([]Delegation, error)
+}
+
+// log query condition, zero value is ignored
+type LogQuery struct {
+	Id       uint
+	Category uint
+	Uuid     string
+	Uuids    []string
+	// only waiting logs
+	Waiting bool
+	// log can be approved by any of approvers
+	Approvers []LogApprover
+	// paginate if it is not nil
+	Page *resp.Page
+}
+
+// log approver condition, Category>0 means only logs of the category match
+type LogApprover struct {
+	Category uint
+	RoleId   uint
+	UserId   uint
+}
+
+// match log by query condition(without pagination)
+func (q LogQuery) match(log Log) bool {
+	if q.Id > constant.Zero && log.Id != q.Id {
+		return false
+	}
+	if q.Category > constant.Zero && log.Category != q.Category {
+		return false
+	}
+	if q.Uuid != "" && log.Uuid != q.Uuid {
+		return false
+	}
+	if len(q.Uuids) > 0 && !utils.Contains(q.Uuids, log.Uuid) {
+		return false
+	}
+	if q.Waiting && log.Approved != constant.FsmLogStatusWaiting {
+		return false
+	}
+	if len(q.Approvers) == 0 {
+		return true
+	}
+	for _, item := range q.Approvers {
+		if item.Category > constant.Zero && item.Category != log.Category {
+			continue
+		}
+		if containsRole(log.CanApprovalRoles, item.RoleId) || containsUser(log.CanApprovalUsers, item.UserId) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,423 @@
+package fsm
+
+import (
+	"fmt"
+	"github.com/golang-module/carbon/v2"
+	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/req"
+	"github.com/piupuer/go-helper/pkg/resp"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"reflect"
+	"strings"
+)
+
+// gorm storage, any database supported by gorm can be used(mysql/postgres/sqlite...)
+type gormStore struct {
+	db *gorm.DB
+}
+
+func newGormStore(db *gorm.DB) Store {
+	return gormStore{
+		db: db,
+	}
+}
+
+func (gs gormStore) Migrate() error {
+	err := gs.db.AutoMigrate(
+		new(Machine),
+		new(MachineVersion),
+		new(Event),
+		new(User),
+		new(EventSrcItemRelation),
+		new(EventUserRelation),
+		new(EventItem),
+		new(EventBranch),
+		new(Log),
+		new(LogApprovalUserRelation),
+		new(LogBranch),
+		new(LogVote),
+		new(Delegation),
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	// events of all versions are kept, the old unique index(machine_id, sort) is replaced by (machine_id, version, sort)
+	if gs.db.Migrator().HasIndex(&Event{}, "idx_m_id_sort") {
+		err = gs.db.Migrator().DropIndex(&Event{}, "idx_m_id_sort")
+	}
+	return errors.WithStack(err)
+}
+
+func (gs gormStore) Transaction(fn func(s Store) error) error {
+	return gs.db.Transaction(func(tx *gorm.DB) error {
+		return fn(gormStore{db: tx})
+	})
+}
+
+func (gs gormStore) CreateMachine(machine *Machine) error {
+	err := gs.db.Omit(clause.Associations).Create(machine).Error
+	return errors.WithStack(err)
+}
+
+func (gs gormStore) UpdateMachine(machine *Machine) error {
+	err := gs.db.Omit(clause.Associations).Save(machine).Error
+	return errors.WithStack(err)
+}
+
+func (gs gormStore) DeleteMachine(ids []uint) error {
+	err := gs.db.
+		Where("id IN (?)", ids).
+		Delete(&Machine{}).Error
+	return errors.WithStack(err)
+}
+
+func (gs gormStore) GetMachine(id uint) (*Machine, error) {
+	var machine Machine
+	err := gs.db.
+		Model(&Machine{}).
+		Where("id = ?", id).
+		First(&machine).Error
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &machine, nil
+}
+
+func (gs gormStore) GetMachineByCategory(category uint) (*Machine, error) {
+	var machine Machine
+	err := gs.db.
+		Model(&Machine{}).
+		Where("category = ?", category).
+		First(&machine).Error
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &machine, nil
+}
+
+func (gs gormStore) FindMachine(r *req.FsmMachine) ([]Machine, error) {
+	list := make([]Machine, 0)
+	q := gs.db.Model(&Machine{})
+	name := strings.TrimSpace(r.Name)
+	if r.Category != nil {
+		q.Where("category = ?", *r.Category)
+	}
+	if name != "" {
+		q.Where("name LIKE ?", fmt.Sprintf("%%%s%%", name))
+	}
+	submitterName := strings.TrimSpace(r.SubmitterName)
+	if submitterName != "" {
+		q.Where("submitter_name LIKE ?", fmt.Sprintf("%%%s%%", submitterName))
+	}
+	if r.SubmitterConfirm != nil {
+		q.Where("submitter_confirm = ?", *r.SubmitterConfirm)
+	}
+	gs.findPage(q, &r.Page, &list)
+	return list, nil
+}
+
+func (gs gormStore) CreateMachineVersion(version *MachineVersion) error {
+	err := gs.db.Create(version).Error
+	return errors.WithStack(err)
+}
+
+func (gs gormStore) GetMachineVersion(machineId, version uint) (*MachineVersion, error) {
+	var v MachineVersion
+	err := gs.db.
+		Model(&MachineVersion{}).
+		Where("machine_id = ?", machineId).
+		Where("version = ?", version).
+		First(&v).Error
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &v, nil
+}
+
+func (gs gormStore) FindMachineVersion(r *req.FsmMachineVersion) ([]MachineVersion, error) {
+	list := make([]MachineVersion, 0)
+	q := gs.db.
+		Model(&MachineVersion{}).
+		Where("machine_id = ?", r.MachineId).
+		Order("version DESC")
+	gs.findPage(q, &r.Page, &list)
+	return list, nil
+}
+
+func (gs gormStore) SaveEventItem(names []string) ([]EventItem, error) {
+	oldItems := make([]EventItem, 0)
+	gs.db.
+		Where("name IN (?)", names).
+		Find(&oldItems)
+	items := make([]EventItem, 0)
+	for _, name := range names {
+		exists := false
+		for _, item := range oldItems {
+			if name == item.Name {
+				exists = true
+			}
+		}
+		if !exists {
+			items = append(items, EventItem{
+				Name: name,
+			})
+		}
+	}
+	if len(items) > 0 {
+		err := gs.db.Create(&items).Error
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	newItems := make([]EventItem, 0)
+	gs.db.
+		Where("name IN (?)", names).
+		Find(&newItems)
+	return newItems, nil
+}
+
+func (gs gormStore) CreateEvent(events []Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	err := gs.db.Omit("Machine", "Name", "Dst").Create(&events).Error
+	return errors.WithStack(err)
+}
+
+func (gs gormStore) FindEvent(machineId, version uint) ([]Event, error) {
+	events := make([]Event, 0)
+	err := gs.db.
+		Preload("Name").
+		Preload("Src").
+		Preload("Dst").
+		Preload("Roles").
+		Preload("Users").
+		Preload("Branches.Roles").
+		Preload("Branches.Users").
+		Preload("EscalateRoles").
+		Preload("EscalateUsers").
+		Where("machine_id = ?", machineId).
+		Where("version = ?", version).
+		Order("sort").
+		Find(&events).Error
+	return events, errors.WithStack(err)
+}
+
+func (gs gormStore) CreateLog(log *Log) error {
+	err := gs.db.Omit("Progress", "CurrentEvent", "NextEvent").Create(log).Error
+	return errors.WithStack(err)
+}
+
+func (gs gormStore) UpdateLog(log *Log) error {
+	err := gs.db.Omit(clause.Associations).Save(log).Error
+	return errors.WithStack(err)
+}
+
+func (gs gormStore) ReplaceLogApprover(log *Log) error {
+	l := Log{
+		M: log.M,
+	}
+	err := gs.db.
+		Model(&l).
+		Association("CanApprovalRoles").
+		Replace(log.CanApprovalRoles)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = gs.db.
+		Model(&l).
+		Association("CanApprovalUsers").
+		Replace(log.CanApprovalUsers)
+	return errors.WithStack(err)
+}
+
+func (gs gormStore) FindLog(r LogQuery) ([]Log, error) {
+	list := make([]Log, 0)
+	q := gs.db.
+		Model(&Log{}).
+		Preload("Progress").
+		Preload("CurrentEvent.Name").
+		Preload("CurrentEvent.Roles").
+		Preload("CurrentEvent.Users").
+		Preload("NextEvent.Name").
+		Preload("NextEvent.Src").
+		Preload("NextEvent.Dst").
+		Preload("NextEvent.Roles").
+		Preload("NextEvent.Users").
+		Preload("NextEvent.Branches.Roles").
+		Preload("NextEvent.Branches.Users").
+		Preload("NextEvent.EscalateRoles").
+		Preload("NextEvent.EscalateUsers").
+		Preload("CanApprovalRoles").
+		Preload("CanApprovalUsers").
+		Preload("Branches.Branch.Roles").
+		Preload("Branches.Branch.Users").
+		Preload("Votes").
+		Order("id")
+	if r.Id > constant.Zero {
+		q.Where("id = ?", r.Id)
+	}
+	if r.Category > constant.Zero {
+		q.Where("category = ?", r.Category)
+	}
+	if r.Uuid != "" {
+		q.Where("uuid = ?", r.Uuid)
+	}
+	if len(r.Uuids) > 0 {
+		q.Where("uuid IN (?)", r.Uuids)
+	}
+	if r.Waiting {
+		q.Where("approved = ?", constant.FsmLogStatusWaiting)
+	}
+	if len(r.Approvers) > 0 {
+		ids := gs.findLogIdByApprover(r.Approvers)
+		if len(ids) == 0 {
+			if r.Page != nil {
+				r.Page.Total = 0
+			}
+			return list, nil
+		}
+		q.Where("id IN (?)", ids)
+	}
+	if r.Page != nil {
+		gs.findPage(q, r.Page, &list)
+		return list, nil
+	}
+	err := q.Find(&list).Error
+	return list, errors.WithStack(err)
+}
+
+func (gs gormStore) findLogIdByApprover(approvers []LogApprover) []uint {
+	ids := make([]uint, 0)
+	for _, item := range approvers {
+		if item.UserId > constant.Zero {
+			// get user relation
+			userLogIds := make([]uint, 0)
+			q := gs.db.
+				Model(&LogApprovalUserRelation{}).
+				Where("user_id = ?", item.UserId)
+			if item.Category > constant.Zero {
+				q.Where("log_id IN (?)", gs.db.Model(&Log{}).Select("id").Where("category = ?", item.Category))
+			}
+			q.Pluck("log_id", &userLogIds)
+			ids = append(ids, userLogIds...)
+		}
+		if item.RoleId > constant.Zero {
+			// get role relation
+			roleLogIds := make([]uint, 0)
+			q := gs.db.
+				Model(&LogApprovalRoleRelation{}).
+				Where("role_id = ?", item.RoleId)
+			if item.Category > constant.Zero {
+				q.Where("log_id IN (?)", gs.db.Model(&Log{}).Select("id").Where("category = ?", item.Category))
+			}
+			q.Pluck("log_id", &roleLogIds)
+			ids = append(ids, roleLogIds...)
+		}
+	}
+	return ids
+}
+
+func (gs gormStore) SaveLogBranch(branch *LogBranch) error {
+	var err error
+	if branch.Id == constant.Zero {
+		err = gs.db.Omit(clause.Associations).Create(branch).Error
+	} else {
+		err = gs.db.Omit(clause.Associations).Save(branch).Error
+	}
+	return errors.WithStack(err)
+}
+
+func (gs gormStore) DeleteLogBranch(logId uint) error {
+	err := gs.db.
+		Where("log_id = ?", logId).
+		Delete(&LogBranch{}).Error
+	return errors.WithStack(err)
+}
+
+func (gs gormStore) SaveLogVote(vote *LogVote) error {
+	var err error
+	if vote.Id == constant.Zero {
+		err = gs.db.Create(vote).Error
+	} else {
+		err = gs.db.Save(vote).Error
+	}
+	return errors.WithStack(err)
+}
+
+func (gs gormStore) DeleteLogVote(logId uint) error {
+	err := gs.db.
+		Where("log_id = ?", logId).
+		Delete(&LogVote{}).Error
+	return errors.WithStack(err)
+}
+
+func (gs gormStore) CreateDelegation(delegation *Delegation) error {
+	err := gs.db.Create(delegation).Error
+	return errors.WithStack(err)
+}
+
+func (gs gormStore) DeleteDelegation(userId uint, ids []uint) error {
+	err := gs.db.
+		Where("user_id = ?", userId).
+		Where("id IN (?)", ids).
+		Delete(&Delegation{}).Error
+	return errors.WithStack(err)
+}
+
+func (gs gormStore) FindDelegation(r *req.FsmDelegation) ([]Delegation, error) {
+	list := make([]Delegation, 0)
+	q := gs.db.
+		Model(&Delegation{}).
+		Order("created_at DESC")
+	if r.Category != nil {
+		q.Where("category = ?", *r.Category)
+	}
+	if r.UserId > constant.Zero {
+		q.Where("user_id = ?", r.UserId)
+	}
+	if r.DelegateUserId != nil {
+		q.Where("delegate_user_id = ?", *r.DelegateUserId)
+	}
+	gs.findPage(q, &r.Page, &list)
+	return list, nil
+}
+
+func (gs gormStore) FindActiveDelegation(category, delegateUserId uint) ([]Delegation, error) {
+	list := make([]Delegation, 0)
+	now := carbon.Now().ToDateTimeString()
+	q := gs.db.
+		Model(&Delegation{}).
+		Where("delegate_user_id = ?", delegateUserId).
+		Where("start_at <= ?", now).
+		Where("end_at >= ?", now)
+	if category > constant.Zero {
+		q.Where("category IN (?)", []uint{constant.Zero, category})
+	}
+	err := q.Find(&list).Error
+	return list, errors.WithStack(err)
+}
+
+func (gs gormStore) findPage(q *gorm.DB, page *resp.Page, list interface{}) {
+	countCache := false
+	if page.CountCache != nil {
+		countCache = *page.CountCache
+	}
+	if !page.NoPagination {
+		if !page.SkipCount {
+			q.Count(&page.Total)
+		}
+		if page.Total > 0 || page.SkipCount {
+			limit, offset := page.GetLimit()
+			q.Limit(limit).Offset(offset).Find(list)
+		}
+	} else {
+		// no pagination
+		q.Find(list)
+		page.Total = int64(reflect.ValueOf(list).Elem().Len())
+		page.GetLimit()
+	}
+	page.CountCache = &countCache
+}
@@ -0,0 +1,614 @@
+package fsm
+
+import (
+	"github.com/golang-module/carbon/v2"
+	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/req"
+	"github.com/piupuer/go-helper/pkg/resp"
+	"github.com/pkg/errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// memory storage, it is designed for unit test(data will be lost after restart)
+// Transaction rollbacks data when fn returns error, but it is not isolated from other goroutines
+type memoryStore struct {
+	lock *sync.Mutex
+	data *memoryData
+}
+
+type memoryData struct {
+	id            uint
+	machines      map[uint]Machine
+	versions      map[uint]MachineVersion
+	items         map[uint]EventItem
+	events        map[uint]Event
+	eventBranches map[uint]EventBranch
+	logs          map[uint]Log
+	logBranches   map[uint]LogBranch
+	logVotes      map[uint]LogVote
+	delegations   map[uint]Delegation
+}
+
+// NewMemoryStore create memory storage, example:
+// fsm.New(fsm.WithStore(fsm.NewMemoryStore()))
+func NewMemoryStore() Store {
+	return memoryStore{
+		lock: &sync.Mutex{},
+		data: newMemoryData(),
+	}
+}
+
+func newMemoryData() *memoryData {
+	return &memoryData{
+		machines:      make(map[uint]Machine),
+		versions:      make(map[uint]MachineVersion),
+		items:         make(map[uint]EventItem),
+		events:        make(map[uint]Event),
+		eventBranches: make(map[uint]EventBranch),
+		logs:          make(map[uint]Log),
+		logBranches:   make(map[uint]LogBranch),
+		logVotes:      make(map[uint]LogVote),
+		delegations:   make(map[uint]Delegation),
+	}
+}
+
+func (ms memoryStore) Migrate() error {
+	return nil
+}
+
+func (ms memoryStore) Transaction(fn func(s Store) error) error {
+	ms.lock.Lock()
+	snapshot := ms.data.clone()
+	ms.lock.Unlock()
+	err := fn(ms)
+	if err != nil {
+		// rollback
+		ms.lock.Lock()
+		*ms.data = *snapshot
+		ms.lock.Unlock()
+	}
+	return err
+}
+
+func (ms memoryStore) CreateMachine(machine *Machine) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	for _, item := range ms.data.machines {
+		if item.Category == machine.Category {
+			return errors.Errorf("fsm category %d already exists", machine.Category)
+		}
+	}
+	machine.Id = ms.data.nextId()
+	machine.CreatedAt = carbon.DateTime{Carbon: carbon.Now()}
+	machine.UpdatedAt = machine.CreatedAt
+	m := *machine
+	m.Events = nil
+	ms.data.machines[m.Id] = m
+	return nil
+}
+
+func (ms memoryStore) UpdateMachine(machine *Machine) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	if _, ok := ms.data.machines[machine.Id]; !ok {
+		return errors.WithStack(ErrRecordNotFound)
+	}
+	machine.UpdatedAt = carbon.DateTime{Carbon: carbon.Now()}
+	m := *machine
+	m.Events = nil
+	ms.data.machines[m.Id] = m
+	return nil
+}
+
+func (ms memoryStore) DeleteMachine(ids []uint) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	for _, id := range ids {
+		delete(ms.data.machines, id)
+	}
+	return nil
+}
+
+func (ms memoryStore) GetMachine(id uint) (*Machine, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	machine, ok := ms.data.machines[id]
+	if !ok {
+		return nil, errors.WithStack(ErrRecordNotFound)
+	}
+	return &machine, nil
+}
+
+func (ms memoryStore) GetMachineByCategory(category uint) (*Machine, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	for _, id := range ms.data.sortedIds(ms.data.machines) {
+		machine := ms.data.machines[id]
+		if machine.Category == category {
+			return &machine, nil
+		}
+	}
+	return nil, errors.WithStack(ErrRecordNotFound)
+}
+
+func (ms memoryStore) FindMachine(r *req.FsmMachine) ([]Machine, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	list := make([]Machine, 0)
+	name := strings.TrimSpace(r.Name)
+	submitterName := strings.TrimSpace(r.SubmitterName)
+	for _, id := range ms.data.sortedIds(ms.data.machines) {
+		machine := ms.data.machines[id]
+		if r.Category != nil && machine.Category != uint(*r.Category) {
+			continue
+		}
+		if name != "" && !strings.Contains(machine.Name, name) {
+			continue
+		}
+		if submitterName != "" && !strings.Contains(machine.SubmitterName, submitterName) {
+			continue
+		}
+		if r.SubmitterConfirm != nil && machine.SubmitterConfirm != uint(*r.SubmitterConfirm) {
+			continue
+		}
+		list = append(list, machine)
+	}
+	start, end := memoryPage(&r.Page, len(list))
+	return list[start:end], nil
+}
+
+func (ms memoryStore) CreateMachineVersion(version *MachineVersion) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	version.Id = ms.data.nextId()
+	version.CreatedAt = carbon.DateTime{Carbon: carbon.Now()}
+	version.UpdatedAt = version.CreatedAt
+	ms.data.versions[version.Id] = *version
+	return nil
+}
+
+func (ms memoryStore) GetMachineVersion(machineId, version uint) (*MachineVersion, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	for _, item := range ms.data.versions {
+		if item.MachineId == machineId && item.Version == version {
+			return &item, nil
+		}
+	}
+	return nil, errors.WithStack(ErrRecordNotFound)
+}
+
+func (ms memoryStore) FindMachineVersion(r *req.FsmMachineVersion) ([]MachineVersion, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	list := make([]MachineVersion, 0)
+	for _, item := range ms.data.versions {
+		if item.MachineId == r.MachineId {
+			list = append(list, item)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Version > list[j].Version
+	})
+	start, end := memoryPage(&r.Page, len(list))
+	return list[start:end], nil
+}
+
+func (ms memoryStore) SaveEventItem(names []string) ([]EventItem, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	list := make([]EventItem, 0)
+	for _, name := range names {
+		exists := false
+		for _, item := range ms.data.items {
+			if item.Name == name {
+				exists = true
+				list = append(list, item)
+				break
+			}
+		}
+		if !exists {
+			item := EventItem{
+				Id:   ms.data.nextId(),
+				Name: name,
+			}
+			ms.data.items[item.Id] = item
+			list = append(list, item)
+		}
+	}
+	return list, nil
+}
+
+func (ms memoryStore) CreateEvent(events []Event) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	for i := range events {
+		for _, item := range ms.data.events {
+			if item.MachineId == events[i].MachineId && item.Version == events[i].Version && item.Sort == events[i].Sort {
+				return errors.Errorf("event %d of machine %d version %d already exists", item.Sort, item.MachineId, item.Version)
+			}
+		}
+		events[i].Id = ms.data.nextId()
+		for j := range events[i].Branches {
+			events[i].Branches[j].Id = ms.data.nextId()
+			events[i].Branches[j].EventId = events[i].Id
+			ms.data.eventBranches[events[i].Branches[j].Id] = copyEventBranch(events[i].Branches[j])
+		}
+		e := copyEvent(events[i])
+		// associations are loaded when query
+		e.Machine = Machine{}
+		e.Name = EventItem{}
+		e.Dst = EventItem{}
+		e.Branches = nil
+		ms.data.events[e.Id] = e
+	}
+	return nil
+}
+
+func (ms memoryStore) FindEvent(machineId, version uint) ([]Event, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	events := make([]Event, 0)
+	for _, item := range ms.data.events {
+		if item.MachineId == machineId && item.Version == version {
+			events = append(events, ms.data.loadEvent(item.Id))
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Sort < events[j].Sort
+	})
+	return events, nil
+}
+
+func (ms memoryStore) CreateLog(log *Log) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	log.Id = ms.data.nextId()
+	log.CreatedAt = carbon.DateTime{Carbon: carbon.Now()}
+	log.UpdatedAt = log.CreatedAt
+	for i := range log.Branches {
+		log.Branches[i].Id = ms.data.nextId()
+		log.Branches[i].LogId = log.Id
+		b := log.Branches[i]
+		b.Branch = EventBranch{}
+		ms.data.logBranches[b.Id] = b
+	}
+	for i := range log.Votes {
+		log.Votes[i].Id = ms.data.nextId()
+		log.Votes[i].LogId = log.Id
+		ms.data.logVotes[log.Votes[i].Id] = log.Votes[i]
+	}
+	ms.data.logs[log.Id] = copyLog(*log)
+	return nil
+}
+
+func (ms memoryStore) UpdateLog(log *Log) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	old, ok := ms.data.logs[log.Id]
+	if !ok {
+		return errors.WithStack(ErrRecordNotFound)
+	}
+	log.UpdatedAt = carbon.DateTime{Carbon: carbon.Now()}
+	l := copyLog(*log)
+	// associations are ignored
+	l.CanApprovalRoles = old.CanApprovalRoles
+	l.CanApprovalUsers = old.CanApprovalUsers
+	ms.data.logs[l.Id] = l
+	return nil
+}
+
+func (ms memoryStore) ReplaceLogApprover(log *Log) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	l, ok := ms.data.logs[log.Id]
+	if !ok {
+		return errors.WithStack(ErrRecordNotFound)
+	}
+	l.CanApprovalRoles = append([]Role{}, log.CanApprovalRoles...)
+	l.CanApprovalUsers = append([]User{}, log.CanApprovalUsers...)
+	ms.data.logs[l.Id] = l
+	return nil
+}
+
+func (ms memoryStore) FindLog(q LogQuery) ([]Log, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	list := make([]Log, 0)
+	for _, id := range ms.data.sortedIds(ms.data.logs) {
+		if q.match(ms.data.logs[id]) {
+			list = append(list, ms.data.loadLog(id))
+		}
+	}
+	if q.Page != nil {
+		start, end := memoryPage(q.Page, len(list))
+		list = list[start:end]
+	}
+	return list, nil
+}
+
+func (ms memoryStore) SaveLogBranch(branch *LogBranch) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	if branch.Id == constant.Zero {
+		branch.Id = ms.data.nextId()
+	}
+	b := *branch
+	b.Branch = EventBranch{}
+	ms.data.logBranches[b.Id] = b
+	return nil
+}
+
+func (ms memoryStore) DeleteLogBranch(logId uint) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	for id, item := range ms.data.logBranches {
+		if item.LogId == logId {
+			delete(ms.data.logBranches, id)
+		}
+	}
+	return nil
+}
+
+func (ms memoryStore) SaveLogVote(vote *LogVote) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	if vote.Id == constant.Zero {
+		vote.Id = ms.data.nextId()
+	}
+	ms.data.logVotes[vote.Id] = *vote
+	return nil
+}
+
+func (ms memoryStore) DeleteLogVote(logId uint) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	for id, item := range ms.data.logVotes {
+		if item.LogId == logId {
+			delete(ms.data.logVotes, id)
+		}
+	}
+	return nil
+}
+
+func (ms memoryStore) CreateDelegation(delegation *Delegation) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	delegation.Id = ms.data.nextId()
+	delegation.CreatedAt = carbon.DateTime{Carbon: carbon.Now()}
+	delegation.UpdatedAt = delegation.CreatedAt
+	ms.data.delegations[delegation.Id] = *delegation
+	return nil
+}
+
+func (ms memoryStore) DeleteDelegation(userId uint, ids []uint) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	for _, id := range ids {
+		if item, ok := ms.data.delegations[id]; ok && item.UserId == userId {
+			delete(ms.data.delegations, id)
+		}
+	}
+	return nil
+}
+
+func (ms memoryStore) FindDelegation(r *req.FsmDelegation) ([]Delegation, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	list := make([]Delegation, 0)
+	ids := ms.data.sortedIds(ms.data.delegations)
+	// created_at DESC
+	for i := len(ids) - 1; i >= 0; i-- {
+		item := ms.data.delegations[ids[i]]
+		if r.Category != nil && item.Category != uint(*r.Category) {
+			continue
+		}
+		if r.UserId > constant.Zero && item.UserId != r.UserId {
+			continue
+		}
+		if r.DelegateUserId != nil && item.DelegateUserId != uint(*r.DelegateUserId) {
+			continue
+		}
+		list = append(list, item)
+	}
+	start, end := memoryPage(&r.Page, len(list))
+	return list[start:end], nil
+}
+
+func (ms memoryStore) FindActiveDelegation(category, delegateUserId uint) ([]Delegation, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	list := make([]Delegation, 0)
+	now := carbon.Now()
+	for _, id := range ms.data.sortedIds(ms.data.delegations) {
+		item := ms.data.delegations[id]
+		if item.DelegateUserId != delegateUserId {
+			continue
+		}
+		if item.StartAt.Gt(now) || item.EndAt.Lt(now) {
+			continue
+		}
+		if category > constant.Zero && item.Category != constant.Zero && item.Category != category {
+			continue
+		}
+		list = append(list, item)
+	}
+	return list, nil
+}
+
+func (md *memoryData) nextId() uint {
+	md.id++
+	return md.id
+}
+
+func (md *memoryData) clone() *memoryData {
+	n := newMemoryData()
+	n.id = md.id
+	for k, v := range md.machines {
+		n.machines[k] = v
+	}
+	for k, v := range md.versions {
+		n.versions[k] = v
+	}
+	for k, v := range md.items {
+		n.items[k] = v
+	}
+	for k, v := range md.events {
+		n.events[k] = v
+	}
+	for k, v := range md.eventBranches {
+		n.eventBranches[k] = v
+	}
+	for k, v := range md.logs {
+		n.logs[k] = v
+	}
+	for k, v := range md.logBranches {
+		n.logBranches[k] = v
+	}
+	for k, v := range md.logVotes {
+		n.logVotes[k] = v
+	}
+	for k, v := range md.delegations {
+		n.delegations[k] = v
+	}
+	return n
+}
+
+// get map keys order by id
+func (md *memoryData) sortedIds(m interface{}) []uint {
+	ids := make([]uint, 0)
+	switch v := m.(type) {
+	case map[uint]Machine:
+		for id := range v {
+			ids = append(ids, id)
+		}
+	case map[uint]Log:
+		for id := range v {
+			ids = append(ids, id)
+		}
+	case map[uint]Delegation:
+		for id := range v {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+func (md *memoryData) loadEvent(id uint) Event {
+	event, ok := md.events[id]
+	if !ok {
+		return Event{}
+	}
+	event = copyEvent(event)
+	event.Name = md.items[event.NameId]
+	event.Dst = md.items[event.DstId]
+	for i, item := range event.Src {
+		event.Src[i] = md.items[item.Id]
+	}
+	event.Branches = make([]EventBranch, 0)
+	branchIds := make([]uint, 0)
+	for branchId, item := range md.eventBranches {
+		if item.EventId == id {
+			branchIds = append(branchIds, branchId)
+		}
+	}
+	sort.Slice(branchIds, func(i, j int) bool {
+		return branchIds[i] < branchIds[j]
+	})
+	for _, branchId := range branchIds {
+		event.Branches = append(event.Branches, copyEventBranch(md.eventBranches[branchId]))
+	}
+	return event
+}
+
+func (md *memoryData) loadLog(id uint) Log {
+	log := copyLog(md.logs[id])
+	log.Progress = md.items[log.ProgressId]
+	log.CurrentEvent = md.loadEvent(log.CurrentEventId)
+	log.NextEvent = md.loadEvent(log.NextEventId)
+	log.Branches = make([]LogBranch, 0)
+	log.Votes = make([]LogVote, 0)
+	ids := make([]uint, 0)
+	for branchId, item := range md.logBranches {
+		if item.LogId == id {
+			ids = append(ids, branchId)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	for _, branchId := range ids {
+		item := md.logBranches[branchId]
+		item.Branch = copyEventBranch(md.eventBranches[item.BranchId])
+		log.Branches = append(log.Branches, item)
+	}
+	ids = make([]uint, 0)
+	for voteId, item := range md.logVotes {
+		if item.LogId == id {
+			ids = append(ids, voteId)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	for _, voteId := range ids {
+		log.Votes = append(log.Votes, md.logVotes[voteId])
+	}
+	return log
+}
+
+// slices are copied so that stored records will not be changed by caller
+func copyEvent(event Event) Event {
+	event.Src = append([]EventItem{}, event.Src...)
+	event.Roles = append([]Role{}, event.Roles...)
+	event.Users = append([]User{}, event.Users...)
+	event.EscalateRoles = append([]Role{}, event.EscalateRoles...)
+	event.EscalateUsers = append([]User{}, event.EscalateUsers...)
+	return event
+}
+
+func copyEventBranch(branch EventBranch) EventBranch {
+	branch.Roles = append([]Role{}, branch.Roles...)
+	branch.Users = append([]User{}, branch.Users...)
+	return branch
+}
+
+func copyLog(log Log) Log {
+	log.CanApprovalRoles = append([]Role{}, log.CanApprovalRoles...)
+	log.CanApprovalUsers = append([]User{}, log.CanApprovalUsers...)
+	log.Progress = EventItem{}
+	log.CurrentEvent = Event{}
+	log.NextEvent = Event{}
+	log.Branches = nil
+	log.Votes = nil
+	return log
+}
+
+// calc slice range of page
+func memoryPage(page *resp.Page, total int) (start, end int) {
+	countCache := false
+	if page.CountCache != nil {
+		countCache = *page.CountCache
+	}
+	page.Total = int64(total)
+	end = total
+	if !page.NoPagination {
+		limit, offset := page.GetLimit()
+		start = offset
+		if start > total {
+			start = total
+		}
+		if start+limit < end {
+			end = start + limit
+		}
+	} else {
+		page.GetLimit()
+	}
+	page.CountCache = &countCache
+	return
+}
@@ -0,0 +1,872 @@
+package fsm
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang-module/carbon/v2"
+	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/req"
+	"github.com/piupuer/go-helper/pkg/resp"
+	"github.com/pkg/errors"
+	"strings"
+	"testing"
+)
+
+func TestFsm_MemoryStore(t *testing.T) {
+	uid := "log9"
+	f := New(WithStore(NewMemoryStore()))
+	_, err := f.CreateMachine(req.FsmCreateMachine{
+		Category:      5,
+		Name:          "Memory Approval",
+		SubmitterName: "applicant",
+		Levels: []req.FsmCreateEvent{
+			{
+				Name:  "L1",
+				Users: "4",
+			},
+			{
+				Name:  "L2",
+				Roles: "5",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.SubmitLog(req.FsmCreateLog{
+		Category:        5,
+		Uuid:            uid,
+		SubmitterUserId: 123,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.ApproveLog(req.FsmApproveLog{
+		Category:       5,
+		Uuid:           uid,
+		ApprovalUserId: 4,
+		Approved:       1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp, err := f.ApproveLog(req.FsmApproveLog{
+		Category:       5,
+		Uuid:           uid,
+		ApprovalRoleId: 5,
+		Approved:       1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rp.End != constant.One {
+		t.Errorf("log %s is not ended", uid)
+	}
+	logs, _ := f.FindLog(req.FsmLog{
+		Category: 5,
+		Uuid:     uid,
+	})
+	fmt.Println(f.FindLogTrack(logs))
+}
+
+func TestFsm_Hooks(t *testing.T) {
+	uid := "log10"
+	completed := false
+	hooks := NewHooks().
+		BeforeApprove(6, func(ctx context.Context, log Log, r req.FsmApproveLog) error {
+			if r.ApprovalUserId == 5 {
+				return fmt.Errorf("user %d is not allowed", r.ApprovalUserId)
+			}
+			return nil
+		}).
+		OnComplete(6, func(ctx context.Context, log resp.FsmApprovalLog) error {
+			// log change is visible by store of the transaction
+			s := GetStore(ctx)
+			if s == nil {
+				return fmt.Errorf("store of transaction is empty")
+			}
+			logs, err := s.FindLog(LogQuery{
+				Category: log.Category,
+				Uuid:     log.Uuid,
+				Waiting:  true,
+			})
+			if err != nil {
+				return err
+			}
+			completed = len(logs) == 0
+			return nil
+		})
+	f := New(WithStore(NewMemoryStore()), WithHooks(hooks))
+	_, err := f.CreateMachine(req.FsmCreateMachine{
+		Category:      6,
+		Name:          "Hook Approval",
+		SubmitterName: "applicant",
+		Levels: []req.FsmCreateEvent{
+			{
+				Name:  "L1",
+				Users: "4,5",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.SubmitLog(req.FsmCreateLog{
+		Category:        6,
+		Uuid:            uid,
+		SubmitterUserId: 123,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// vetoed by before-approve hook
+	_, err = f.ApproveLog(req.FsmApproveLog{
+		Category:       6,
+		Uuid:           uid,
+		ApprovalUserId: 5,
+		Approved:       1,
+	})
+	if err == nil {
+		t.Error("approval is not vetoed")
+	}
+	_, err = f.ApproveLog(req.FsmApproveLog{
+		Category:       6,
+		Uuid:           uid,
+		ApprovalUserId: 4,
+		Approved:       1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !completed {
+		t.Error("on-complete hook is not called")
+	}
+}
+
+func TestFsm_EmptyUuid(t *testing.T) {
+	f := New(WithStore(NewMemoryStore()))
+	_, err := f.CreateMachine(req.FsmCreateMachine{
+		Category:      77,
+		Name:          "Uuid Approval",
+		SubmitterName: "applicant",
+		Levels: []req.FsmCreateEvent{
+			{
+				Name:  "L1",
+				Users: "4",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.SubmitLog(req.FsmCreateLog{
+		Category:        77,
+		Uuid:            "real",
+		SubmitterUserId: 123,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// empty uuid must not match pending log of others
+	_, err = f.ApproveLog(req.FsmApproveLog{
+		Category:       77,
+		Uuid:           "",
+		ApprovalUserId: 4,
+		Approved:       1,
+	})
+	if err == nil {
+		t.Error("log of empty uuid is approved")
+	}
+	_, err = f.SubmitLog(req.FsmCreateLog{
+		Category:        77,
+		SubmitterUserId: 123,
+	})
+	if !errors.Is(err, ErrParams) {
+		t.Errorf("submit empty uuid err = %v, want %v", err, ErrParams)
+	}
+	_, err = f.FindLog(req.FsmLog{})
+	if !errors.Is(err, ErrParams) {
+		t.Errorf("find empty log err = %v, want %v", err, ErrParams)
+	}
+	logs, err := f.FindLog(req.FsmLog{
+		Category: 77,
+		Uuid:     "real",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 || logs[0].Approved != constant.FsmLogStatusWaiting {
+		t.Errorf("log real is changed: %+v", logs)
+	}
+}
+
+func TestFsm_TransferSeat(t *testing.T) {
+	uid := "log11"
+	f := New(WithStore(NewMemoryStore()))
+	_, err := f.CreateMachine(req.FsmCreateMachine{
+		Category:      7,
+		Name:          "Transfer Approval",
+		SubmitterName: "applicant",
+		Levels: []req.FsmCreateEvent{
+			{
+				// 2 of 3 directors
+				Name:     "Directors",
+				Users:    "4,5,6",
+				SignMode: req.NullUint(constant.FsmSignQuorum),
+				Quorum:   2,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.SubmitLog(req.FsmCreateLog{
+		Category:        7,
+		Uuid:            uid,
+		SubmitterUserId: 123,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.TransferLog(req.FsmTransferLog{
+		Category:       7,
+		Uuid:           uid,
+		ApprovalUserId: 5,
+		Users:          "10,11",
+	})
+	if err == nil {
+		t.Error("seat is transferred to more than one user")
+	}
+	err = f.TransferLog(req.FsmTransferLog{
+		Category:       7,
+		Uuid:           uid,
+		ApprovalUserId: 5,
+		Users:          "10",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// seat of user 5 is moved to user 10
+	_, err = f.ApproveLog(req.FsmApproveLog{
+		Category:       7,
+		Uuid:           uid,
+		ApprovalUserId: 5,
+		Approved:       1,
+	})
+	if err == nil {
+		t.Error("user 5 can still approve after transfer")
+	}
+	rp, err := f.ApproveLog(req.FsmApproveLog{
+		Category:       7,
+		Uuid:           uid,
+		ApprovalUserId: 10,
+		Approved:       1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rp.End == constant.One {
+		t.Error("transferred seat reaches quorum alone")
+	}
+	rp, err = f.ApproveLog(req.FsmApproveLog{
+		Category:       7,
+		Uuid:           uid,
+		ApprovalUserId: 4,
+		Approved:       1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rp.End != constant.One {
+		t.Errorf("log %s is not ended", uid)
+	}
+}
+
+// store fails to update log, used to check rollback
+type failUpdateStore struct {
+	Store
+}
+
+func (s failUpdateStore) Transaction(fn func(s Store) error) error {
+	return s.Store.Transaction(func(Store) error {
+		return fn(s)
+	})
+}
+
+func (s failUpdateStore) UpdateLog(*Log) error {
+	return fmt.Errorf("update log failed")
+}
+
+func TestFsm_TransferRollback(t *testing.T) {
+	uid := "log11-rollback"
+	store := NewMemoryStore()
+	f := New(WithStore(store))
+	_, err := f.CreateMachine(req.FsmCreateMachine{
+		Category:      7,
+		Name:          "Transfer Approval",
+		SubmitterName: "applicant",
+		Levels: []req.FsmCreateEvent{
+			{
+				Name:     "Directors",
+				Users:    "4,5,6",
+				SignMode: req.NullUint(constant.FsmSignQuorum),
+				Quorum:   2,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.SubmitLog(req.FsmCreateLog{
+		Category:        7,
+		Uuid:            uid,
+		SubmitterUserId: 123,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// old log can not be closed, seat and new log are rolled back
+	err = New(WithStore(failUpdateStore{Store: store})).TransferLog(req.FsmTransferLog{
+		Category:       7,
+		Uuid:           uid,
+		ApprovalUserId: 5,
+		Users:          "10",
+	})
+	if err == nil {
+		t.Fatal("transfer succeeds without closing old log")
+	}
+	logs, err := store.FindLog(LogQuery{
+		Category: 7,
+		Uuid:     uid,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 {
+		t.Errorf("log count = %d, want 1", len(logs))
+	}
+	_, err = f.ApproveLog(req.FsmApproveLog{
+		Category:       7,
+		Uuid:           uid,
+		ApprovalUserId: 5,
+		Approved:       1,
+	})
+	if err != nil {
+		t.Errorf("user 5 can not approve after rollback: %v", err)
+	}
+}
+
+func TestFsm_UpdateMachine(t *testing.T) {
+	for _, cancel := range []bool{false, true} {
+		f := New(WithStore(NewMemoryStore()), WithCancelLogOnUpdate(cancel))
+		machine, err := f.CreateMachine(req.FsmCreateMachine{
+			Category:      8,
+			Name:          "Update Approval",
+			SubmitterName: "applicant",
+			Levels: []req.FsmCreateEvent{
+				{
+					Name:  "L1",
+					Users: "4",
+				},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = f.SubmitLog(req.FsmCreateLog{
+			Category:        8,
+			Uuid:            "log12",
+			SubmitterUserId: 123,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		machine, err = f.UpdateMachineById(machine.Id, req.FsmUpdateMachine{
+			Levels: []req.FsmCreateEvent{
+				{
+					Name:  "L1",
+					Users: "5",
+				},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		diff, err := f.DiffMachineVersion(req.FsmDiffMachineVersion{
+			MachineId: machine.Id,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(diff.Levels) != 1 {
+			t.Errorf("diff levels of version %d and %d: %d", diff.From, diff.To, len(diff.Levels))
+		}
+		_, err = f.getLastPendingLog(req.FsmLog{
+			Category: 8,
+			Uuid:     "log12",
+		})
+		if cancel && err == nil {
+			t.Error("pending log is not cancelled")
+		}
+		if !cancel && err != nil {
+			t.Errorf("pending log is cancelled: %v", err)
+		}
+	}
+}
+
+func TestFsm_RemindTrack(t *testing.T) {
+	uid := "log13"
+	reminded := 0
+	f := New(
+		WithStore(NewMemoryStore()),
+		WithRemind(func(ctx context.Context, logs ...resp.FsmApprovingLog) error {
+			reminded += len(logs)
+			return nil
+		}),
+	)
+	_, err := f.CreateMachine(req.FsmCreateMachine{
+		Category:      9,
+		Name:          "Remind Approval",
+		SubmitterName: "applicant",
+		Levels: []req.FsmCreateEvent{
+			{
+				Name:   "L1",
+				Users:  "4",
+				Remind: 60,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.SubmitLog(req.FsmCreateLog{
+		Category:        9,
+		Uuid:            uid,
+		SubmitterUserId: 123,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, err := f.getLastPendingLog(req.FsmLog{
+		Category: 9,
+		Uuid:     uid,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.remindLog(*pending, pending.NextEvent, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs, _ := f.FindLog(req.FsmLog{
+		Category: 9,
+		Uuid:     uid,
+	})
+	track, err := f.FindLogTrack(logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reminded != 1 || len(track) == 0 || track[len(track)-1].Reminded != 1 {
+		t.Errorf("reminder is not displayed in track: %d, %+v", reminded, track)
+	}
+}
+
+func TestFsm_JoinRules(t *testing.T) {
+	for _, join := range []uint{constant.FsmJoinAll, constant.FsmJoinAny} {
+		category := 10 + join
+		uid := fmt.Sprintf("log14-%d", join)
+		f := newMemoryFsm(t, category, req.FsmCreateEvent{
+			Name: "Finance and Legal",
+			Join: req.NullUint(join),
+			Branches: []req.FsmCreateBranch{
+				{
+					Name:  "Finance",
+					Users: "4",
+				},
+				{
+					Name:  "Legal",
+					Users: "5",
+				},
+			},
+		})
+		submitMemoryLog(t, f, category, uid)
+		rp := approveMemoryLog(t, f, category, uid, 4, constant.FsmLogStatusApproved)
+		if join == constant.FsmJoinAny {
+			if rp.End != constant.One {
+				t.Error("any branch approved is not enough")
+			}
+			continue
+		}
+		if rp.End == constant.One {
+			t.Error("level is ended before all branches are approved")
+		}
+		_, err := f.ApproveLog(req.FsmApproveLog{
+			Category:       req.NullUint(category),
+			Uuid:           uid,
+			ApprovalUserId: 4,
+			Approved:       req.NullUint(constant.FsmLogStatusApproved),
+		})
+		if err == nil {
+			t.Error("branch is approved twice")
+		}
+		rp = approveMemoryLog(t, f, category, uid, 5, constant.FsmLogStatusApproved)
+		if rp.End != constant.One {
+			t.Error("level is not ended after all branches are approved")
+		}
+	}
+}
+
+func TestFsm_Quorum(t *testing.T) {
+	uid := "log15"
+	f := newMemoryFsm(t, 12, req.FsmCreateEvent{
+		// 2 of 3 directors
+		Name:     "Directors",
+		Users:    "4,5,6",
+		SignMode: req.NullUint(constant.FsmSignQuorum),
+		Quorum:   2,
+	}, req.FsmCreateEvent{
+		// all of managers
+		Name:     "Managers",
+		Users:    "7,8",
+		SignMode: req.NullUint(constant.FsmSignAll),
+	})
+	submitMemoryLog(t, f, 12, uid)
+	approveMemoryLog(t, f, 12, uid, 4, constant.FsmLogStatusApproved)
+	// the same approver can not vote twice to reach quorum
+	_, err := f.ApproveLog(req.FsmApproveLog{
+		Category:       12,
+		Uuid:           uid,
+		ApprovalUserId: 4,
+		Approved:       req.NullUint(constant.FsmLogStatusApproved),
+	})
+	if err == nil {
+		t.Error("approver votes twice")
+	}
+	if checkMemoryLog(f, 12, uid, 7) {
+		t.Error("managers can approve before quorum of directors")
+	}
+	approveMemoryLog(t, f, 12, uid, 5, constant.FsmLogStatusApproved)
+	if !checkMemoryLog(f, 12, uid, 7) || checkMemoryLog(f, 12, uid, 6) {
+		t.Error("level is not passed after quorum")
+	}
+	rp := approveMemoryLog(t, f, 12, uid, 7, constant.FsmLogStatusApproved)
+	if rp.End == constant.One {
+		t.Error("countersign is ended before all managers approve")
+	}
+	rp = approveMemoryLog(t, f, 12, uid, 8, constant.FsmLogStatusApproved)
+	if rp.End != constant.One {
+		t.Errorf("log %s is not ended", uid)
+	}
+}
+
+func TestFsm_RefuseRules(t *testing.T) {
+	for _, mode := range []uint{constant.FsmRefuseOne, constant.FsmRefuseMajority} {
+		category := 13 + mode
+		uid := fmt.Sprintf("log16-%d", mode)
+		f := newMemoryFsm(t, category, req.FsmCreateEvent{
+			Name:       "Directors",
+			Users:      "4,5,6",
+			SignMode:   req.NullUint(constant.FsmSignQuorum),
+			Quorum:     2,
+			RefuseMode: req.NullUint(mode),
+		})
+		submitMemoryLog(t, f, category, uid)
+		rp := approveMemoryLog(t, f, category, uid, 4, constant.FsmLogStatusRefused)
+		if mode == constant.FsmRefuseOne {
+			if rp.Resubmit != constant.One {
+				t.Error("one refusal does not reject the level")
+			}
+			continue
+		}
+		if rp.Resubmit == constant.One {
+			t.Error("level is rejected by minority")
+		}
+		rp = approveMemoryLog(t, f, category, uid, 5, constant.FsmLogStatusRefused)
+		if rp.Resubmit != constant.One {
+			t.Error("level is not rejected when quorum can not be reached")
+		}
+	}
+}
+
+func TestFsm_RefuseRouting(t *testing.T) {
+	uid := "log17"
+	f := newMemoryFsm(t, 15, req.FsmCreateEvent{
+		Name:  "L1",
+		Users: "4",
+	}, req.FsmCreateEvent{
+		Name:  "L2",
+		Users: "5",
+	})
+	submitMemoryLog(t, f, 15, uid)
+	approveMemoryLog(t, f, 15, uid, 4, constant.FsmLogStatusApproved)
+	// refused by L2, back to L1
+	approveMemoryLog(t, f, 15, uid, 5, constant.FsmLogStatusRefused)
+	if !checkMemoryLog(f, 15, uid, 4) || checkMemoryLog(f, 15, uid, 5) {
+		t.Error("refused log is not routed to previous level")
+	}
+	// refused by L1, back to submitter
+	rp := approveMemoryLog(t, f, 15, uid, 4, constant.FsmLogStatusRefused)
+	if rp.Resubmit != constant.One || !checkMemoryLog(f, 15, uid, 123) {
+		t.Error("refused log is not routed to submitter")
+	}
+	approveMemoryLog(t, f, 15, uid, 123, constant.FsmLogStatusApproved)
+	if !checkMemoryLog(f, 15, uid, 4) {
+		t.Error("resubmitted log is not routed to first level")
+	}
+}
+
+func TestFsm_DelegationApprove(t *testing.T) {
+	uid := "log18"
+	f := newMemoryFsm(t, 16, req.FsmCreateEvent{
+		Name:  "L1",
+		Users: "4",
+	})
+	err := f.CreateDelegation(req.FsmCreateDelegation{
+		Category:       16,
+		UserId:         4,
+		DelegateUserId: 9,
+		StartAt: carbon.DateTime{
+			Carbon: carbon.Now().SubHours(1),
+		},
+		EndAt: carbon.DateTime{
+			Carbon: carbon.Now().AddHours(1),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	submitMemoryLog(t, f, 16, uid)
+	if checkMemoryLog(f, 16, uid, 10) {
+		t.Error("user without delegation can approve")
+	}
+	rp := approveMemoryLog(t, f, 16, uid, 9, constant.FsmLogStatusApproved)
+	if rp.End != constant.One {
+		t.Error("delegate can not approve for delegator")
+	}
+	err = f.DeleteDelegationByIds(9, []uint{1})
+	list, _ := f.FindDelegation(&req.FsmDelegation{})
+	if err == nil && len(list) != 1 {
+		t.Error("delegation is deleted by other user")
+	}
+}
+
+func TestFsm_MigrateLog(t *testing.T) {
+	f := newMemoryFsm(t, 17, req.FsmCreateEvent{
+		Name:  "L1",
+		Users: "4",
+	}, req.FsmCreateEvent{
+		Name:  "L2",
+		Users: "5",
+	})
+	submitMemoryLog(t, f, 17, "log19")
+	submitMemoryLog(t, f, 17, "log20")
+	approveMemoryLog(t, f, 17, "log20", 4, constant.FsmLogStatusApproved)
+	machine, err := f.GetMachineByCategory(17)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// L1 approver is changed, L2 is removed
+	_, err = f.UpdateMachineById(machine.Id, req.FsmUpdateMachine{
+		Levels: []req.FsmCreateEvent{
+			{
+				Name:  "L1",
+				Users: "6",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !checkMemoryLog(f, 17, "log19", 4) {
+		t.Error("pending log is not pinned to old version")
+	}
+	rp, err := f.MigrateLog(req.FsmMigrateLog{
+		Category: 17,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rp.Migrated) != 1 || rp.Migrated[0] != "log19" || len(rp.Cancelled) != 1 || rp.Cancelled[0] != "log20" {
+		t.Errorf("migrated: %v, cancelled: %v", rp.Migrated, rp.Cancelled)
+	}
+	if checkMemoryLog(f, 17, "log19", 4) || !checkMemoryLog(f, 17, "log19", 6) {
+		t.Error("approver of migrated log is not rebound")
+	}
+	if checkMemoryLog(f, 17, "log20", 5) {
+		t.Error("cancelled log can still be approved")
+	}
+}
+
+func TestFsm_TransferBranch(t *testing.T) {
+	uid := "log21"
+	f := newMemoryFsm(t, 18, req.FsmCreateEvent{
+		Name: "Finance and Legal",
+		Join: req.NullUint(constant.FsmJoinAll),
+		Branches: []req.FsmCreateBranch{
+			{
+				Name:  "Finance",
+				Users: "4",
+			},
+			{
+				Name:  "Legal",
+				Users: "5",
+			},
+		},
+	})
+	submitMemoryLog(t, f, 18, uid)
+	err := f.TransferLog(req.FsmTransferLog{
+		Category:       18,
+		Uuid:           uid,
+		ApprovalUserId: 4,
+		Users:          "10",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkMemoryLog(f, 18, uid, 4) || !checkMemoryLog(f, 18, uid, 5) {
+		t.Error("other branches are changed by transfer")
+	}
+	rp := approveMemoryLog(t, f, 18, uid, 10, constant.FsmLogStatusApproved)
+	if rp.End == constant.One {
+		t.Error("transferred branch decides the whole level")
+	}
+	rp = approveMemoryLog(t, f, 18, uid, 5, constant.FsmLogStatusApproved)
+	if rp.End != constant.One {
+		t.Errorf("log %s is not ended", uid)
+	}
+}
+
+func TestFsm_DiagramRules(t *testing.T) {
+	f := newMemoryFsm(t, 19, req.FsmCreateEvent{
+		Name: "Finance and Legal",
+		Join: req.NullUint(constant.FsmJoinAny),
+		Branches: []req.FsmCreateBranch{
+			{
+				Name:      "Finance",
+				Condition: "amount > 10000",
+				Users:     "4",
+			},
+			{
+				Name:  "Legal",
+				Users: "5",
+			},
+		},
+	}, req.FsmCreateEvent{
+		Name:       "Directors",
+		Users:      "6,7,8",
+		Condition:  "amount > 50000",
+		SignMode:   req.NullUint(constant.FsmSignQuorum),
+		Quorum:     2,
+		RefuseMode: req.NullUint(constant.FsmRefuseMajority),
+	})
+	forks := map[string]string{
+		constant.FsmDiagramDot:     `"Finance and Legal waiting fork"`,
+		constant.FsmDiagramMermaid: "<<fork>>",
+	}
+	for format, fork := range forks {
+		rp, err := f.Diagram(req.FsmDiagram{
+			Category: 19,
+			Format:   format,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range []string{
+			fork,
+			"Finance and Legal waiting: Finance",
+			"[amount > 10000]",
+			"any of",
+			"skip if not [amount > 50000]",
+			"quorum 2/3",
+			"majority refuse",
+		} {
+			if !strings.Contains(rp.Content, item) {
+				t.Errorf("%s diagram has no %s:\n%s", format, item, rp.Content)
+			}
+		}
+	}
+}
+
+// create memory fsm with one machine
+func newMemoryFsm(t *testing.T, category uint, levels ...req.FsmCreateEvent) *Fsm {
+	f := New(WithStore(NewMemoryStore()))
+	_, err := f.CreateMachine(req.FsmCreateMachine{
+		Category:      req.NullUint(category),
+		Name:          fmt.Sprintf("Approval %d", category),
+		SubmitterName: "applicant",
+		Levels:        levels,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func submitMemoryLog(t *testing.T, f *Fsm, category uint, uid string) {
+	_, err := f.SubmitLog(req.FsmCreateLog{
+		Category:        req.NullUint(category),
+		Uuid:            uid,
+		SubmitterUserId: 123,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func approveMemoryLog(t *testing.T, f *Fsm, category uint, uid string, userId, approved uint) *resp.FsmApprovalLog {
+	rp, err := f.ApproveLog(req.FsmApproveLog{
+		Category:       req.NullUint(category),
+		Uuid:           uid,
+		ApprovalUserId: userId,
+		Approved:       req.NullUint(approved),
+	})
+	if err != nil {
+		t.Fatalf("user %d approve log %s failed: %v", userId, uid, err)
+	}
+	return rp
+}
+
+// whether user can approve pending log
+func checkMemoryLog(f *Fsm, category uint, uid string, userId uint) bool {
+	_, err := f.CheckLogPermission(req.FsmPermissionLog{
+		Category:       req.NullUint(category),
+		Uuid:           uid,
+		ApprovalUserId: userId,
+		Approved:       constant.FsmLogStatusApproved,
+	})
+	return err == nil
+}
+
+func TestFsm_RunAfterCommit(t *testing.T) {
+	f := New(WithStore(NewMemoryStore()))
+	count := 0
+	task := func() error {
+		count++
+		return nil
+	}
+	err := f.transaction(func(f Fsm) error {
+		f.runAfterCommit(task)
+		return fmt.Errorf("rollback")
+	})
+	if err == nil || count != 0 {
+		t.Errorf("count = %d, want 0 after rollback", count)
+	}
+	err = f.transaction(func(f Fsm) error {
+		f.runAfterCommit(task)
+		// nested transaction is committed by the outer one
+		return f.transaction(func(f Fsm) error {
+			f.runAfterCommit(task)
+			if count != 0 {
+				t.Errorf("count = %d, want 0 before commit", count)
+			}
+			return nil
+		})
+	})
+	if err != nil || count != 2 {
+		t.Errorf("count = %d, err = %v, want 2 after commit", count, err)
+	}
+	err = f.runAfterCommit(task)
+	if err != nil || count != 3 {
+		t.Errorf("count = %d, want 3 outside of transaction", count)
+	}
+}
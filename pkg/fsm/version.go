@@ -0,0 +1,314 @@
+package fsm
+
+import (
+	"fmt"
+	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/log"
+	"github.com/piupuer/go-helper/pkg/req"
+	"github.com/piupuer/go-helper/pkg/resp"
+	"github.com/piupuer/go-helper/pkg/utils"
+	"github.com/pkg/errors"
+)
+
+// find machine versions
+func (fs Fsm) FindMachineVersion(r *req.FsmMachineVersion) ([]resp.FsmMachineVersion, error) {
+	if fs.Error != nil {
+		return nil, fs.Error
+	}
+	list, err := fs.store.FindMachineVersion(r)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	newList := make([]resp.FsmMachineVersion, 0)
+	utils.Struct2StructByJson(list, &newList)
+	return newList, nil
+}
+
+// diff two machine versions, To=0 means current version, From=0 means the version before To
+func (fs Fsm) DiffMachineVersion(r req.FsmDiffMachineVersion) (*resp.FsmMachineVersionDiff, error) {
+	if fs.Error != nil {
+		return nil, fs.Error
+	}
+	machine, err := fs.store.GetMachine(r.MachineId)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	to := uint(r.To)
+	if to == constant.Zero {
+		to = machine.Version
+	}
+	from := uint(r.From)
+	if from == constant.Zero && to > constant.One {
+		from = to - 1
+	}
+	oldVersion, err := fs.getMachineVersion(*machine, from)
+	if err != nil {
+		return nil, errors.Wrapf(err, "version %d", from)
+	}
+	newVersion, err := fs.getMachineVersion(*machine, to)
+	if err != nil {
+		return nil, errors.Wrapf(err, "version %d", to)
+	}
+	rp := resp.FsmMachineVersionDiff{
+		From:   from,
+		To:     to,
+		Fields: make([]resp.FsmVersionDiffItem, 0),
+		Levels: make([]resp.FsmVersionDiffItem, 0),
+	}
+	fields := []struct {
+		name     string
+		old, new interface{}
+	}{
+		{"name", oldVersion.Name, newVersion.Name},
+		{"submitterName", oldVersion.SubmitterName, newVersion.SubmitterName},
+		{"submitterEditFields", oldVersion.SubmitterEditFields, newVersion.SubmitterEditFields},
+		{"submitterConfirm", oldVersion.SubmitterConfirm, newVersion.SubmitterConfirm},
+		{"submitterConfirmEditFields", oldVersion.SubmitterConfirmEditFields, newVersion.SubmitterConfirmEditFields},
+	}
+	for _, item := range fields {
+		o := fmt.Sprint(item.old)
+		n := fmt.Sprint(item.new)
+		if o != n {
+			rp.Fields = append(rp.Fields, resp.FsmVersionDiffItem{
+				Name:   item.name,
+				Action: constant.FsmDiffActionChange,
+				Old:    o,
+				New:    n,
+			})
+		}
+	}
+	// levels are matched by name
+	oldLevels := make([]req.FsmCreateEvent, 0)
+	newLevels := make([]req.FsmCreateEvent, 0)
+	utils.Json2Struct(oldVersion.EventsJson, &oldLevels)
+	utils.Json2Struct(newVersion.EventsJson, &newLevels)
+	for _, o := range oldLevels {
+		item := resp.FsmVersionDiffItem{
+			Name:   o.Name,
+			Action: constant.FsmDiffActionRemove,
+			Old:    utils.Struct2Json(o),
+		}
+		for _, n := range newLevels {
+			if n.Name == o.Name {
+				item.Action = constant.FsmDiffActionChange
+				item.New = utils.Struct2Json(n)
+				break
+			}
+		}
+		if item.Old != item.New {
+			rp.Levels = append(rp.Levels, item)
+		}
+	}
+	for _, n := range newLevels {
+		exists := false
+		for _, o := range oldLevels {
+			if o.Name == n.Name {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			rp.Levels = append(rp.Levels, resp.FsmVersionDiffItem{
+				Name:   n.Name,
+				Action: constant.FsmDiffActionAdd,
+				New:    utils.Struct2Json(n),
+			})
+		}
+	}
+	return &rp, nil
+}
+
+// migrate pending logs to the newest machine version(all pending logs of category if uuids is empty)
+// the log is matched by event name, it will be cancelled if its position does not exist in the newest version
+func (fs Fsm) MigrateLog(r req.FsmMigrateLog) (*resp.FsmMigrateLog, error) {
+	if fs.Error != nil {
+		return nil, fs.Error
+	}
+	var rp *resp.FsmMigrateLog
+	err := fs.transaction(func(f Fsm) (err error) {
+		rp, err = f.migrateLogs(r)
+		return
+	})
+	return rp, err
+}
+
+func (fs Fsm) migrateLogs(r req.FsmMigrateLog) (*resp.FsmMigrateLog, error) {
+	machine, err := fs.GetMachineByCategory(uint(r.Category))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	logs, err := fs.store.FindLog(LogQuery{
+		Category: machine.Category,
+		Uuids:    r.Uuids,
+		Waiting:  true,
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	events, err := fs.store.FindEvent(machine.Id, machine.Version)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	rp := resp.FsmMigrateLog{
+		Migrated:  make([]string, 0),
+		Cancelled: make([]string, 0),
+	}
+	list := make([]resp.FsmApprovalLog, 0)
+	for _, item := range logs {
+		if item.Version == machine.Version {
+			continue
+		}
+		params := make(map[string]interface{})
+		utils.Json2Struct(item.Params, &params)
+		current := findEventByName(events, item.CurrentEvent.Name.Name)
+		next := findEventByName(events, item.NextEvent.Name.Name)
+		if current == nil || next == nil || !eventFrom(*next, item.Progress.Name) || !eventActive(*next, params) {
+			err = fs.cancelMigrateLog(item)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			rp.Cancelled = append(rp.Cancelled, item.Uuid)
+			list = append(list, resp.FsmApprovalLog{
+				Uuid:     item.Uuid,
+				Category: item.Category,
+				Cancel:   constant.One,
+			})
+			continue
+		}
+		err = fs.migrateLog(item, machine.Version, *current, *next, params)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		rp.Migrated = append(rp.Migrated, item.Uuid)
+	}
+	if len(list) == 0 {
+		return &rp, nil
+	}
+	err = fs.afterChange(constant.FsmLogStatusCancelled, list...)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	// status transition
+	if fs.ops.transition == nil {
+		log.WithContext(fs.ops.ctx).Warn("%s", ErrTransitionNil)
+		return &rp, nil
+	}
+	return &rp, fs.ops.transition(fs.ops.ctx, list...)
+}
+
+func (fs Fsm) migrateLog(l Log, version uint, current, next Event, params map[string]interface{}) error {
+	l.Version = version
+	l.CurrentEventId = current.Id
+	l.NextEventId = next.Id
+	err := fs.store.UpdateLog(&l)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if len(next.Roles) == 0 && len(next.Users) == 0 && len(next.Branches) == 0 {
+		// waiting submitter resubmit/confirm, approver is still submitter
+		return fs.scheduleSla(l, next, true)
+	}
+	// rebind approvers of the new event
+	err = fs.store.DeleteLogBranch(l.Id)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = fs.store.DeleteLogVote(l.Id)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	l.Branches = nil
+	l.Votes = nil
+	fs.bindApprover(&l, next, params)
+	err = fs.store.ReplaceLogApprover(&l)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for i := range l.Branches {
+		l.Branches[i].LogId = l.Id
+		err = fs.store.SaveLogBranch(&l.Branches[i])
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	for i := range l.Votes {
+		l.Votes[i].LogId = l.Id
+		err = fs.store.SaveLogVote(&l.Votes[i])
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	// timeout/remind of the new event
+	return fs.scheduleSla(l, next, true)
+}
+
+func (fs Fsm) cancelMigrateLog(l Log) error {
+	err := fs.cancelPendingBranch(l)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = fs.cancelPendingVote(l)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	l.Approved = constant.FsmLogStatusCancelled
+	l.NextEventId = constant.Zero
+	l.Detail = constant.FsmMsgConfigChanged
+	err = fs.store.UpdateLog(&l)
+	return errors.WithStack(err)
+}
+
+func (fs Fsm) saveMachineVersion(machine Machine) error {
+	err := fs.store.CreateMachineVersion(&MachineVersion{
+		MachineId:                  machine.Id,
+		Version:                    machine.Version,
+		Name:                       machine.Name,
+		SubmitterName:              machine.SubmitterName,
+		SubmitterEditFields:        machine.SubmitterEditFields,
+		SubmitterConfirm:           machine.SubmitterConfirm,
+		SubmitterConfirmEditFields: machine.SubmitterConfirmEditFields,
+		EventsJson:                 machine.EventsJson,
+	})
+	return errors.WithStack(err)
+}
+
+// get machine version snapshot, machine created before versioning has no snapshot, the current config is used
+func (fs Fsm) getMachineVersion(machine Machine, version uint) (*MachineVersion, error) {
+	v, err := fs.store.GetMachineVersion(machine.Id, version)
+	if errors.Is(err, ErrRecordNotFound) && version == machine.Version {
+		return &MachineVersion{
+			MachineId:                  machine.Id,
+			Version:                    machine.Version,
+			Name:                       machine.Name,
+			SubmitterName:              machine.SubmitterName,
+			SubmitterEditFields:        machine.SubmitterEditFields,
+			SubmitterConfirm:           machine.SubmitterConfirm,
+			SubmitterConfirmEditFields: machine.SubmitterConfirmEditFields,
+			EventsJson:                 machine.EventsJson,
+		}, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return v, nil
+}
+
+func findEventByName(events []Event, name string) *Event {
+	for i, event := range events {
+		if event.Name.Name == name {
+			return &events[i]
+		}
+	}
+	return nil
+}
+
+// whether the event can be triggered from progress
+func eventFrom(event Event, progress string) bool {
+	for _, item := range event.Src {
+		if item.Name == progress {
+			return true
+		}
+	}
+	return false
+}
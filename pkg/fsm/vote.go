@@ -0,0 +1,193 @@
+package fsm
+
+import (
+	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/req"
+	"github.com/piupuer/go-helper/pkg/resp"
+	"github.com/piupuer/go-helper/pkg/utils"
+	"github.com/pkg/errors"
+)
+
+// save approver vote to log seat, joined is true when the sign/refuse rule of the event is met
+// approved is the final status of the current level when joined
+func (fs Fsm) approveVote(log Log, r req.FsmApproveLog) (approved uint, joined bool, err error) {
+	approved = uint(r.Approved)
+	if approved != constant.FsmLogStatusApproved && approved != constant.FsmLogStatusRefused {
+		err = errors.Wrap(ErrParams, "approved")
+		return
+	}
+	// one approver can only vote once
+	for _, item := range log.Votes {
+		if item.Approved != constant.FsmLogStatusWaiting && item.ApprovalUserId > constant.Zero && item.ApprovalUserId == r.ApprovalUserId {
+			err = errors.WithStack(ErrRepeatVote)
+			return
+		}
+	}
+	index := fs.findVoteSeat(log, r)
+	if index < 0 {
+		err = errors.WithStack(ErrNoPermissionApprove)
+		return
+	}
+	log.Votes[index].Approved = approved
+	log.Votes[index].ApprovalRoleId = r.ApprovalRoleId
+	log.Votes[index].ApprovalUserId = r.ApprovalUserId
+	log.Votes[index].ApprovalOpinion = r.ApprovalOpinion
+	err = fs.store.SaveLogVote(&log.Votes[index])
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+
+	total := uint(len(log.Votes))
+	var approvedCount, refusedCount, pendingCount uint
+	pending := make([]LogVote, 0)
+	for _, item := range log.Votes {
+		switch item.Approved {
+		case constant.FsmLogStatusApproved:
+			approvedCount++
+		case constant.FsmLogStatusRefused:
+			refusedCount++
+		case constant.FsmLogStatusWaiting:
+			pendingCount++
+			pending = append(pending, item)
+		}
+	}
+	required := total
+	if log.NextEvent.SignMode == constant.FsmSignQuorum && log.NextEvent.Quorum < total {
+		required = log.NextEvent.Quorum
+	}
+	if approvedCount >= required {
+		approved = constant.FsmLogStatusApproved
+		joined = true
+	} else {
+		switch log.NextEvent.RefuseMode {
+		case constant.FsmRefuseMajority:
+			if refusedCount*2 > total || approvedCount+pendingCount < required {
+				approved = constant.FsmLogStatusRefused
+				joined = true
+			}
+		default:
+			if refusedCount > 0 {
+				approved = constant.FsmLogStatusRefused
+				joined = true
+			}
+		}
+	}
+
+	if joined {
+		// the remaining seats no longer need to vote
+		err = fs.cancelPendingVote(log)
+		return
+	}
+	// only approvers of pending seats can approve
+	log.CanApprovalRoles, log.CanApprovalUsers = getVoteApprovers(pending)
+	err = fs.store.ReplaceLogApprover(&log)
+	if err != nil {
+		err = errors.WithStack(err)
+	}
+	return
+}
+
+// find pending seat of approver, approver can act for delegators, user seat is preferred
+func (fs Fsm) findVoteSeat(log Log, r req.FsmApproveLog) int {
+	approverUserIds := fs.getApproverUserIds(log.Category, r.ApprovalUserId)
+	for i, item := range log.Votes {
+		if item.Approved == constant.FsmLogStatusWaiting && item.UserId > constant.Zero && utils.ContainsUint(approverUserIds, item.UserId) {
+			return i
+		}
+	}
+	for i, item := range log.Votes {
+		if item.Approved == constant.FsmLogStatusWaiting && item.RoleId > constant.Zero && item.RoleId == r.ApprovalRoleId {
+			return i
+		}
+	}
+	return -1
+}
+
+func (fs Fsm) cancelPendingVote(log Log) error {
+	for _, item := range log.Votes {
+		if item.Approved != constant.FsmLogStatusWaiting {
+			continue
+		}
+		item.Approved = constant.FsmLogStatusCancelled
+		err := fs.store.SaveLogVote(&item)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// merge approvers of pending seats(remove repeat)
+func getVoteApprovers(votes []LogVote) ([]Role, []User) {
+	roles := make([]Role, 0)
+	users := make([]User, 0)
+	for _, item := range votes {
+		if item.Approved != constant.FsmLogStatusWaiting {
+			continue
+		}
+		if item.RoleId > constant.Zero && !containsRole(roles, item.RoleId) {
+			roles = append(roles, Role{Id: item.RoleId})
+		}
+		if item.UserId > constant.Zero && !containsUser(users, item.UserId) {
+			users = append(users, User{Id: item.UserId})
+		}
+	}
+	return roles, users
+}
+
+// every approver user/role of event is a seat when countersign is enabled
+func getEventSeats(event Event) []LogVote {
+	seats := make([]LogVote, 0)
+	if event.SignMode == constant.FsmSignAny {
+		return seats
+	}
+	for _, user := range event.Users {
+		seats = append(seats, LogVote{
+			UserId: user.Id,
+		})
+	}
+	for _, role := range event.Roles {
+		seats = append(seats, LogVote{
+			RoleId: role.Id,
+		})
+	}
+	return seats
+}
+
+func getLogVotes(log Log) []resp.FsmLogVote {
+	votes := make([]resp.FsmLogVote, 0)
+	for _, item := range log.Votes {
+		votes = append(votes, resp.FsmLogVote{
+			RoleId:         item.RoleId,
+			UserId:         item.UserId,
+			Status:         item.Approved,
+			Opinion:        item.ApprovalOpinion,
+			ApprovalRoleId: item.ApprovalRoleId,
+			ApprovalUserId: item.ApprovalUserId,
+		})
+	}
+	return votes
+}
+
+func checkSign(event req.FsmCreateEvent) error {
+	signMode := uint(event.SignMode)
+	if signMode > constant.FsmSignQuorum {
+		return errors.Wrap(ErrSignMode, event.Name)
+	}
+	if uint(event.RefuseMode) > constant.FsmRefuseMajority {
+		return errors.Wrap(ErrRefuseMode, event.Name)
+	}
+	if signMode == constant.FsmSignAny {
+		return nil
+	}
+	// parallel branches have their own join mode
+	if len(event.Branches) > 0 {
+		return errors.Wrap(ErrSignMode, event.Name)
+	}
+	seats := uint(len(event.Roles.Uints()) + len(event.Users.Uints()))
+	if signMode == constant.FsmSignQuorum && (uint(event.Quorum) == constant.Zero || uint(event.Quorum) > seats) {
+		return errors.Wrap(ErrQuorum, event.Name)
+	}
+	return nil
+}
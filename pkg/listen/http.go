@@ -47,7 +47,7 @@ func Http(options ...func(*HttpOptions)) {
 
 	// https://github.com/gin-gonic/examples/blob/master/graceful-shutdown/graceful-shutdown/server.go
 	// Wait for interrupt signal to gracefully shutdown the server with
-	// a timeout of 5 seconds.
+	// a timeout of WithHttpShutdownTimeout(default 5 seconds).
 	quit := make(chan os.Signal)
 	// kill (no param) default send syscall.SIGTERM
 	// kill -2 is syscall.SIGINT
@@ -59,13 +59,21 @@ func Http(options ...func(*HttpOptions)) {
 	}
 	log.WithContext(ctx).Info("[%s][http server]shutting down...", ops.proName)
 
-	// The context is used to inform the server it has 5 seconds to finish
+	// The context is used to inform the server it has shutdownTimeout seconds to finish
 	// the request it is currently handling
-	_, cancel := context.WithTimeout(ops.ctx, 5*time.Second)
+	timeoutCtx, cancel := context.WithTimeout(ops.ctx, time.Duration(ops.shutdownTimeout)*time.Second)
 	defer cancel()
-	if err := srv.Shutdown(ops.ctx); err != nil {
+	if err := srv.Shutdown(timeoutCtx); err != nil {
 		log.WithContext(ctx).WithError(err).Error("[%s][http server]forced to shutdown failed", ops.proName)
 	}
+	// shutdown background workers after no request is handled, every hook has its own timeout
+	for _, f := range ops.shutdown {
+		hookCtx, hookCancel := context.WithTimeout(ops.ctx, time.Duration(ops.shutdownTimeout)*time.Second)
+		if err := f(hookCtx); err != nil {
+			log.WithContext(ctx).WithError(err).Error("[%s][http server]shutdown hook failed", ops.proName)
+		}
+		hookCancel()
+	}
 
 	log.WithContext(ctx).Info("[%s][http server]exiting", ops.proName)
 }
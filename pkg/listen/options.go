@@ -17,6 +17,9 @@ type HttpOptions struct {
 	proName   string
 	handler   http.Handler
 	exit      func()
+	// called after http server is shutdown, such as delay.Queue.Shutdown
+	shutdown        []func(ctx context.Context) error
+	shutdownTimeout int
 }
 
 func WithHttpCtx(ctx context.Context) func(*HttpOptions) {
@@ -71,14 +74,32 @@ func WithHttpExit(f func()) func(*HttpOptions) {
 	}
 }
 
+func WithHttpShutdown(f func(ctx context.Context) error) func(*HttpOptions) {
+	return func(options *HttpOptions) {
+		if f != nil {
+			getHttpOptionsOrSetDefault(options).shutdown = append(getHttpOptionsOrSetDefault(options).shutdown, f)
+		}
+	}
+}
+
+// timeout of http server shutdown and each shutdown hook
+func WithHttpShutdownTimeout(second int) func(*HttpOptions) {
+	return func(options *HttpOptions) {
+		if second > 0 {
+			getHttpOptionsOrSetDefault(options).shutdownTimeout = second
+		}
+	}
+}
+
 func getHttpOptionsOrSetDefault(options *HttpOptions) *HttpOptions {
 	if options == nil {
 		return &HttpOptions{
-			ctx:       context.Background(),
-			host:      "0.0.0.0",
-			port:      8080,
-			urlPrefix: "api",
-			proName:   "project",
+			ctx:             context.Background(),
+			host:            "0.0.0.0",
+			port:            8080,
+			urlPrefix:       "api",
+			proName:         "project",
+			shutdownTimeout: 5,
 		}
 	}
 	return options
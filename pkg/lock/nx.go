@@ -3,9 +3,18 @@ package lock
 import (
 	"context"
 	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
 	"time"
 )
 
+// delete key only if it is still held by token
+var nxUnlockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+    return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
 type NxLock struct {
 	Redis      redis.UniversalClient
 	Key        string
@@ -36,3 +45,31 @@ func (nl NxLock) Unlock() {
 	nl.Redis.Del(context.Background(), nl.Key)
 	return
 }
+
+// LockWithToken lock with unique token, the lock may expire and be acquired by others, it is released only by the token
+func (nl NxLock) LockWithToken() (token string, ok bool) {
+	if nl.Redis == nil {
+		return
+	}
+	if nl.Key == "" {
+		return
+	}
+	if nl.Expiration == 0 {
+		nl.Expiration = time.Minute
+	}
+	token = uuid.NewString()
+	ok, _ = nl.Redis.SetNX(context.Background(), nl.Key, token, nl.Expiration).Result()
+	return
+}
+
+// UnlockWithToken release lock acquired by LockWithToken
+func (nl NxLock) UnlockWithToken(token string) {
+	if nl.Redis == nil {
+		return
+	}
+	if nl.Key == "" {
+		return
+	}
+	nxUnlockScript.Run(context.Background(), nl.Redis, []string{nl.Key}, token)
+	return
+}
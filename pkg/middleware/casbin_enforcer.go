@@ -0,0 +1,272 @@
+package middleware
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"github.com/casbin/casbin/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/piupuer/go-helper/pkg/log"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Enforcer casbin enforcer safe for concurrent use, decisions are cached until policy is changed,
+// policy changes are broadcast by redis pub/sub(WithEnforcerRedis) so that other instances reload policy
+type Enforcer struct {
+	*casbin.SyncedEnforcer
+	ops    EnforcerOptions
+	id     string
+	cache  *enforcerCache
+	pubsub *redis.PubSub
+}
+
+type enforcerDecision struct {
+	key     string
+	pass    bool
+	version uint64
+	expire  int64
+}
+
+// lru cache of decisions
+type enforcerCache struct {
+	lock  sync.Mutex
+	size  int
+	items map[string]*list.Element
+	lru   *list.List
+	// policy version, decision of old version is not cached
+	version uint64
+}
+
+func NewEnforcer(e *casbin.SyncedEnforcer, options ...func(*EnforcerOptions)) (*Enforcer, error) {
+	ops := getEnforcerOptionsOrSetDefault(nil)
+	for _, f := range options {
+		f(ops)
+	}
+	if e == nil {
+		return nil, errors.Errorf("casbin enforcer is empty")
+	}
+	enforcer := &Enforcer{
+		SyncedEnforcer: e,
+		ops:            *ops,
+		id:             uuid.NewString(),
+		cache:          newEnforcerCache(ops.cacheSize),
+	}
+	if ops.redis != nil {
+		enforcer.pubsub = ops.redis.Subscribe(ops.ctx, ops.channel)
+		// wait for subscription created, no update is missed after NewEnforcer
+		_, err := enforcer.pubsub.Receive(ops.ctx)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		go enforcer.watch()
+	}
+	// policy changes(AddPolicy/RemovePolicies etc.) call Update of watcher
+	err := e.SetWatcher(enforcerWatcher{
+		e: enforcer,
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return enforcer, nil
+}
+
+var (
+	wrappedLock      sync.Mutex
+	wrappedEnforcers = make(map[*casbin.Enforcer]*Enforcer)
+)
+
+// WrapEnforcer wrap casbin.Enforcer as Enforcer without redis watcher,
+// the same casbin.Enforcer is wrapped only once so that policy changes invalidate cached decisions of all users
+func WrapEnforcer(e *casbin.Enforcer) (*Enforcer, error) {
+	if e == nil {
+		return nil, errors.Errorf("casbin enforcer is empty")
+	}
+	wrappedLock.Lock()
+	defer wrappedLock.Unlock()
+	if enforcer, ok := wrappedEnforcers[e]; ok {
+		return enforcer, nil
+	}
+	// synced enforcer without params has no model/adapter, it shares the given enforcer
+	synced, err := casbin.NewSyncedEnforcer()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	synced.Enforcer = e
+	enforcer, err := NewEnforcer(synced)
+	if err != nil {
+		return nil, err
+	}
+	wrappedEnforcers[e] = enforcer
+	return enforcer, nil
+}
+
+// Enforce decide whether a subject can access an object with the action, the decision is cached
+func (e *Enforcer) Enforce(rvals ...interface{}) (pass bool, err error) {
+	key := enforcerCacheKey(rvals...)
+	now := time.Now().Unix()
+	pass, version, ok := e.cache.get(key, now)
+	if ok {
+		return
+	}
+	pass, err = e.SyncedEnforcer.Enforce(rvals...)
+	if err != nil {
+		return
+	}
+	item := enforcerDecision{
+		key:     key,
+		pass:    pass,
+		version: version,
+	}
+	if e.ops.cacheExpire > 0 {
+		item.expire = now + int64(e.ops.cacheExpire)
+	}
+	e.cache.set(item)
+	return
+}
+
+// HasDomain policy has domain(p = sub, dom, obj, act), refer to constant.MiddlewareCasbinDomainModel
+func (e *Enforcer) HasDomain() bool {
+	p, ok := e.GetModel()["p"]["p"]
+	if !ok {
+		return false
+	}
+	for _, token := range p.Tokens {
+		if token == "p_dom" {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadPolicy reload policy from adapter and invalidate cached decisions
+func (e *Enforcer) LoadPolicy() (err error) {
+	err = e.SyncedEnforcer.LoadPolicy()
+	e.InvalidateCache()
+	return
+}
+
+// InvalidateCache remove cached decisions
+func (e *Enforcer) InvalidateCache() {
+	e.cache.clear()
+}
+
+// Close stop watching policy changes
+func (e *Enforcer) Close() {
+	if e.pubsub != nil {
+		e.pubsub.Close()
+	}
+}
+
+// reload policy when it is changed by other instances
+func (e *Enforcer) watch() {
+	for msg := range e.pubsub.Channel() {
+		if msg.Payload == e.id {
+			continue
+		}
+		err := e.LoadPolicy()
+		if err != nil {
+			log.WithContext(e.ops.ctx).WithError(err).Error("reload casbin policy failed")
+		}
+	}
+}
+
+// enforcerWatcher implement persist.Watcher
+type enforcerWatcher struct {
+	e *Enforcer
+}
+
+// SetUpdateCallback policy of other instances is always reloaded by Enforcer.LoadPolicy
+func (w enforcerWatcher) SetUpdateCallback(func(string)) error {
+	return nil
+}
+
+// Update policy is changed by this instance
+func (w enforcerWatcher) Update() (err error) {
+	w.e.InvalidateCache()
+	if w.e.ops.redis == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(w.e.ops.ctx, 5*time.Second)
+	defer cancel()
+	err = errors.WithStack(w.e.ops.redis.Publish(ctx, w.e.ops.channel, w.e.id).Err())
+	return
+}
+
+func (w enforcerWatcher) Close() {
+	w.e.Close()
+}
+
+// values are length-prefixed so that a separator inside value can not make two requests share a key,
+// such as ["alice bob", "/x", "GET"] and ["alice", "bob /x", "GET"]
+func enforcerCacheKey(rvals ...interface{}) string {
+	var b strings.Builder
+	for _, v := range rvals {
+		s := fmt.Sprintf("%v", v)
+		b.WriteString(strconv.Itoa(len(s)))
+		b.WriteByte(':')
+		b.WriteString(s)
+	}
+	return b.String()
+}
+
+func newEnforcerCache(size int) *enforcerCache {
+	return &enforcerCache{
+		size:  size,
+		items: make(map[string]*list.Element),
+		lru:   list.New(),
+	}
+}
+
+// get cached decision and current policy version
+func (c *enforcerCache) get(key string, now int64) (pass bool, version uint64, ok bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	version = c.version
+	el, exists := c.items[key]
+	if !exists {
+		return
+	}
+	item := el.Value.(enforcerDecision)
+	if item.expire > 0 && item.expire <= now {
+		c.lru.Remove(el)
+		delete(c.items, key)
+		return
+	}
+	c.lru.MoveToFront(el)
+	pass = item.pass
+	ok = true
+	return
+}
+
+// decision is dropped if policy is changed while enforcing
+func (c *enforcerCache) set(item enforcerDecision) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if item.version != c.version {
+		return
+	}
+	if el, exists := c.items[item.key]; exists {
+		el.Value = item
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.items[item.key] = c.lru.PushFront(item)
+	for c.lru.Len() > c.size {
+		el := c.lru.Back()
+		c.lru.Remove(el)
+		delete(c.items, el.Value.(enforcerDecision).key)
+	}
+}
+
+func (c *enforcerCache) clear() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.version++
+	c.items = make(map[string]*list.Element)
+	c.lru.Init()
+}
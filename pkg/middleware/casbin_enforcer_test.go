@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"testing"
+)
+
+func TestEnforcerCacheKey(t *testing.T) {
+	cases := []struct {
+		a    []interface{}
+		b    []interface{}
+		same bool
+	}{
+		{[]interface{}{"alice", "/x", "GET"}, []interface{}{"alice", "/x", "GET"}, true},
+		{[]interface{}{"alice bob", "/x", "GET"}, []interface{}{"alice", "bob /x", "GET"}, false},
+		{[]interface{}{"alice", "dom", "/x", "GET"}, []interface{}{"alice", "/x", "GET"}, false},
+	}
+	for _, item := range cases {
+		if same := enforcerCacheKey(item.a...) == enforcerCacheKey(item.b...); same != item.same {
+			t.Errorf("enforcerCacheKey(%v) == enforcerCacheKey(%v) = %v, want %v", item.a, item.b, same, item.same)
+		}
+	}
+}
+
+func TestEnforcerCache(t *testing.T) {
+	cases := []struct {
+		name string
+		run  func(c *enforcerCache) (pass, ok bool)
+		pass bool
+		ok   bool
+	}{
+		{
+			name: "hit",
+			run: func(c *enforcerCache) (bool, bool) {
+				c.set(enforcerDecision{key: "a", pass: true})
+				pass, _, ok := c.get("a", 0)
+				return pass, ok
+			},
+			pass: true,
+			ok:   true,
+		},
+		{
+			name: "miss",
+			run: func(c *enforcerCache) (bool, bool) {
+				pass, _, ok := c.get("a", 0)
+				return pass, ok
+			},
+		},
+		{
+			name: "expired",
+			run: func(c *enforcerCache) (bool, bool) {
+				c.set(enforcerDecision{key: "a", pass: true, expire: 100})
+				pass, _, ok := c.get("a", 100)
+				return pass, ok
+			},
+		},
+		{
+			name: "lru evicted",
+			run: func(c *enforcerCache) (bool, bool) {
+				c.set(enforcerDecision{key: "a", pass: true})
+				c.set(enforcerDecision{key: "b", pass: true})
+				// a is used recently, b is evicted
+				c.get("a", 0)
+				c.set(enforcerDecision{key: "c", pass: true})
+				pass, _, ok := c.get("b", 0)
+				return pass, ok
+			},
+		},
+		{
+			name: "lru kept",
+			run: func(c *enforcerCache) (bool, bool) {
+				c.set(enforcerDecision{key: "a", pass: true})
+				c.set(enforcerDecision{key: "b", pass: true})
+				c.get("a", 0)
+				c.set(enforcerDecision{key: "c", pass: true})
+				pass, _, ok := c.get("a", 0)
+				return pass, ok
+			},
+			pass: true,
+			ok:   true,
+		},
+		{
+			name: "cleared",
+			run: func(c *enforcerCache) (bool, bool) {
+				c.set(enforcerDecision{key: "a", pass: true})
+				c.clear()
+				pass, _, ok := c.get("a", 0)
+				return pass, ok
+			},
+		},
+		{
+			name: "stale version",
+			run: func(c *enforcerCache) (bool, bool) {
+				_, version, _ := c.get("a", 0)
+				// policy is changed while enforcing
+				c.clear()
+				c.set(enforcerDecision{key: "a", pass: true, version: version})
+				pass, _, ok := c.get("a", 0)
+				return pass, ok
+			},
+		},
+	}
+	for _, item := range cases {
+		pass, ok := item.run(newEnforcerCache(2))
+		if pass != item.pass || ok != item.ok {
+			t.Errorf("%s: get() = %v, %v, want %v, %v", item.name, pass, ok, item.pass, item.ok)
+		}
+	}
+}
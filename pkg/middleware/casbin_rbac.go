@@ -2,10 +2,10 @@ package middleware
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/piupuer/go-helper/ms"
 	"github.com/piupuer/go-helper/pkg/resp"
 	"github.com/piupuer/go-helper/pkg/tracing"
 	"strings"
-	"sync"
 )
 
 func Casbin(options ...func(*CasbinOptions)) gin.HandlerFunc {
@@ -13,23 +13,25 @@ func Casbin(options ...func(*CasbinOptions)) gin.HandlerFunc {
 	for _, f := range options {
 		f(ops)
 	}
-	if ops.Enforcer == nil {
+	if ops.CachedEnforcer == nil {
 		panic("casbin Enforcer is empty")
 	}
-	if ops.Enforcer == nil {
+	if ops.getCurrentUser == nil {
 		panic("casbin getCurrentUser is empty")
 	}
+	if ops.getDomain != nil && !ops.CachedEnforcer.HasDomain() {
+		panic("casbin model has no domain")
+	}
 	return func(c *gin.Context) {
 		ctx := tracing.RealCtx(c)
 		_, span := tracer.Start(ctx, tracing.Name(tracing.Middleware, "Casbin"))
 		defer span.End()
-		// get role.key as subject
-		sub := ops.getCurrentUser(c)
+		user := ops.getCurrentUser(c)
 		// request path as object
 		obj := strings.Replace(c.Request.URL.Path, "/"+ops.urlPrefix, "", 1)
 		// request method as action
 		act := c.Request.Method
-		if !check(sub.RoleKeyword, obj, act, *ops) {
+		if !check(c, user, obj, act, *ops) {
 			ops.failWithCode(resp.Forbidden)
 			return
 		}
@@ -37,11 +39,22 @@ func Casbin(options ...func(*CasbinOptions)) gin.HandlerFunc {
 	}
 }
 
-var checkLock sync.Mutex
-
-func check(sub, obj, act string, ops CasbinOptions) bool {
-	checkLock.Lock()
-	defer checkLock.Unlock()
-	pass, _ := ops.Enforcer.Enforce(sub, obj, act)
-	return pass
+// without domain, role keywords of user are subjects, user passes if any role passes
+// with domain, username is subject, roles of user in the domain are resolved by grouping policy(g, username, role, domain)
+func check(c *gin.Context, user ms.User, obj, act string, ops CasbinOptions) bool {
+	if ops.getDomain != nil {
+		dom := ops.getDomain(c)
+		if user.Username == "" || dom == "" {
+			return false
+		}
+		pass, _ := ops.CachedEnforcer.Enforce(user.Username, dom, obj, act)
+		return pass
+	}
+	for _, sub := range user.Roles() {
+		pass, _ := ops.CachedEnforcer.Enforce(sub, obj, act)
+		if pass {
+			return true
+		}
+	}
+	return false
 }
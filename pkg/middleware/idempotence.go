@@ -1,13 +1,19 @@
 package middleware
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/piupuer/go-helper/pkg/constant"
 	"github.com/piupuer/go-helper/pkg/log"
 	"github.com/piupuer/go-helper/pkg/resp"
 	"github.com/piupuer/go-helper/pkg/tracing"
+	"github.com/piupuer/go-helper/pkg/utils"
+	"net/http"
 	"strings"
 	"time"
 )
@@ -34,6 +40,13 @@ func Idempotence(options ...func(*IdempotenceOptions)) gin.HandlerFunc {
 		ctx := tracing.RealCtx(c)
 		_, span := tracer.Start(ctx, tracing.Name(tracing.Middleware, "Idempotence"))
 		defer span.End()
+		if ops.key {
+			key := strings.TrimSpace(c.Request.Header.Get(ops.keyName))
+			if key != "" {
+				idempotenceByKey(c, key, *ops)
+				return
+			}
+		}
 		// read token from header at first
 		token := c.Request.Header.Get(ops.tokenName)
 		if token == "" {
@@ -95,3 +108,133 @@ func CheckIdempotenceToken(ctx context.Context, token string, ops IdempotenceOpt
 	}
 	return true
 }
+
+// request and response of idempotency key
+type idempotenceRecord struct {
+	Fingerprint string `json:"fingerprint"`
+	// false if request is in-flight
+	Done        bool   `json:"done"`
+	Status      int    `json:"status"`
+	ContentType string `json:"contentType"`
+	Body        []byte `json:"body"`
+}
+
+// handle request only once by client-supplied key, the response is saved and replayed to retries of the same request
+func idempotenceByKey(c *gin.Context, key string, ops IdempotenceOptions) {
+	if ops.redis == nil {
+		log.WithContext(c).Warn("please enable redis, otherwise the idempotence is invalid")
+		c.Next()
+		return
+	}
+	// the same key of different callers is not shared
+	cacheKey := fmt.Sprintf("%s_key_%s_%s", ops.cachePrefix, idempotenceCaller(c), key)
+	record := idempotenceRecord{
+		Fingerprint: idempotenceFingerprint(c),
+	}
+	ok, err := ops.redis.SetNX(c, cacheKey, utils.Struct2Json(record), time.Duration(ops.keyLockExpire)*time.Second).Result()
+	if err != nil {
+		log.WithContext(c).WithError(err).Error("lock idempotency key %s failed", key)
+		ops.failWithMsg(resp.InternalServerErrorMsg)
+		return
+	}
+	if !ok {
+		replayIdempotenceKey(c, cacheKey, record.Fingerprint, ops)
+		return
+	}
+	w := &accessWriter{
+		body:           bytes.NewBuffer(nil),
+		ResponseWriter: c.Writer,
+	}
+	c.Writer = w
+	defer func() {
+		err := recover()
+		saved := false
+		if rp, ok := err.(resp.Resp); ok {
+			// response is written by Transaction/ExceptionWithNoTransaction, save what they will write
+			if rp.Code != resp.InternalServerError {
+				rp.RequestId, _, _ = tracing.GetId(c)
+				record.Status = http.StatusOK
+				record.ContentType = "application/json; charset=utf-8"
+				record.Body = []byte(utils.Struct2Json(rp))
+				saved = saveIdempotenceKey(c, cacheKey, record, ops)
+			}
+		} else if err == nil && w.Status() < http.StatusInternalServerError {
+			record.Status = w.Status()
+			record.ContentType = w.Header().Get("Content-Type")
+			record.Body = w.body.Bytes()
+			saved = saveIdempotenceKey(c, cacheKey, record, ops)
+		}
+		if !saved {
+			// server error is not saved, client can retry with the same key
+			ops.redis.Del(context.Background(), cacheKey)
+		}
+		if err != nil {
+			panic(err)
+		}
+	}()
+	c.Next()
+}
+
+func replayIdempotenceKey(c *gin.Context, cacheKey, fingerprint string, ops IdempotenceOptions) {
+	var record idempotenceRecord
+	v, err := ops.redis.Get(c, cacheKey).Result()
+	if err == nil {
+		utils.Json2Struct(v, &record)
+	}
+	if err == nil && record.Fingerprint != fingerprint {
+		abortWithCode(c, http.StatusUnprocessableEntity, resp.IdempotenceKeyMismatchMsg)
+		return
+	}
+	if err != nil || !record.Done {
+		// in-flight or just released by server error
+		abortWithCode(c, http.StatusConflict, resp.IdempotenceKeyConflictMsg)
+		return
+	}
+	c.Header(constant.MiddlewareIdempotenceReplayedHeaderKey, "true")
+	c.Data(record.Status, record.ContentType, record.Body)
+	c.Abort()
+}
+
+func saveIdempotenceKey(c *gin.Context, cacheKey string, record idempotenceRecord, ops IdempotenceOptions) bool {
+	record.Done = true
+	err := ops.redis.Set(context.Background(), cacheKey, utils.Struct2Json(record), time.Duration(ops.expire)*time.Hour).Err()
+	if err != nil {
+		log.WithContext(c).WithError(err).Warn("save idempotency key response failed")
+		return false
+	}
+	return true
+}
+
+// the same key must be used by the same request
+func idempotenceFingerprint(c *gin.Context) string {
+	b := bytes.NewBuffer(nil)
+	b.WriteString(c.Request.Method)
+	b.WriteString(constant.MiddlewareSignSeparator)
+	b.WriteString(c.Request.URL.RequestURI())
+	b.WriteString(constant.MiddlewareSignSeparator)
+	b.WriteString(getBody(c))
+	sum := sha256.Sum256(b.Bytes())
+	return hex.EncodeToString(sum[:])
+}
+
+// jwt user/sign app of request, hash of authorization header or ip is used if middleware of them is not used
+func idempotenceCaller(c *gin.Context) string {
+	if userId := c.GetInt64(constant.MiddlewareJwtUserCtxKey); userId > 0 {
+		return fmt.Sprintf("user:%d", userId)
+	}
+	if appId := c.GetString(constant.MiddlewareSignAppIdCtxKey); appId != "" {
+		return "app:" + appId
+	}
+	if auth := c.Request.Header.Get("Authorization"); auth != "" {
+		sum := sha256.Sum256([]byte(auth))
+		return "auth:" + hex.EncodeToString(sum[:])
+	}
+	return "ip:" + c.ClientIP()
+}
+
+func abortWithCode(c *gin.Context, code int, msg string) {
+	rp := resp.GetFailWithCodeAndMsg(code, msg)
+	rp.RequestId, _, _ = tracing.GetId(c)
+	c.JSON(code, rp)
+	c.Abort()
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/piupuer/go-helper/pkg/constant"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestContext(method, uri, body string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(method, uri, strings.NewReader(body))
+	return c
+}
+
+func TestIdempotenceFingerprint(t *testing.T) {
+	base := idempotenceFingerprint(newTestContext(http.MethodPost, "/api/v1/order?a=1", `{"amount":1}`))
+	cases := []struct {
+		method string
+		uri    string
+		body   string
+		same   bool
+	}{
+		{http.MethodPost, "/api/v1/order?a=1", `{"amount":1}`, true},
+		{http.MethodPut, "/api/v1/order?a=1", `{"amount":1}`, false},
+		{http.MethodPost, "/api/v1/order?a=2", `{"amount":1}`, false},
+		{http.MethodPost, "/api/v1/order?a=1", `{"amount":2}`, false},
+	}
+	for _, item := range cases {
+		fingerprint := idempotenceFingerprint(newTestContext(item.method, item.uri, item.body))
+		if (fingerprint == base) != item.same {
+			t.Errorf("idempotenceFingerprint(%s %s %s) same = %v, want %v", item.method, item.uri, item.body, fingerprint == base, item.same)
+		}
+	}
+}
+
+func TestIdempotenceCaller(t *testing.T) {
+	cases := []struct {
+		userId int64
+		appId  string
+		auth   string
+		prefix string
+	}{
+		{1, "app", "Bearer token", "user:1"},
+		{0, "app", "Bearer token", "app:app"},
+		{0, "", "Bearer token", "auth:"},
+		{0, "", "", "ip:"},
+	}
+	for _, item := range cases {
+		c := newTestContext(http.MethodPost, "/api/v1/order", "")
+		if item.userId > 0 {
+			c.Set(constant.MiddlewareJwtUserCtxKey, item.userId)
+		}
+		if item.appId != "" {
+			c.Set(constant.MiddlewareSignAppIdCtxKey, item.appId)
+		}
+		if item.auth != "" {
+			c.Request.Header.Set("Authorization", item.auth)
+		}
+		caller := idempotenceCaller(c)
+		if !strings.HasPrefix(caller, item.prefix) {
+			t.Errorf("idempotenceCaller() = %s, want prefix %s", caller, item.prefix)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package middleware
 
 import (
 	"context"
+	"fmt"
+	jwt "github.com/appleboy/gin-jwt/v2"
 	"github.com/casbin/casbin/v2"
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
@@ -125,7 +127,10 @@ type CasbinOptions struct {
 	urlPrefix      string
 	getCurrentUser func(c *gin.Context) ms.User
 	Enforcer       *casbin.Enforcer
+	// Enforcer wrapped by WrapEnforcer or passed by WithCasbinCachedEnforcer, decisions are enforced by it
+	CachedEnforcer *Enforcer
 	failWithCode   func(code int)
+	getDomain      func(c *gin.Context) string
 }
 
 func WithCasbinUrlPrefix(prefix string) func(*CasbinOptions) {
@@ -142,14 +147,63 @@ func WithCasbinGetCurrentUser(fun func(c *gin.Context) ms.User) func(*CasbinOpti
 	}
 }
 
+// casbin.Enforcer is wrapped by WrapEnforcer, use WithCasbinCachedEnforcer to watch policy changes of other instances
 func WithCasbinEnforcer(enforcer *casbin.Enforcer) func(*CasbinOptions) {
 	return func(options *CasbinOptions) {
 		if enforcer != nil {
+			e, err := WrapEnforcer(enforcer)
+			if err != nil {
+				panic(fmt.Sprintf("wrap casbin enforcer failed: %v", err))
+			}
 			getCasbinOptionsOrSetDefault(options).Enforcer = enforcer
+			getCasbinOptionsOrSetDefault(options).CachedEnforcer = e
 		}
 	}
 }
 
+func WithCasbinCachedEnforcer(enforcer *Enforcer) func(*CasbinOptions) {
+	return func(options *CasbinOptions) {
+		if enforcer != nil {
+			getCasbinOptionsOrSetDefault(options).Enforcer = enforcer.SyncedEnforcer.Enforcer
+			getCasbinOptionsOrSetDefault(options).CachedEnforcer = enforcer
+		}
+	}
+}
+
+// enforce with domain(tenant) of request, Enforcer model must have domain
+func WithCasbinDomain(fun func(c *gin.Context) string) func(*CasbinOptions) {
+	return func(options *CasbinOptions) {
+		if fun != nil {
+			getCasbinOptionsOrSetDefault(options).getDomain = fun
+		}
+	}
+}
+
+// domain is taken from request header
+func WithCasbinDomainHeader(key string) func(*CasbinOptions) {
+	return WithCasbinDomain(func(c *gin.Context) string {
+		return c.GetHeader(key)
+	})
+}
+
+// domain is taken from path param, such as /tenant/:tenant/xxx
+func WithCasbinDomainParam(name string) func(*CasbinOptions) {
+	return WithCasbinDomain(func(c *gin.Context) string {
+		return c.Param(name)
+	})
+}
+
+// domain is taken from jwt claim
+func WithCasbinDomainClaim(key string) func(*CasbinOptions) {
+	return WithCasbinDomain(func(c *gin.Context) string {
+		v, ok := jwt.ExtractClaims(c)[key]
+		if !ok || v == nil {
+			return ""
+		}
+		return fmt.Sprintf("%v", v)
+	})
+}
+
 func WithCasbinFailWithCode(fun func(code int)) func(*CasbinOptions) {
 	return func(options *CasbinOptions) {
 		if fun != nil {
@@ -175,14 +229,80 @@ func getCasbinOptionsOrSetDefault(options *CasbinOptions) *CasbinOptions {
 	return options
 }
 
+type EnforcerOptions struct {
+	ctx         context.Context
+	redis       redis.UniversalClient
+	channel     string
+	cacheExpire int
+	cacheSize   int
+}
+
+func WithEnforcerCtx(ctx context.Context) func(*EnforcerOptions) {
+	return func(options *EnforcerOptions) {
+		if !utils.InterfaceIsNil(ctx) {
+			getEnforcerOptionsOrSetDefault(options).ctx = ctx
+		}
+	}
+}
+
+// policy changes are broadcast by redis pub/sub
+func WithEnforcerRedis(rd redis.UniversalClient) func(*EnforcerOptions) {
+	return func(options *EnforcerOptions) {
+		if rd != nil {
+			getEnforcerOptionsOrSetDefault(options).redis = rd
+		}
+	}
+}
+
+func WithEnforcerChannel(channel string) func(*EnforcerOptions) {
+	return func(options *EnforcerOptions) {
+		if channel != "" {
+			getEnforcerOptionsOrSetDefault(options).channel = channel
+		}
+	}
+}
+
+// cached decision expires after second, 0 means never(until policy is changed)
+func WithEnforcerCacheExpire(second int) func(*EnforcerOptions) {
+	return func(options *EnforcerOptions) {
+		if second >= 0 {
+			getEnforcerOptionsOrSetDefault(options).cacheExpire = second
+		}
+	}
+}
+
+// max count of cached decisions, the least recently used one is removed when cache is full
+func WithEnforcerCacheSize(count int) func(*EnforcerOptions) {
+	return func(options *EnforcerOptions) {
+		if count > 0 {
+			getEnforcerOptionsOrSetDefault(options).cacheSize = count
+		}
+	}
+}
+
+func getEnforcerOptionsOrSetDefault(options *EnforcerOptions) *EnforcerOptions {
+	if options == nil {
+		return &EnforcerOptions{
+			ctx:         context.Background(),
+			channel:     constant.MiddlewareCasbinWatcherChannel,
+			cacheExpire: 300,
+			cacheSize:   10000,
+		}
+	}
+	return options
+}
+
 type SignOptions struct {
 	expire       string
 	findSkipPath func(c *gin.Context) []string
 	getSignUser  func(c *gin.Context, appId string) ms.SignUser
 	headerKey    []string
 	checkScope   bool
+	redis        redis.UniversalClient
+	noncePrefix  string
 }
 
+// WithSignExpire timestamp expire duration of time.ParseDuration, such as 60s, 5m
 func WithSignExpire(duration string) func(*SignOptions) {
 	return func(options *SignOptions) {
 		getSignOptionsOrSetDefault(options).expire = duration
@@ -218,6 +338,11 @@ func WithSignHeaderKey(arr ...string) func(*SignOptions) {
 			getSignOptionsOrSetDefault(options).headerKey[1] = arr[1]
 			getSignOptionsOrSetDefault(options).headerKey[2] = arr[2]
 		case 4:
+			getSignOptionsOrSetDefault(options).headerKey[0] = arr[0]
+			getSignOptionsOrSetDefault(options).headerKey[1] = arr[1]
+			getSignOptionsOrSetDefault(options).headerKey[2] = arr[2]
+			getSignOptionsOrSetDefault(options).headerKey[3] = arr[3]
+		case 5:
 			getSignOptionsOrSetDefault(options).headerKey = arr
 		}
 	}
@@ -229,6 +354,23 @@ func WithSignCheckScope(flag bool) func(*SignOptions) {
 	}
 }
 
+// WithSignRedis nonce is required and saved in redis, the same nonce of app id is rejected before expire
+func WithSignRedis(rd redis.UniversalClient) func(*SignOptions) {
+	return func(options *SignOptions) {
+		if rd != nil {
+			getSignOptionsOrSetDefault(options).redis = rd
+		}
+	}
+}
+
+func WithSignNoncePrefix(prefix string) func(*SignOptions) {
+	return func(options *SignOptions) {
+		if prefix != "" {
+			getSignOptionsOrSetDefault(options).noncePrefix = prefix
+		}
+	}
+}
+
 func getSignOptionsOrSetDefault(options *SignOptions) *SignOptions {
 	if options == nil {
 		return &SignOptions{
@@ -238,8 +380,10 @@ func getSignOptionsOrSetDefault(options *SignOptions) *SignOptions {
 				constant.MiddlewareSignAppIdHeaderKey,
 				constant.MiddlewareSignTimestampHeaderKey,
 				constant.MiddlewareSignSignatureHeaderKey,
+				constant.MiddlewareSignNonceHeaderKey,
 			},
-			checkScope: true,
+			checkScope:  true,
+			noncePrefix: constant.MiddlewareSignNoncePrefix,
 		}
 	}
 	return options
@@ -250,6 +394,9 @@ type IdempotenceOptions struct {
 	cachePrefix     string
 	expire          int
 	tokenName       string
+	key             bool
+	keyName         string
+	keyLockExpire   int
 	successWithData func(...interface{})
 	failWithMsg     func(format interface{}, a ...interface{})
 }
@@ -282,6 +429,31 @@ func WithIdempotenceTokenName(name string) func(*IdempotenceOptions) {
 	}
 }
 
+// WithIdempotenceKey requests with client-supplied key(WithIdempotenceKeyName) are handled only once,
+// the saved response is replayed for the same key of the same caller(jwt user/sign app/authorization), requests without key still check token
+func WithIdempotenceKey(flag bool) func(*IdempotenceOptions) {
+	return func(options *IdempotenceOptions) {
+		getIdempotenceOptionsOrSetDefault(options).key = flag
+	}
+}
+
+func WithIdempotenceKeyName(name string) func(*IdempotenceOptions) {
+	return func(options *IdempotenceOptions) {
+		if name != "" {
+			getIdempotenceOptionsOrSetDefault(options).keyName = name
+		}
+	}
+}
+
+// WithIdempotenceKeyLockExpire max seconds of in-flight request, duplicate requests get 409 before it is finished
+func WithIdempotenceKeyLockExpire(second int) func(*IdempotenceOptions) {
+	return func(options *IdempotenceOptions) {
+		if second > 0 {
+			getIdempotenceOptionsOrSetDefault(options).keyLockExpire = second
+		}
+	}
+}
+
 func WithIdempotenceSuccessWithData(fun func(...interface{})) func(*IdempotenceOptions) {
 	return func(options *IdempotenceOptions) {
 		if fun != nil {
@@ -312,6 +484,8 @@ func getIdempotenceOptionsOrSetDefault(options *IdempotenceOptions) *Idempotence
 			cachePrefix:     constant.MiddlewareIdempotencePrefix,
 			expire:          constant.MiddlewareIdempotenceExpire,
 			tokenName:       constant.MiddlewareIdempotenceTokenName,
+			keyName:         constant.MiddlewareIdempotenceKeyName,
+			keyLockExpire:   60,
 			successWithData: resp.SuccessWithData,
 			failWithMsg:     resp.FailWithMsg,
 		}
@@ -567,8 +741,13 @@ func getOperationLogOptionsOrSetDefault(options *OperationLogOptions) *Operation
 }
 
 type RateOptions struct {
-	redis    redis.UniversalClient
-	maxLimit int64
+	redis          redis.UniversalClient
+	maxLimit       int64
+	rules          []RateRule
+	prefix         string
+	ruleKey        string
+	refresh        int
+	getCurrentUser func(c *gin.Context) ms.User
 }
 
 func WithRateRedis(rd redis.UniversalClient) func(*RateOptions) {
@@ -579,6 +758,7 @@ func WithRateRedis(rd redis.UniversalClient) func(*RateOptions) {
 	}
 }
 
+// WithRateMaxLimit max requests per second of each ip, it is the default rule
 func WithRateMaxLimit(limit int64) func(*RateOptions) {
 	return func(options *RateOptions) {
 		if limit > 0 {
@@ -587,10 +767,55 @@ func WithRateMaxLimit(limit int64) func(*RateOptions) {
 	}
 }
 
+// WithRateRule initial rules, rules saved in redis are not overwritten
+func WithRateRule(rules ...RateRule) func(*RateOptions) {
+	return func(options *RateOptions) {
+		getRateOptionsOrSetDefault(options).rules = append(getRateOptionsOrSetDefault(options).rules, rules...)
+	}
+}
+
+func WithRatePrefix(prefix string) func(*RateOptions) {
+	return func(options *RateOptions) {
+		getRateOptionsOrSetDefault(options).prefix = prefix
+	}
+}
+
+func WithRateRuleKey(key string) func(*RateOptions) {
+	return func(options *RateOptions) {
+		if key != "" {
+			getRateOptionsOrSetDefault(options).ruleKey = key
+		}
+	}
+}
+
+// WithRateRefresh seconds of reloading rules from redis
+func WithRateRefresh(second int) func(*RateOptions) {
+	return func(options *RateOptions) {
+		if second > 0 {
+			getRateOptionsOrSetDefault(options).refresh = second
+		}
+	}
+}
+
+// WithRateGetCurrentUser get user of MiddlewareRateKeyUser rules, ip is used if user id is 0
+func WithRateGetCurrentUser(fun func(c *gin.Context) ms.User) func(*RateOptions) {
+	return func(options *RateOptions) {
+		if fun != nil {
+			getRateOptionsOrSetDefault(options).getCurrentUser = fun
+		}
+	}
+}
+
 func getRateOptionsOrSetDefault(options *RateOptions) *RateOptions {
 	if options == nil {
 		return &RateOptions{
 			maxLimit: 200,
+			prefix:   constant.MiddlewareRatePrefix,
+			ruleKey:  constant.MiddlewareRateRuleKey,
+			refresh:  10,
+			getCurrentUser: func(c *gin.Context) ms.User {
+				return ms.User{}
+			},
 		}
 	}
 	return options
@@ -1,41 +1,414 @@
 package middleware
 
 import (
+	"context"
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/log"
+	"github.com/piupuer/go-helper/pkg/req"
 	"github.com/piupuer/go-helper/pkg/resp"
-	"github.com/ulule/limiter/v3"
-	mgin "github.com/ulule/limiter/v3/drivers/middleware/gin"
-	"github.com/ulule/limiter/v3/drivers/store/memory"
-	"github.com/ulule/limiter/v3/drivers/store/redis"
+	"github.com/piupuer/go-helper/pkg/tracing"
+	"github.com/piupuer/go-helper/pkg/utils"
+	"github.com/pkg/errors"
 	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// RateRule limit rule of routes, request is rejected if any matched rule is exceeded
+type RateRule struct {
+	Id        string `json:"id"`
+	Path      string `json:"path"`      // gin route pattern such as /api/v1/user/list, prefix match if it ends with *, empty matches all routes
+	Method    string `json:"method"`    // empty matches all methods
+	Key       string `json:"key"`       // limit by constant.MiddlewareRateKeyIp/MiddlewareRateKeyUser/MiddlewareRateKeyApp
+	Algorithm string `json:"algorithm"` // constant.MiddlewareRateTokenBucket/MiddlewareRateSlidingWindow
+	Limit     int64  `json:"limit"`     // max requests of period, it is also capacity of token bucket
+	Period    int64  `json:"period"`    // seconds
+}
+
+// RateLimiter rate limiter of routes, rules can be changed without restarting,
+// rules and counters are shared by all instances if WithRateRedis is set
+type RateLimiter struct {
+	ops     RateOptions
+	lock    sync.RWMutex
+	rules   map[string]RateRule
+	loadAt  time.Time
+	loading int32
+	memory  *rateMemory
+	// per-ip rule of WithRateMaxLimit, it is always kept
+	defaultRule RateRule
+}
+
+type rateResult struct {
+	allowed   bool
+	limit     int64
+	remaining int64
+	// milliseconds until the next request is allowed(rejected) or the counter is reset(allowed)
+	reset int64
+}
+
+// Rate limit requests by rules, the default rule limits max requests per second of each ip(WithRateMaxLimit)
 func Rate(options ...func(*RateOptions)) gin.HandlerFunc {
+	return NewRateLimiter(options...).Handler()
+}
+
+// NewRateLimiter create rate limiter, the same limiter can be used by middleware and v1.WithRateLimiter
+func NewRateLimiter(options ...func(*RateOptions)) *RateLimiter {
 	ops := getRateOptionsOrSetDefault(nil)
 	for _, f := range options {
 		f(ops)
 	}
-	rate := limiter.Rate{
-		Period: time.Second,
-		Limit:  ops.maxLimit,
+	l := &RateLimiter{
+		ops:    *ops,
+		rules:  make(map[string]RateRule),
+		memory: newRateMemory(),
 	}
-
-	var store limiter.Store
-	var err error
-	if ops.redis != nil {
-		store, err = redis.NewStore(ops.redis)
+	l.defaultRule = RateRule{
+		Id:        constant.MiddlewareRateDefaultRuleId,
+		Key:       constant.MiddlewareRateKeyIp,
+		Algorithm: constant.MiddlewareRateSlidingWindow,
+		Limit:     ops.maxLimit,
+		Period:    1,
+	}
+	rules := []RateRule{l.defaultRule}
+	rules = append(rules, ops.rules...)
+	for _, item := range rules {
+		if item.Id == "" {
+			panic("rate rule id is empty")
+		}
+		item, err := item.check()
 		if err != nil {
 			panic(err)
 		}
-	} else {
-		store = memory.NewStore()
+		if ops.redis == nil {
+			l.rules[item.Id] = item
+			continue
+		}
+		// the rule may be changed by api, keep it
+		err = ops.redis.HSetNX(context.Background(), ops.ruleKey, item.Id, utils.Struct2Json(item)).Err()
+		if err != nil {
+			log.WithError(err).Warn("save rate rule %s failed", item.Id)
+		}
+	}
+	if ops.redis != nil {
+		l.load()
+	}
+	return l
+}
+
+func (l *RateLimiter) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Middleware, "Rate"))
+		defer span.End()
+		path := c.FullPath()
+		if path == "" {
+			path = c.Request.URL.Path
+		}
+		var current, limited *rateResult
+		for _, rule := range l.findRule() {
+			if !rule.match(c.Request.Method, path) {
+				continue
+			}
+			rs, err := l.take(c, rule)
+			if err != nil {
+				// limiter is unavailable, do not block requests
+				log.WithContext(c).WithError(err).Warn("rate limit by rule %s failed", rule.Id)
+				continue
+			}
+			if current == nil || rs.remaining < current.remaining {
+				current = &rs
+			}
+			if !rs.allowed && (limited == nil || rs.reset > limited.reset) {
+				limited = &rs
+			}
+		}
+		if limited != nil {
+			current = limited
+		}
+		if current != nil {
+			c.Header("X-RateLimit-Limit", fmt.Sprintf("%d", current.limit))
+			c.Header("X-RateLimit-Remaining", fmt.Sprintf("%d", current.remaining))
+			c.Header("X-RateLimit-Reset", fmt.Sprintf("%d", time.Now().Add(time.Duration(current.reset)*time.Millisecond).Unix()))
+		}
+		if limited != nil {
+			c.Header("Retry-After", fmt.Sprintf("%d", (limited.reset+999)/1000))
+			rp := resp.GetFailWithCodeAndMsg(http.StatusTooManyRequests, http.StatusText(http.StatusTooManyRequests))
+			rp.RequestId, _, _ = tracing.GetId(c)
+			c.JSON(http.StatusTooManyRequests, rp)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// FindRule find rules, sorted by path and method
+func (l *RateLimiter) FindRule(r req.RateRule) []resp.RateRule {
+	rp := make([]resp.RateRule, 0)
+	for _, item := range l.findRule() {
+		if r.Path != "" && !strings.Contains(item.Path, r.Path) {
+			continue
+		}
+		if r.Method != "" && !strings.EqualFold(item.Method, r.Method) {
+			continue
+		}
+		if r.Key != "" && item.Key != r.Key {
+			continue
+		}
+		rp = append(rp, resp.RateRule{
+			Id:        item.Id,
+			Path:      item.Path,
+			Method:    item.Method,
+			Key:       item.Key,
+			Algorithm: item.Algorithm,
+			Limit:     item.Limit,
+			Period:    item.Period,
+		})
 	}
+	sort.Slice(rp, func(i, j int) bool {
+		if rp[i].Path != rp[j].Path {
+			return rp[i].Path < rp[j].Path
+		}
+		if rp[i].Method != rp[j].Method {
+			return rp[i].Method < rp[j].Method
+		}
+		return rp[i].Id < rp[j].Id
+	})
+	return rp
+}
+
+// CreateRule create rule, it takes effect immediately in current instance and after WithRateRefresh in others
+func (l *RateLimiter) CreateRule(r req.CreateRateRule) (err error) {
+	err = l.saveRule(RateRule{
+		Id:        uuid.NewString(),
+		Path:      r.Path,
+		Method:    r.Method,
+		Key:       r.Key,
+		Algorithm: r.Algorithm,
+		Limit:     r.Limit,
+		Period:    r.Period,
+	})
+	return
+}
 
-	instance := limiter.New(store, rate, limiter.WithTrustForwardHeader(true))
+func (l *RateLimiter) UpdateRuleById(id string, r req.UpdateRateRule) (err error) {
+	item, ok := l.getRule(id)
+	if !ok {
+		err = errors.Errorf("rate rule %s not found", id)
+		return
+	}
+	if r.Path != nil {
+		item.Path = *r.Path
+	}
+	if r.Method != nil {
+		item.Method = *r.Method
+	}
+	if r.Key != nil {
+		item.Key = *r.Key
+	}
+	if r.Algorithm != nil {
+		item.Algorithm = *r.Algorithm
+	}
+	if r.Limit != nil {
+		item.Limit = *r.Limit
+	}
+	if r.Period != nil {
+		item.Period = *r.Period
+	}
+	err = l.saveRule(item)
+	return
+}
 
-	return mgin.NewMiddleware(instance, mgin.WithLimitReachedHandler(func(c *gin.Context) {
-		rp := resp.GetFailWithCodeAndMsg(http.StatusTooManyRequests, http.StatusText(http.StatusTooManyRequests))
-		c.JSON(http.StatusTooManyRequests, rp)
-	}))
+// DeleteRuleByIds delete rules, the default rule can not be deleted, update its limit instead
+func (l *RateLimiter) DeleteRuleByIds(ids []string) (err error) {
+	list := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if id != constant.MiddlewareRateDefaultRuleId {
+			list = append(list, id)
+		}
+	}
+	ids = list
+	if len(ids) == 0 {
+		return
+	}
+	if l.ops.redis != nil {
+		err = l.ops.redis.HDel(context.Background(), l.ops.ruleKey, ids...).Err()
+		if err != nil {
+			err = errors.WithStack(err)
+			return
+		}
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for _, id := range ids {
+		delete(l.rules, id)
+	}
+	return
+}
+
+func (l *RateLimiter) getRule(id string) (item RateRule, ok bool) {
+	for _, rule := range l.findRule() {
+		if rule.Id == id {
+			item = rule
+			ok = true
+			return
+		}
+	}
+	return
+}
+
+func (l *RateLimiter) saveRule(item RateRule) (err error) {
+	item, err = item.check()
+	if err != nil {
+		return
+	}
+	if l.ops.redis != nil {
+		err = l.ops.redis.HSet(context.Background(), l.ops.ruleKey, item.Id, utils.Struct2Json(item)).Err()
+		if err != nil {
+			err = errors.WithStack(err)
+			return
+		}
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.rules[item.Id] = item
+	return
+}
+
+// rules of current instance, they are reloaded from redis every WithRateRefresh seconds
+func (l *RateLimiter) findRule() []RateRule {
+	l.lock.RLock()
+	expired := l.ops.redis != nil && time.Since(l.loadAt) > time.Duration(l.ops.refresh)*time.Second
+	l.lock.RUnlock()
+	if expired && atomic.CompareAndSwapInt32(&l.loading, 0, 1) {
+		l.load()
+		atomic.StoreInt32(&l.loading, 0)
+	}
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	list := make([]RateRule, 0, len(l.rules))
+	for _, item := range l.rules {
+		list = append(list, item)
+	}
+	return list
+}
+
+func (l *RateLimiter) load() {
+	m, err := l.ops.redis.HGetAll(context.Background(), l.ops.ruleKey).Result()
+	var rules map[string]RateRule
+	if err == nil {
+		rules = parseRateRules(m)
+		if _, ok := m[constant.MiddlewareRateDefaultRuleId]; !ok {
+			// rule key is flushed or expired, re-seed the default rule
+			e := l.ops.redis.HSetNX(context.Background(), l.ops.ruleKey, l.defaultRule.Id, utils.Struct2Json(l.defaultRule)).Err()
+			if e != nil {
+				log.WithError(e).Warn("save rate rule %s failed", l.defaultRule.Id)
+			}
+			rules[l.defaultRule.Id] = l.defaultRule
+		}
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	// retry after refresh interval even if failed
+	l.loadAt = time.Now()
+	if err != nil {
+		log.WithError(err).Warn("load rate rule failed")
+		return
+	}
+	l.rules = rules
+}
+
+func parseRateRules(m map[string]string) map[string]RateRule {
+	rules := make(map[string]RateRule, len(m))
+	for id, v := range m {
+		var item RateRule
+		utils.Json2Struct(v, &item)
+		item.Id = id
+		rules[id] = item
+	}
+	return rules
+}
+
+// take a request from the counter of rule
+func (l *RateLimiter) take(c *gin.Context, rule RateRule) (rs rateResult, err error) {
+	key := fmt.Sprintf("%s:%s:%s", l.ops.prefix, rule.Id, l.identity(c, rule))
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	period := rule.Period * 1000
+	if l.ops.redis == nil {
+		rs = l.memory.take(key, rule.Algorithm, rule.Limit, period, now)
+		return
+	}
+	script := rateSlidingWindowScript
+	if rule.Algorithm == constant.MiddlewareRateTokenBucket {
+		script = rateTokenBucketScript
+	}
+	arr, err := script.Run(c, l.ops.redis, []string{key}, rule.Limit, period, now, uuid.NewString()).Int64Slice()
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	if len(arr) != 3 {
+		err = errors.Errorf("invalid rate script result: %v", arr)
+		return
+	}
+	rs = rateResult{
+		allowed:   arr[0] == 1,
+		limit:     rule.Limit,
+		remaining: arr[1],
+		reset:     arr[2],
+	}
+	return
+}
+
+// user/app of request, ip is used if request has no user/app
+func (l *RateLimiter) identity(c *gin.Context, rule RateRule) string {
+	switch rule.Key {
+	case constant.MiddlewareRateKeyUser:
+		if u := l.ops.getCurrentUser(c); u.Id > 0 {
+			return fmt.Sprintf("user:%d", u.Id)
+		}
+	case constant.MiddlewareRateKeyApp:
+		if appId := c.GetString(constant.MiddlewareSignAppIdCtxKey); appId != "" {
+			return "app:" + appId
+		}
+	}
+	return "ip:" + c.ClientIP()
+}
+
+func (r RateRule) match(method, path string) bool {
+	if r.Method != "" && r.Method != method {
+		return false
+	}
+	if r.Path == "" {
+		return true
+	}
+	if strings.HasSuffix(r.Path, "*") {
+		return strings.HasPrefix(path, strings.TrimSuffix(r.Path, "*"))
+	}
+	return r.Path == path
+}
+
+func (r RateRule) check() (RateRule, error) {
+	r.Method = strings.ToUpper(strings.TrimSpace(r.Method))
+	r.Path = strings.TrimSpace(r.Path)
+	switch r.Key {
+	case constant.MiddlewareRateKeyIp, constant.MiddlewareRateKeyUser, constant.MiddlewareRateKeyApp:
+	default:
+		return r, errors.Errorf("invalid rate rule key: %s", r.Key)
+	}
+	switch r.Algorithm {
+	case constant.MiddlewareRateTokenBucket, constant.MiddlewareRateSlidingWindow:
+	default:
+		return r, errors.Errorf("invalid rate rule algorithm: %s", r.Algorithm)
+	}
+	if r.Limit <= 0 || r.Period <= 0 {
+		return r, errors.Errorf("rate rule limit and period must be greater than 0")
+	}
+	return r, nil
 }
@@ -0,0 +1,129 @@
+package middleware
+
+import (
+	"github.com/go-redis/redis/v8"
+	"github.com/piupuer/go-helper/pkg/constant"
+	"math"
+	"sync"
+)
+
+// redis lua script of token bucket, the bucket is refilled to limit in period(KEYS[1]: key, ARGV: limit, period ms, now ms)
+var rateTokenBucketScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+    tokens = limit
+    ts = now
+end
+local rate = limit / period
+tokens = math.min(limit, tokens + math.max(0, now - ts) * rate)
+local allowed = 0
+local reset
+if tokens >= 1 then
+    tokens = tokens - 1
+    allowed = 1
+    reset = math.ceil((limit - tokens) / rate)
+else
+    reset = math.ceil((1 - tokens) / rate)
+end
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], period)
+return {allowed, math.floor(tokens), reset}
+`)
+
+// redis lua script of sliding window log(KEYS[1]: key, ARGV: limit, period ms, now ms, unique member)
+var rateSlidingWindowScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], 0, now - period)
+local count = redis.call('ZCARD', KEYS[1])
+local allowed = 0
+if count < limit then
+    redis.call('ZADD', KEYS[1], now, ARGV[4])
+    count = count + 1
+    allowed = 1
+end
+redis.call('PEXPIRE', KEYS[1], period)
+local reset = period
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+if #oldest > 0 then
+    reset = tonumber(oldest[2]) + period - now
+end
+return {allowed, limit - count, reset}
+`)
+
+// counters of current instance, it is used if redis is empty
+type rateMemory struct {
+	lock     sync.Mutex
+	counters map[string]*rateCounter
+	cleanAt  int64
+}
+
+type rateCounter struct {
+	// token bucket
+	tokens float64
+	ts     int64
+	// sliding window
+	log    []int64
+	expire int64
+}
+
+func newRateMemory() *rateMemory {
+	return &rateMemory{
+		counters: make(map[string]*rateCounter),
+	}
+}
+
+func (m *rateMemory) take(key, algorithm string, limit, period, now int64) (rs rateResult) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if now-m.cleanAt > 60000 {
+		for k, v := range m.counters {
+			if v.expire < now {
+				delete(m.counters, k)
+			}
+		}
+		m.cleanAt = now
+	}
+	counter, ok := m.counters[key]
+	if !ok || counter.expire < now {
+		counter = &rateCounter{
+			tokens: float64(limit),
+			ts:     now,
+		}
+		m.counters[key] = counter
+	}
+	counter.expire = now + period
+	rs.limit = limit
+	if algorithm == constant.MiddlewareRateTokenBucket {
+		rate := float64(limit) / float64(period)
+		counter.tokens = math.Min(float64(limit), counter.tokens+float64(now-counter.ts)*rate)
+		counter.ts = now
+		if counter.tokens >= 1 {
+			counter.tokens--
+			rs.allowed = true
+			rs.reset = int64(math.Ceil((float64(limit) - counter.tokens) / rate))
+		} else {
+			rs.reset = int64(math.Ceil((1 - counter.tokens) / rate))
+		}
+		rs.remaining = int64(counter.tokens)
+		return
+	}
+	i := 0
+	for i < len(counter.log) && counter.log[i] <= now-period {
+		i++
+	}
+	counter.log = counter.log[i:]
+	if int64(len(counter.log)) < limit {
+		counter.log = append(counter.log, now)
+		rs.allowed = true
+	}
+	rs.remaining = limit - int64(len(counter.log))
+	rs.reset = counter.log[0] + period - now
+	return
+}
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"github.com/piupuer/go-helper/pkg/constant"
+	"testing"
+)
+
+func TestRateMemory_Take(t *testing.T) {
+	type take struct {
+		now       int64
+		allowed   bool
+		remaining int64
+		reset     int64
+	}
+	cases := []struct {
+		algorithm string
+		takes     []take
+	}{
+		{
+			// limit 2 per 1000ms
+			algorithm: constant.MiddlewareRateSlidingWindow,
+			takes: []take{
+				{0, true, 1, 1000},
+				{400, true, 0, 600},
+				{500, false, 0, 500},
+				// the first request is out of window
+				{1000, true, 0, 400},
+				{1200, false, 0, 200},
+			},
+		},
+		{
+			// capacity 2, refill 1 token per 500ms
+			algorithm: constant.MiddlewareRateTokenBucket,
+			takes: []take{
+				{0, true, 1, 500},
+				{0, true, 0, 1000},
+				{250, false, 0, 250},
+				{500, true, 0, 1000},
+				{2000, true, 1, 500},
+			},
+		},
+	}
+	for _, item := range cases {
+		m := newRateMemory()
+		for i, tk := range item.takes {
+			rs := m.take("key", item.algorithm, 2, 1000, tk.now)
+			if rs.allowed != tk.allowed || rs.remaining != tk.remaining || rs.reset != tk.reset || rs.limit != 2 {
+				t.Errorf("%s take %d at %d = %+v, want allowed %v remaining %d reset %d", item.algorithm, i, tk.now, rs, tk.allowed, tk.remaining, tk.reset)
+			}
+		}
+	}
+}
+
+func TestRateMemory_TakeExpired(t *testing.T) {
+	m := newRateMemory()
+	m.take("key", constant.MiddlewareRateSlidingWindow, 1, 1000, 0)
+	// counter of key is expired after period, it is removed by the next cleaning
+	m.take("other", constant.MiddlewareRateSlidingWindow, 1, 1000, 60001)
+	if _, ok := m.counters["key"]; ok {
+		t.Errorf("expired counter is not cleaned")
+	}
+	rs := m.take("key", constant.MiddlewareRateSlidingWindow, 1, 1000, 60002)
+	if !rs.allowed {
+		t.Errorf("take after expired = %+v, want allowed", rs)
+	}
+}
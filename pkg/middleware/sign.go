@@ -1,12 +1,11 @@
 package middleware
 
 import (
-	"bytes"
 	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/base64"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-module/carbon/v2"
+	"github.com/piupuer/go-helper/ms"
 	"github.com/piupuer/go-helper/pkg/constant"
 	"github.com/piupuer/go-helper/pkg/log"
 	"github.com/piupuer/go-helper/pkg/resp"
@@ -15,6 +14,7 @@ import (
 	"net/http"
 	"regexp"
 	"strings"
+	"time"
 )
 
 func Sign(options ...func(*SignOptions)) gin.HandlerFunc {
@@ -25,6 +25,10 @@ func Sign(options ...func(*SignOptions)) gin.HandlerFunc {
 	if ops.getSignUser == nil {
 		panic("getSignUser is empty")
 	}
+	expire, err := time.ParseDuration(ops.expire)
+	if err != nil || expire <= 0 {
+		panic(fmt.Sprintf("sign expire %s is invalid", ops.expire))
+	}
 	return func(c *gin.Context) {
 		ctx := tracing.RealCtx(c)
 		_, span := tracer.Start(ctx, tracing.Name(tracing.Middleware, "Sign"))
@@ -55,7 +59,7 @@ func Sign(options ...func(*SignOptions)) gin.HandlerFunc {
 		}
 		list := strings.Split(token, ",")
 		re := regexp.MustCompile(`"[\D\d].*"`)
-		var appId, timestamp, signature string
+		var appId, timestamp, signature, nonce string
 		for _, item := range list {
 			ms := re.FindAllString(item, -1)
 			if len(ms) == 1 {
@@ -65,6 +69,8 @@ func Sign(options ...func(*SignOptions)) gin.HandlerFunc {
 					timestamp = strings.Trim(ms[0], `"`)
 				} else if strings.HasPrefix(item, ops.headerKey[3]) {
 					signature = strings.Trim(ms[0], `"`)
+				} else if strings.HasPrefix(item, ops.headerKey[4]) {
+					nonce = strings.Trim(ms[0], `"`)
 				}
 			}
 		}
@@ -86,9 +92,17 @@ func Sign(options ...func(*SignOptions)) gin.HandlerFunc {
 			abort(c, "%s: %s", resp.InvalidSignTimestampMsg, timestamp)
 			return
 		}
+		if ops.redis != nil {
+			// nonce is saved until timestamp is expired, timestamp in the future is rejected too
+			if nonce == "" || len(nonce) > 64 || t.SubDuration(ops.expire).Gt(now) {
+				log.WithContext(c).Warn("%s: %s, %s", resp.InvalidSignNonceMsg, nonce, timestamp)
+				abort(c, "%s: %s", resp.InvalidSignNonceMsg, nonce)
+				return
+			}
+		}
 		// query user by app id
 		u := ops.getSignUser(c, appId)
-		if u.AppSecret == "" {
+		if u.SignKey() == "" {
 			log.WithContext(c).Warn("%s: %s", resp.IllegalSignIdMsg, appId)
 			abort(c, "%s: %s", resp.IllegalSignIdMsg, appId)
 			return
@@ -118,28 +132,41 @@ func Sign(options ...func(*SignOptions)) gin.HandlerFunc {
 		}
 
 		// verify signature
-		if !verifySign(u.AppSecret, signature, reqMethod, reqUri, timestamp, getBody(c)) {
+		if !verifySign(u, signature, reqMethod, reqUri, timestamp, nonce, getBody(c)) {
 			log.WithContext(c).Warn("%s: %s", resp.IllegalSignTokenMsg, token)
 			abort(c, "%s: %s", resp.IllegalSignTokenMsg, token)
 			return
 		}
+		// nonce is checked after signature, otherwise anyone can use up nonce of others
+		if ops.redis != nil {
+			ok, err := ops.redis.SetNX(c, fmt.Sprintf("%s_%s_%s", ops.noncePrefix, appId, nonce), timestamp, 2*expire).Result()
+			if err != nil {
+				log.WithContext(c).WithError(err).Error("save sign nonce failed")
+				abort(c, resp.InternalServerErrorMsg)
+				return
+			}
+			if !ok {
+				log.WithContext(c).Warn("%s: %s, %s", resp.RepeatedSignNonceMsg, appId, nonce)
+				abort(c, "%s: %s", resp.RepeatedSignNonceMsg, nonce)
+				return
+			}
+		}
+		c.Set(constant.MiddlewareSignAppIdCtxKey, appId)
 		c.Next()
 	}
 }
 
-func verifySign(secret, signature, method, uri, timestamp, body string) (flag bool) {
-	b := bytes.NewBuffer(nil)
-	b.WriteString(method)
-	b.WriteString(constant.MiddlewareSignSeparator)
-	b.WriteString(uri)
-	b.WriteString(constant.MiddlewareSignSeparator)
-	b.WriteString(timestamp)
-	b.WriteString(constant.MiddlewareSignSeparator)
-	b.WriteString(utils.JsonWithSort(body))
-	hash := hmac.New(sha256.New, []byte(secret))
-	hash.Write(b.Bytes())
-	digest := base64.StdEncoding.EncodeToString(hash.Sum(nil))
-	flag = digest == signature
+// verify signature by sign type of user
+func verifySign(u ms.SignUser, signature, method, uri, timestamp, nonce, body string) (flag bool) {
+	content := utils.SignContent(method, uri, timestamp, nonce, body)
+	switch u.SignType {
+	case constant.MiddlewareSignTypeEd25519:
+		flag = utils.Ed25519Verify(content, signature, u.PublicKey)
+	case constant.MiddlewareSignTypeRsa:
+		flag = utils.RSAVerify([]byte(content), []byte(signature), []byte(u.PublicKey))
+	default:
+		flag = hmac.Equal([]byte(utils.SignHmac(u.AppSecret, content)), []byte(signature))
+	}
 	return
 }
 
@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"github.com/piupuer/go-helper/ms"
+	"testing"
+)
+
+func TestVerifySign(t *testing.T) {
+	u := ms.SignUser{
+		AppId:     "app",
+		AppSecret: "secret",
+	}
+	cases := []struct {
+		secret    string
+		signature string
+		nonce     string
+		flag      bool
+	}{
+		// signature without nonce is still valid
+		{"secret", "VJqNZGH/UtMkuFWD957UAFyd4pU4tC8N8TDwOAjY/1E=", "", true},
+		{"secret", "U45KV3OVu91Ea7SxsX5AuHoKKiHfVoRijWXyl0KstXs=", "0f2c6a", true},
+		{"secret", "VJqNZGH/UtMkuFWD957UAFyd4pU4tC8N8TDwOAjY/1E=", "0f2c6a", false},
+		{"other", "VJqNZGH/UtMkuFWD957UAFyd4pU4tC8N8TDwOAjY/1E=", "", false},
+	}
+	for _, item := range cases {
+		u.AppSecret = item.secret
+		flag := verifySign(u, item.signature, "POST", "/api/v1/user?page=1", "1640995200", item.nonce, `{"b":"x","a":1}`)
+		if flag != item.flag {
+			t.Errorf("verifySign(secret=%s, nonce=%q) = %v, want %v", item.secret, item.nonce, flag, item.flag)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrReturned message published with WithPublishMandatory can not be routed to any queue
+var ErrReturned = fmt.Errorf("publish returned by broker")
+
+// PublishStats count of publisher confirms
+type PublishStats struct {
+	Confirmed uint64 `json:"confirmed"`
+	Nacked    uint64 `json:"nacked"`
+	Returned  uint64 `json:"returned"` // unroutable messages published with WithPublishMandatory
+}
+
+// Stats get publisher confirms count since rabbit created
+func (rb *Rabbit) Stats() PublishStats {
+	return PublishStats{
+		Confirmed: atomic.LoadUint64(&rb.confirmer.stats.Confirmed),
+		Nacked:    atomic.LoadUint64(&rb.confirmer.stats.Nacked),
+		Returned:  atomic.LoadUint64(&rb.confirmer.stats.Returned),
+	}
+}
+
+// confirmer publish by a confirm mode channel, every message waits for its own ack
+type confirmer struct {
+	stats   PublishStats
+	rb      *Rabbit
+	lock    sync.Mutex
+	ch      *amqp.Channel
+	seq     uint64
+	pending map[uint64]*confirmWaiter
+	// closed when the channel being opened is ready, nil if no channel is being opened
+	opening chan struct{}
+}
+
+type confirmWaiter struct {
+	messageId string
+	// buffered, listener never blocks even if publish is timeout
+	done chan error
+}
+
+// publish with confirmation
+func (pu *Publish) confirm(exchange, key string) (err error) {
+	ctx, cancel := context.WithTimeout(pu.ops.ctx, time.Duration(pu.ops.timeout)*time.Millisecond)
+	defer cancel()
+	msg := pu.msg
+	msg.Timestamp = time.Now()
+	msg.MessageId = uuid.NewString()
+	for i := 0; i <= pu.ops.maxRetryCount; i++ {
+		err = pu.ex.rb.confirmer.publish(ctx, exchange, key, pu.ops.mandatory, pu.ops.immediate, msg)
+		if err == nil || errors.Is(err, ErrReturned) || ctx.Err() != nil {
+			return
+		}
+		time.Sleep(time.Duration(pu.ops.reconnectInterval) * time.Millisecond)
+	}
+	return
+}
+
+func (c *confirmer) publish(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) (err error) {
+	w := &confirmWaiter{
+		messageId: msg.MessageId,
+		done:      make(chan error, 1),
+	}
+	c.lock.Lock()
+	for c.ch == nil {
+		// channel is opened outside of lock, it blocks until broker is available
+		opening := c.opening
+		if opening == nil {
+			opening = make(chan struct{})
+			c.opening = opening
+			go c.open(opening)
+		}
+		c.lock.Unlock()
+		select {
+		case <-ctx.Done():
+			err = errors.Errorf("publish channel wasn't opened before context expired")
+			return
+		case <-opening:
+		}
+		c.lock.Lock()
+	}
+	ch := c.ch
+	c.seq++
+	tag := c.seq
+	c.pending[tag] = w
+	err = ch.Publish(exchange, key, mandatory, immediate, msg)
+	if err != nil {
+		delete(c.pending, tag)
+		c.lock.Unlock()
+		err = errors.WithStack(err)
+		return
+	}
+	c.lock.Unlock()
+	select {
+	case <-ctx.Done():
+		// waiter is removed by listener when ack arrives or channel is closed
+		err = errors.Errorf("publish confirmation wasn't received before context expired")
+	case err = <-w.done:
+	}
+	return
+}
+
+// open a new confirm mode channel, lock must not be held, done is closed when channel is ready
+func (c *confirmer) open(done chan struct{}) {
+	ch := c.rb.pool.GetTransientChannel(true)
+	confirms := ch.NotifyPublish(make(chan amqp.Confirmation, 100))
+	returns := ch.NotifyReturn(make(chan amqp.Return, 100))
+	c.lock.Lock()
+	c.ch = ch
+	c.seq = 0
+	c.pending = make(map[uint64]*confirmWaiter)
+	c.opening = nil
+	c.lock.Unlock()
+	close(done)
+	go c.listen(ch, confirms, returns)
+}
+
+func (c *confirmer) listen(ch *amqp.Channel, confirms chan amqp.Confirmation, returns chan amqp.Return) {
+	// returned message is not confirmed yet, keep it until ack arrives
+	returned := make(map[string]amqp.Return)
+	onReturn := func(r amqp.Return) {
+		atomic.AddUint64(&c.stats.Returned, 1)
+		returned[r.MessageId] = r
+		if c.rb.ops.returnCallback != nil {
+			c.rb.ops.returnCallback(r)
+		}
+	}
+	for {
+		select {
+		case r, ok := <-returns:
+			if ok {
+				onReturn(r)
+			} else {
+				returns = nil
+			}
+		case cf, ok := <-confirms:
+			if !ok {
+				c.close(ch)
+				return
+			}
+			// return is sent before ack by broker, receive it first
+			for drained := false; !drained && returns != nil; {
+				select {
+				case r, o := <-returns:
+					if o {
+						onReturn(r)
+					} else {
+						returns = nil
+					}
+				default:
+					drained = true
+				}
+			}
+			c.lock.Lock()
+			w, ok := c.pending[cf.DeliveryTag]
+			if ok {
+				delete(c.pending, cf.DeliveryTag)
+			}
+			c.lock.Unlock()
+			if !ok {
+				continue
+			}
+			r, isReturned := returned[w.messageId]
+			delete(returned, w.messageId)
+			switch {
+			case !cf.Ack:
+				atomic.AddUint64(&c.stats.Nacked, 1)
+				w.done <- errors.Errorf("publish nacked by broker")
+			case isReturned:
+				w.done <- errors.Wrapf(ErrReturned, "code: %d, reason: %s", r.ReplyCode, r.ReplyText)
+			default:
+				atomic.AddUint64(&c.stats.Confirmed, 1)
+				w.done <- nil
+			}
+		}
+	}
+}
+
+// channel is closed, fail all pending messages
+func (c *confirmer) close(ch *amqp.Channel) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.ch != ch {
+		return
+	}
+	for tag, w := range c.pending {
+		w.done <- errors.Errorf("channel closed before publish confirmation")
+		delete(c.pending, tag)
+	}
+	c.ch = nil
+}
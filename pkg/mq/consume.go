@@ -4,7 +4,6 @@ import (
 	"context"
 	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr"
 	"github.com/piupuer/go-helper/pkg/log"
-	"github.com/piupuer/go-helper/pkg/tracing"
 	"github.com/pkg/errors"
 	"github.com/streadway/amqp"
 	"sync/atomic"
@@ -27,9 +26,10 @@ func (qu *Queue) Consume(handler func(context.Context, string, amqp.Delivery) bo
 		err = errors.WithStack(co.Error)
 		return
 	}
-	ctx := co.newContext(nil)
 	co.consumer.StartConsumingWithAction(func(msg *tcr.ReceivedMessage) {
 		d := msg.Delivery
+		ctx, span := co.newContext(nil, d)
+		defer span.End()
 		a := d.Acknowledger
 		tag := d.DeliveryTag
 		ok := handler(ctx, co.q, d)
@@ -43,10 +43,7 @@ func (qu *Queue) Consume(handler func(context.Context, string, amqp.Delivery) bo
 			}
 			return
 		}
-		e := a.Nack(tag, false, co.ops.nackRequeue)
-		if e != nil {
-			log.WithContext(ctx).WithError(e).Error("consume nack failed")
-		}
+		co.nack(ctx, qu, d)
 	})
 	return
 }
@@ -81,51 +78,89 @@ func (qu *Queue) ConsumeOne(size int, handler func(c context.Context, q string,
 		err = errors.WithStack(err)
 		return
 	}
-	ctx := co.newContext(co.ops.oneCtx)
 	for i, d := range ds {
-		a := d.Acknowledger
-		tag := d.DeliveryTag
-		ctx = context.WithValue(ctx, "index", i)
-		ok := handler(ctx, co.q, d)
-		if co.ops.autoAck {
-			return
-		}
-		if ok {
-			e := a.Ack(tag, false)
-			if e != nil {
-				log.WithContext(ctx).WithError(e).Error("consume one ack failed")
-			}
-			return
+		co.one(i, qu, d, handler)
+	}
+	return
+}
+
+func (co *Consume) one(i int, qu *Queue, d amqp.Delivery, handler func(c context.Context, q string, d amqp.Delivery) bool) {
+	ctx, span := co.newContext(co.ops.oneCtx, d)
+	defer span.End()
+	a := d.Acknowledger
+	tag := d.DeliveryTag
+	ctx = context.WithValue(ctx, "index", i)
+	ok := handler(ctx, co.q, d)
+	if co.ops.autoAck {
+		return
+	}
+	if ok {
+		e := a.Ack(tag, false)
+		if e != nil {
+			log.WithContext(ctx).WithError(e).Error("consume one ack failed")
 		}
-		// get retry count
-		var retryCount int32
-		if v, o := d.Headers["x-retry-count"].(int32); o {
-			retryCount = v + 1
-		} else {
-			retryCount = 1
+		return
+	}
+	co.nack(ctx, qu, d)
+}
+
+// nack failed message, Consume and ConsumeOne share the same retry policy:
+// without WithConsumeNackRetry, the message is nacked with WithConsumeNackRequeue
+// with WithConsumeNackRetry, the message is republished to the consuming queue with x-retry-count after exponential backoff,
+// when max retry count is exceeded, the message is rejected without requeue so that broker routes it to dead letter queue
+func (co *Consume) nack(ctx context.Context, qu *Queue, d amqp.Delivery) {
+	a := d.Acknowledger
+	tag := d.DeliveryTag
+	if !co.ops.nackRetry {
+		e := a.Nack(tag, false, co.ops.nackRequeue)
+		if e != nil {
+			log.WithContext(ctx).WithError(e).Error("consume nack failed")
 		}
-		// need to retry, requeue with set custom header
-		if co.ops.nackRetry {
-			if retryCount < co.ops.nackMaxRetryCount {
-				d.Headers["x-retry-count"] = retryCount
-				err = qu.ex.PublishByte(
-					d.Body,
-					WithPublishHeaders(d.Headers),
-					WithPublishRouteKey(d.RoutingKey),
-				)
-				if err != nil {
-					log.WithContext(ctx).WithError(err).Error("consume one republish failed")
-				}
-			} else {
-				log.WithContext(ctx).Warn("maximum retry %d exceeded, discard data", co.ops.nackMaxRetryCount)
-			}
+		return
+	}
+	// get retry count
+	var retryCount int32
+	if v, o := d.Headers["x-retry-count"].(int32); o {
+		retryCount = v + 1
+	} else {
+		retryCount = 1
+	}
+	if retryCount >= co.ops.nackMaxRetryCount {
+		log.WithContext(ctx).Warn("maximum retry %d exceeded, send to dead letter queue", co.ops.nackMaxRetryCount)
+		e := a.Nack(tag, false, false)
+		if e != nil {
+			log.WithContext(ctx).WithError(e).Error("consume nack failed")
 		}
-		e := a.Nack(tag, false, co.ops.nackRequeue)
+		return
+	}
+	// death headers of delay queue are set by broker, they should not stick to the retried message
+	headers := withoutDeathHeaders(d.Headers)
+	headers["x-retry-count"] = retryCount
+	// default exchange routes message to the consuming queue only, other queues bound to the same key will not receive it again
+	ex := &Exchange{
+		rb: qu.ex.rb,
+	}
+	err := ex.PublishByte(
+		d.Body,
+		WithPublishHeaders(headers),
+		WithPublishRouteKey(qu.ops.name),
+		WithPublishContentType(d.ContentType),
+		WithPublishDelay(co.ops.backoff(retryCount)),
+	)
+	if err != nil {
+		// keep the message in queue if republish failed
+		log.WithContext(ctx).WithError(err).Error("consume republish failed")
+		e := a.Nack(tag, false, true)
 		if e != nil {
-			log.WithContext(ctx).WithError(e).Error("consume one nack failed")
+			log.WithContext(ctx).WithError(e).Error("consume nack failed")
 		}
+		return
+	}
+	// the retry is in flight, remove the original
+	e := a.Ack(tag, false)
+	if e != nil {
+		log.WithContext(ctx).WithError(e).Error("consume ack failed")
 	}
-	return
 }
 
 func (qu *Queue) beforeConsume(options ...func(*ConsumeOptions)) *Consume {
@@ -185,13 +220,3 @@ GetBatchLoop:
 
 	return
 }
-
-func (co *Consume) newContext(ctx context.Context) context.Context {
-	if ctx == nil {
-		ctx = context.Background()
-	}
-	if co.ops.autoRequestId {
-		ctx = tracing.NewId(ctx)
-	}
-	return ctx
-}
@@ -3,14 +3,16 @@ package mq
 import (
 	"context"
 	"fmt"
+	"github.com/piupuer/go-helper/pkg/constant"
 	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel/trace"
 	"os"
 	"testing"
 	"time"
 )
 
 func TestQueue_Consume(t *testing.T) {
-	ex := NewRabbit(uri).
+	ex := getRabbit(t).
 		Exchange(
 			WithExchangeName("ex1"),
 		)
@@ -42,10 +44,7 @@ func handler(ctx context.Context, q string, delivery amqp.Delivery) bool {
 }
 
 func TestQueue_ConsumeOne(t *testing.T) {
-	rb := NewRabbit(uri)
-	if rb.Error != nil {
-		panic(rb.Error)
-	}
+	rb := getRabbit(t)
 	for {
 		time.Sleep(10 * time.Second)
 		err := rb.
@@ -64,3 +63,81 @@ func TestQueue_ConsumeOne(t *testing.T) {
 		}
 	}
 }
+
+func TestQueue_ConsumeRetry(t *testing.T) {
+	ex := getRabbit(t).
+		Exchange(
+			WithExchangeName("ex1"),
+		)
+	if ex.Error != nil {
+		panic(ex.Error)
+	}
+	qu := ex.QueueWithDeadLetter(
+		WithQueueName("q1"),
+		WithQueueDeclare(false),
+		WithQueueBind(false),
+		WithQueueDeadLetterName("dlx1"),
+	)
+	if qu.Error != nil {
+		panic(qu.Error)
+	}
+
+	// retried after 1s, 2s, 4s, then dead-lettered
+	err := qu.Consume(
+		func(ctx context.Context, q string, d amqp.Delivery) bool {
+			fmt.Println(time.Now(), q, d.Headers["x-retry-count"])
+			return false
+		},
+		WithConsumeNackRetry(true),
+		WithConsumeNackMaxRetryCount(4),
+		WithConsumeNackBackoff(1000),
+	)
+	if err != nil {
+		fmt.Println(err)
+	}
+	time.Sleep(time.Minute)
+}
+
+func TestConsumeOptions_Backoff(t *testing.T) {
+	ops := getConsumeOptionsOrSetDefault(nil)
+	WithConsumeNackMaxBackoff(5000)(ops)
+	for n, want := range map[int32]time.Duration{
+		1: time.Second,
+		2: 2 * time.Second,
+		3: 4 * time.Second,
+		4: 5 * time.Second,
+		9: 5 * time.Second,
+	} {
+		if got := ops.backoff(n); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", n, got, want)
+		}
+	}
+}
+
+func TestConsume_NewContext(t *testing.T) {
+	traceId, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanId, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceId,
+		SpanID:     spanId,
+		TraceFlags: trace.FlagsSampled,
+	}))
+	pu := &Publish{
+		ops: PublishOptions{
+			ctx: ctx,
+		},
+	}
+	pu.startSpan().End()
+
+	var co Consume
+	c, span := co.newContext(nil, amqp.Delivery{
+		Headers: pu.msg.Headers,
+	})
+	defer span.End()
+	if got := trace.SpanContextFromContext(c).TraceID(); got != traceId {
+		t.Errorf("trace id = %s, want %s", got, traceId)
+	}
+	if got := pu.msg.Headers[constant.MqRequestIdHeaderKey]; got != traceId.String() {
+		t.Errorf("request id = %v, want %s", got, traceId)
+	}
+}
@@ -0,0 +1,188 @@
+package mq
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DeadLetter dead-lettered message with its origin
+type DeadLetter struct {
+	Body        []byte     `json:"-"`
+	ContentType string     `json:"contentType"`
+	Headers     amqp.Table `json:"headers"`
+	// origin exchange/routing key/queue before dead-lettered, empty if message has no x-death header
+	Exchange   string    `json:"exchange"`
+	RoutingKey string    `json:"routingKey"`
+	Queue      string    `json:"queue"`
+	Reason     string    `json:"reason"`
+	Count      int64     `json:"count"`
+	Time       time.Time `json:"time"`
+}
+
+// PeekDeadLetter get messages of dead letter queue without removing them
+func (qu *Queue) PeekDeadLetter(options ...func(*DeadLetterOptions)) (list []DeadLetter, err error) {
+	list = make([]DeadLetter, 0)
+	err = qu.walkDeadLetter(func(d amqp.Delivery, letter DeadLetter) bool {
+		list = append(list, letter)
+		return false
+	}, options...)
+	return
+}
+
+// RepublishDeadLetter publish messages of dead letter queue back to their origin queue by default exchange,
+// other queues bound to origin exchange will not receive them again, republished messages are removed.
+// with WithDeadLetterOrigin, messages are published to their origin exchange and routing key
+func (qu *Queue) RepublishDeadLetter(options ...func(*DeadLetterOptions)) (count int, err error) {
+	ops := getDeadLetterOptionsOrSetDefault(nil)
+	for _, f := range options {
+		f(ops)
+	}
+	err = qu.walkDeadLetter(func(d amqp.Delivery, letter DeadLetter) bool {
+		if letter.Queue == "" {
+			return false
+		}
+		headers := withoutDeathHeaders(letter.Headers)
+		if _, ok := headers["x-retry-count"]; ok {
+			// retry from the beginning
+			headers["x-retry-count"] = int32(0)
+		}
+		// default exchange routes message to the queue of the same name
+		ex := &Exchange{
+			rb: qu.ex.rb,
+		}
+		key := letter.Queue
+		if ops.origin {
+			ex.ops.name = letter.Exchange
+			key = letter.RoutingKey
+		}
+		e := ex.PublishByte(
+			letter.Body,
+			WithPublishRouteKey(key),
+			WithPublishHeaders(headers),
+			WithPublishContentType(letter.ContentType),
+			// origin queue may be deleted, keep the dead letter if message can not be routed
+			WithPublishMandatory(true),
+		)
+		if e != nil {
+			err = e
+			return false
+		}
+		count++
+		return true
+	}, options...)
+	return
+}
+
+// walk through dead letter queue, the message is acked if handler returns true, otherwise it is requeued
+func (qu *Queue) walkDeadLetter(handler func(d amqp.Delivery, letter DeadLetter) bool, options ...func(*DeadLetterOptions)) (err error) {
+	if qu.Error != nil {
+		err = errors.WithStack(qu.Error)
+		return
+	}
+	ops := getDeadLetterOptionsOrSetDefault(nil)
+	for _, f := range options {
+		f(ops)
+	}
+	ch := qu.ex.rb.pool.GetChannelFromPool()
+	defer func() {
+		qu.ex.rb.pool.ReturnChannel(ch, true)
+	}()
+	// messages are not redelivered to the same channel before nack, so scanned messages are not repeated
+	ds, e := qu.getBatch(ch, qu.ops.name, ops.scan, false)
+	if e != nil {
+		err = errors.WithStack(e)
+		return
+	}
+	matched := 0
+	for _, d := range ds {
+		letter := newDeadLetter(d)
+		ok := false
+		if matched < ops.size && ops.match(letter) {
+			matched++
+			ok = handler(d, letter)
+		}
+		if ok {
+			e = d.Ack(false)
+		} else {
+			e = d.Nack(false, true)
+		}
+		if e != nil && err == nil {
+			err = errors.WithStack(e)
+		}
+	}
+	return
+}
+
+func newDeadLetter(d amqp.Delivery) (letter DeadLetter) {
+	letter = DeadLetter{
+		Body:        d.Body,
+		ContentType: d.ContentType,
+		Headers:     d.Headers,
+	}
+	deaths, _ := d.Headers["x-death"].([]interface{})
+	// x-death is sorted by the latest, the latest rejected death is the origin,
+	// expired deaths of delay queues(retry backoff or delayed publishing) are skipped
+	var origin, oldest amqp.Table
+	for _, item := range deaths {
+		death, ok := item.(amqp.Table)
+		if !ok {
+			continue
+		}
+		queue, _ := death["queue"].(string)
+		reason, _ := death["reason"].(string)
+		if reason == "expired" && isDelayQueue(queue) {
+			continue
+		}
+		if reason == "rejected" {
+			origin = death
+			break
+		}
+		oldest = death
+	}
+	if origin == nil {
+		origin = oldest
+	}
+	if origin == nil {
+		return
+	}
+	letter.Queue, _ = origin["queue"].(string)
+	letter.Exchange, _ = origin["exchange"].(string)
+	letter.Reason, _ = origin["reason"].(string)
+	letter.Count, _ = origin["count"].(int64)
+	letter.Time, _ = origin["time"].(time.Time)
+	if keys, ok := origin["routing-keys"].([]interface{}); ok && len(keys) > 0 {
+		letter.RoutingKey = fmt.Sprintf("%v", keys[0])
+	}
+	return
+}
+
+// broker owned headers of dead-lettered message, they should not be copied to a new message
+func withoutDeathHeaders(headers amqp.Table) amqp.Table {
+	m := make(amqp.Table)
+	for k, v := range headers {
+		switch k {
+		case "x-death", "x-first-death-exchange", "x-first-death-queue", "x-first-death-reason":
+			continue
+		}
+		m[k] = v
+	}
+	return m
+}
+
+// queue name is generated by delayQueueName
+func isDelayQueue(name string) bool {
+	arr := strings.SplitN(name, ".delay.", 2)
+	if len(arr) != 2 || arr[0] == "" {
+		return false
+	}
+	rest := strings.SplitN(arr[1], ".", 2)
+	if len(rest) != 2 || rest[1] == "" {
+		return false
+	}
+	_, err := strconv.ParseInt(rest[0], 10, 64)
+	return err == nil
+}
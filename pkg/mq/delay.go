@@ -0,0 +1,40 @@
+package mq
+
+import (
+	"fmt"
+	"github.com/streadway/amqp"
+	"time"
+)
+
+// declare delay queue of route key, expired messages are dead-lettered back to exchange with the same key
+// (the key is queue name if exchange is default exchange)
+// messages expire in order, delays are grouped by precision so that a long delay does not block a short one
+func (ex *Exchange) declareDelayQueue(key string, delay time.Duration, precision int) (name string, err error) {
+	name, milli := delayQueueName(ex.ops.name, key, delay, precision)
+	qu := ex.Queue(
+		WithQueueName(name),
+		WithQueueBind(false),
+		WithQueueArgs(amqp.Table{
+			"x-dead-letter-exchange":    ex.ops.name,
+			"x-dead-letter-routing-key": key,
+			// idle delay queue is removed by broker
+			"x-expires": milli + time.Minute.Milliseconds(),
+		}),
+	)
+	err = qu.Error
+	return
+}
+
+// delay is rounded up to precision, return queue name and rounded delay milli
+func delayQueueName(exchange, key string, delay time.Duration, precision int) (string, int64) {
+	milli := delay.Milliseconds()
+	if precision > 0 {
+		p := int64(precision)
+		milli = (milli + p - 1) / p * p
+	}
+	prefix := exchange
+	if prefix == "" {
+		prefix = "default"
+	}
+	return fmt.Sprintf("%s.delay.%d.%s", prefix, milli, key), milli
+}
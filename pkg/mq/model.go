@@ -0,0 +1,22 @@
+package mq
+
+import (
+	"github.com/golang-module/carbon/v2"
+	"github.com/piupuer/go-helper/ms"
+)
+
+// Outbox message published in db transaction(WithOutboxDb), it is delivered by relay after commit
+type Outbox struct {
+	ms.M
+	Exchange    string          `gorm:"comment:exchange name" json:"exchange"`
+	RouteKey    string          `gorm:"comment:route key" json:"routeKey"`
+	ContentType string          `gorm:"comment:content type" json:"contentType"`
+	Headers     string          `gorm:"type:text;comment:json headers" json:"headers"`
+	Body        []byte          `gorm:"type:longblob;comment:message body" json:"-"`
+	PublishAt   carbon.DateTime `gorm:"comment:routed time, delay is kept if relay is late" json:"publishAt"`
+	Status      uint            `gorm:"index:idx_status;type:tinyint(1);default:0;comment:0: pending, 1: sent, 2: failed" json:"status"`
+	Attempt     int             `gorm:"comment:relay attempt times" json:"attempt"`
+	Error       string          `gorm:"type:text;comment:last relay error" json:"error"`
+	RetryAt     carbon.DateTime `gorm:"comment:message is invisible to relay before retry time(claimed or backoff)" json:"retryAt"`
+	SentAt      carbon.DateTime `gorm:"comment:sent time" json:"sentAt"`
+}
@@ -2,9 +2,13 @@ package mq
 
 import (
 	"context"
+	"fmt"
+	"github.com/piupuer/go-helper/pkg/constant"
 	"github.com/piupuer/go-helper/pkg/utils"
 	"github.com/streadway/amqp"
 	"github.com/thoas/go-funk"
+	"gorm.io/gorm"
+	"time"
 )
 
 type RabbitOptions struct {
@@ -15,6 +19,14 @@ type RabbitOptions struct {
 	maxConnection       int
 	maxChannel          int
 	healthCheckInterval int
+	outboxDb            *gorm.DB
+	outboxTbPrefix      string
+	outboxInterval      int
+	outboxBatch         int
+	outboxRetention     int
+	outboxLease         int
+	outboxMaxAttempt    int
+	returnCallback      func(amqp.Return)
 }
 
 func WithCtx(ctx context.Context) func(*RabbitOptions) {
@@ -71,6 +83,75 @@ func WithHealthCheckInterval(milli int) func(*RabbitOptions) {
 	}
 }
 
+// publish with transaction ctx is saved to outbox table of db, relay delivers it after commit
+func WithOutboxDb(db *gorm.DB) func(*RabbitOptions) {
+	return func(options *RabbitOptions) {
+		if db != nil {
+			getRabbitOptionsOrSetDefault(options).outboxDb = db
+		}
+	}
+}
+
+func WithOutboxTbPrefix(prefix string) func(*RabbitOptions) {
+	return func(options *RabbitOptions) {
+		getRabbitOptionsOrSetDefault(options).outboxTbPrefix = prefix
+	}
+}
+
+// relay scan interval
+func WithOutboxInterval(milli int) func(*RabbitOptions) {
+	return func(options *RabbitOptions) {
+		if milli > 0 {
+			getRabbitOptionsOrSetDefault(options).outboxInterval = milli
+		}
+	}
+}
+
+// max count of messages relayed by one query
+func WithOutboxBatch(count int) func(*RabbitOptions) {
+	return func(options *RabbitOptions) {
+		if count > 0 {
+			getRabbitOptionsOrSetDefault(options).outboxBatch = count
+		}
+	}
+}
+
+// sent messages are kept for second, 0 means never remove
+func WithOutboxRetention(second int) func(*RabbitOptions) {
+	return func(options *RabbitOptions) {
+		if second >= 0 {
+			getRabbitOptionsOrSetDefault(options).outboxRetention = second
+		}
+	}
+}
+
+// claimed messages are invisible to other relays for second, it should be longer than publishing a batch
+func WithOutboxLease(second int) func(*RabbitOptions) {
+	return func(options *RabbitOptions) {
+		if second > 0 {
+			getRabbitOptionsOrSetDefault(options).outboxLease = second
+		}
+	}
+}
+
+// message is marked failed after max relay attempt times
+func WithOutboxMaxAttempt(count int) func(*RabbitOptions) {
+	return func(options *RabbitOptions) {
+		if count > 0 {
+			getRabbitOptionsOrSetDefault(options).outboxMaxAttempt = count
+		}
+	}
+}
+
+// callback of unroutable message published with WithPublishMandatory, it runs in confirm listener and should not block
+func WithReturnCallback(fun func(amqp.Return)) func(*RabbitOptions) {
+	return func(options *RabbitOptions) {
+		if fun != nil {
+			getRabbitOptionsOrSetDefault(options).returnCallback = fun
+		}
+	}
+}
+
 func getRabbitOptionsOrSetDefault(options *RabbitOptions) *RabbitOptions {
 	if options == nil {
 		return &RabbitOptions{
@@ -80,6 +161,12 @@ func getRabbitOptionsOrSetDefault(options *RabbitOptions) *RabbitOptions {
 			maxConnection:       10,
 			maxChannel:          50,
 			healthCheckInterval: 100,
+			outboxTbPrefix:      constant.MqOutboxTbPrefix,
+			outboxInterval:      1000,
+			outboxBatch:         100,
+			outboxRetention:     7 * 86400,
+			outboxLease:         60,
+			outboxMaxAttempt:    10,
 		}
 	}
 	return options
@@ -283,6 +370,8 @@ type PublishOptions struct {
 	expiration           string
 	deadLetter           bool
 	deadLetterFirstQueue string
+	delay                time.Duration
+	delayPrecision       int
 }
 
 func WithPublishCtx(ctx context.Context) func(*PublishOptions) {
@@ -350,6 +439,13 @@ func WithPublishHeaders(headers amqp.Table) func(*PublishOptions) {
 	}
 }
 
+// message is returned by broker if no queue is bound, publish gets ErrReturned
+func WithPublishMandatory(flag bool) func(*PublishOptions) {
+	return func(options *PublishOptions) {
+		getPublishOptionsOrSetDefault(options).mandatory = flag
+	}
+}
+
 func WithPublishDeadLetter(flag bool) func(*PublishOptions) {
 	return func(options *PublishOptions) {
 		getPublishOptionsOrSetDefault(options).deadLetter = flag
@@ -362,6 +458,29 @@ func WithPublishDeadLetterFirstQueue(q string) func(*PublishOptions) {
 	}
 }
 
+// message is routed after d by per-message TTL and dead letter of delay queue, no broker plugin required
+func WithPublishDelay(d time.Duration) func(*PublishOptions) {
+	return func(options *PublishOptions) {
+		getPublishOptionsOrSetDefault(options).delay = d
+	}
+}
+
+// message is routed at t, publish immediately if t is passed
+func WithPublishAt(t time.Time) func(*PublishOptions) {
+	return func(options *PublishOptions) {
+		getPublishOptionsOrSetDefault(options).delay = time.Until(t)
+	}
+}
+
+// delays are grouped into delay queues by milli precision, messages of the same queue may be late at most precision
+func WithPublishDelayPrecision(milli int) func(*PublishOptions) {
+	return func(options *PublishOptions) {
+		if milli > 0 {
+			getPublishOptionsOrSetDefault(options).delayPrecision = milli
+		}
+	}
+}
+
 func getPublishOptionsOrSetDefault(options *PublishOptions) *PublishOptions {
 	if options == nil {
 		return &PublishOptions{
@@ -372,6 +491,7 @@ func getPublishOptionsOrSetDefault(options *PublishOptions) *PublishOptions {
 			timeout:           10000,
 			reconnectInterval: 1000,
 			idleInterval:      1000,
+			delayPrecision:    1000,
 		}
 	}
 	return options
@@ -387,6 +507,8 @@ type ConsumeOptions struct {
 	nackRequeue       bool
 	nackRetry         bool
 	nackMaxRetryCount int32
+	nackBackoff       int
+	nackMaxBackoff    int
 	autoRequestId     bool
 	oneCtx            context.Context
 }
@@ -447,6 +569,24 @@ func WithConsumeNackMaxRetryCount(i int32) func(*ConsumeOptions) {
 	}
 }
 
+// base delay(milli) of retry, the nth retry is delayed base * 2^(n-1)
+func WithConsumeNackBackoff(milli int) func(*ConsumeOptions) {
+	return func(options *ConsumeOptions) {
+		if milli >= 0 {
+			getConsumeOptionsOrSetDefault(options).nackBackoff = milli
+		}
+	}
+}
+
+// max delay(milli) of retry
+func WithConsumeNackMaxBackoff(milli int) func(*ConsumeOptions) {
+	return func(options *ConsumeOptions) {
+		if milli > 0 {
+			getConsumeOptionsOrSetDefault(options).nackMaxBackoff = milli
+		}
+	}
+}
+
 func WithConsumeAutoRequestId(flag bool) func(*ConsumeOptions) {
 	return func(options *ConsumeOptions) {
 		getConsumeOptionsOrSetDefault(options).autoRequestId = flag
@@ -464,7 +604,86 @@ func getConsumeOptionsOrSetDefault(options *ConsumeOptions) *ConsumeOptions {
 		return &ConsumeOptions{
 			qosPrefetchCount:  2,
 			nackMaxRetryCount: 5,
+			nackBackoff:       1000,
+			nackMaxBackoff:    60000,
+		}
+	}
+	return options
+}
+
+// delay of the nth retry
+func (ops ConsumeOptions) backoff(n int32) time.Duration {
+	delay := time.Duration(ops.nackBackoff) * time.Millisecond
+	max := time.Duration(ops.nackMaxBackoff) * time.Millisecond
+	for i := int32(1); i < n && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+type DeadLetterOptions struct {
+	size    int
+	scan    int
+	headers map[string]string
+	origin  bool
+}
+
+// max count of matched messages
+func WithDeadLetterSize(size int) func(*DeadLetterOptions) {
+	return func(options *DeadLetterOptions) {
+		if size > 0 {
+			getDeadLetterOptionsOrSetDefault(options).size = size
+		}
+	}
+}
+
+// max count of messages to scan from queue head
+func WithDeadLetterScan(count int) func(*DeadLetterOptions) {
+	return func(options *DeadLetterOptions) {
+		if count > 0 {
+			getDeadLetterOptionsOrSetDefault(options).scan = count
+		}
+	}
+}
+
+// only messages with header key=value match
+func WithDeadLetterHeader(key, value string) func(*DeadLetterOptions) {
+	return func(options *DeadLetterOptions) {
+		ops := getDeadLetterOptionsOrSetDefault(options)
+		if ops.headers == nil {
+			ops.headers = make(map[string]string)
+		}
+		ops.headers[key] = value
+	}
+}
+
+// republish to origin exchange and routing key instead of origin queue,
+// all queues bound to origin exchange with the routing key will receive messages again
+func WithDeadLetterOrigin(flag bool) func(*DeadLetterOptions) {
+	return func(options *DeadLetterOptions) {
+		getDeadLetterOptionsOrSetDefault(options).origin = flag
+	}
+}
+
+func getDeadLetterOptionsOrSetDefault(options *DeadLetterOptions) *DeadLetterOptions {
+	if options == nil {
+		return &DeadLetterOptions{
+			size: 10,
+			scan: 100,
 		}
 	}
 	return options
 }
+
+func (ops DeadLetterOptions) match(letter DeadLetter) bool {
+	for k, v := range ops.headers {
+		item, ok := letter.Headers[k]
+		if !ok || fmt.Sprintf("%v", item) != v {
+			return false
+		}
+	}
+	return true
+}
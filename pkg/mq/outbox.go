@@ -0,0 +1,204 @@
+package mq
+
+import (
+	"github.com/golang-module/carbon/v2"
+	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/log"
+	"github.com/piupuer/go-helper/pkg/utils"
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// MigrateOutbox create outbox table if WithOutboxDb
+func (rb *Rabbit) MigrateOutbox() (err error) {
+	if rb.Error != nil {
+		err = rb.Error
+		return
+	}
+	if rb.ops.outboxDb == nil {
+		return
+	}
+	err = errors.WithStack(rb.outboxTable(rb.ops.outboxDb).AutoMigrate(new(Outbox)))
+	return
+}
+
+// transaction of publish ctx(middleware.Transaction or interceptor.Transaction), nil if outbox is disabled
+func (pu *Publish) tx() *gorm.DB {
+	if pu.ex.rb.ops.outboxDb == nil {
+		return nil
+	}
+	if tx, ok := pu.ops.ctx.Value(constant.MiddlewareTransactionTxCtxKey).(*gorm.DB); ok {
+		return tx
+	}
+	return nil
+}
+
+// save message to outbox in the same transaction, it is invisible to relay until commit
+func (pu *Publish) outbox(tx *gorm.DB) (err error) {
+	publishAt := carbon.DateTime{
+		Carbon: carbon.Time2Carbon(time.Now().Add(pu.ops.delay)),
+	}
+	list := make([]Outbox, 0)
+	for _, key := range pu.ops.routeKeys {
+		list = append(list, Outbox{
+			Exchange:    pu.ex.ops.name,
+			RouteKey:    key,
+			ContentType: pu.msg.ContentType,
+			Headers:     utils.Struct2Json(pu.msg.Headers),
+			Body:        pu.msg.Body,
+			PublishAt:   publishAt,
+			Status:      constant.MqOutboxPending,
+		})
+	}
+	err = errors.WithStack(pu.ex.rb.outboxTable(tx).Create(&list).Error)
+	return
+}
+
+// deliver committed outbox messages, a message may be delivered more than once(at-least-once)
+func (rb *Rabbit) relay() {
+	var cleared time.Time
+	for {
+		time.Sleep(time.Duration(rb.ops.outboxInterval) * time.Millisecond)
+		if atomic.LoadInt32(&rb.lost) == 1 {
+			continue
+		}
+		for rb.relayOutbox() == rb.ops.outboxBatch {
+			// more messages are pending
+		}
+		if rb.ops.outboxRetention > 0 && time.Since(cleared) > time.Hour {
+			rb.clearOutbox()
+			cleared = time.Now()
+		}
+	}
+}
+
+// relay a batch of pending messages, return count of sent messages
+func (rb *Rabbit) relayOutbox() (count int) {
+	list, err := rb.claimOutbox()
+	if err != nil {
+		log.WithContext(rb.ops.ctx).WithError(err).Warn("claim outbox failed")
+		return
+	}
+	for _, item := range list {
+		headers := outboxHeaders(item.Headers)
+		ex := &Exchange{
+			ops: ExchangeOptions{
+				name: item.Exchange,
+			},
+			rb: rb,
+		}
+		e := ex.PublishByte(
+			item.Body,
+			WithPublishCtx(rb.ops.ctx),
+			WithPublishRouteKey(item.RouteKey),
+			WithPublishHeaders(headers),
+			WithPublishContentType(item.ContentType),
+			WithPublishAt(item.PublishAt.Carbon2Time()),
+		)
+		attempt := item.Attempt + 1
+		m := map[string]interface{}{
+			"attempt": attempt,
+		}
+		if e != nil {
+			log.WithContext(rb.ops.ctx).WithError(e).Warn("relay outbox %d failed, attempt: %d", item.Id, attempt)
+			m["error"] = e.Error()
+			if attempt >= rb.ops.outboxMaxAttempt {
+				m["status"] = constant.MqOutboxFailed
+			} else {
+				// exponential backoff, bad message will not block the batch
+				m["retry_at"] = carbon.DateTime{
+					Carbon: carbon.Time2Carbon(time.Now().Add(outboxBackoff(attempt))),
+				}
+			}
+		} else {
+			count++
+			m["status"] = constant.MqOutboxSent
+			m["error"] = ""
+			m["sent_at"] = carbon.DateTime{
+				Carbon: carbon.Now(),
+			}
+		}
+		err = rb.outboxTable(rb.ops.outboxDb).
+			Where("id = ?", item.Id).
+			Updates(m).Error
+		if err != nil {
+			log.WithContext(rb.ops.ctx).WithError(err).Warn("update outbox %d failed", item.Id)
+		}
+	}
+	return
+}
+
+// lock a batch of pending messages(rows locked by other relays are skipped) and lease them to current relay,
+// the lease expires if relay crashes, then the messages are claimed again
+func (rb *Rabbit) claimOutbox() (list []Outbox, err error) {
+	list = make([]Outbox, 0)
+	err = rb.ops.outboxDb.WithContext(rb.ops.ctx).Transaction(func(tx *gorm.DB) error {
+		now := carbon.Now().ToDateTimeString()
+		err := rb.outboxTable(tx).
+			Clauses(clause.Locking{
+				Strength: "UPDATE",
+				Options:  "SKIP LOCKED",
+			}).
+			Where("status = ?", constant.MqOutboxPending).
+			Where("retry_at IS NULL OR retry_at <= ?", now).
+			Order("id").
+			Limit(rb.ops.outboxBatch).
+			Find(&list).Error
+		if err != nil || len(list) == 0 {
+			return err
+		}
+		ids := make([]uint, 0)
+		for _, item := range list {
+			ids = append(ids, item.Id)
+		}
+		return rb.outboxTable(tx).
+			Where("id IN (?)", ids).
+			Update("retry_at", carbon.DateTime{
+				Carbon: carbon.Now().AddSeconds(rb.ops.outboxLease),
+			}).Error
+	})
+	err = errors.WithStack(err)
+	return
+}
+
+// 2^attempt seconds, max 1 hour
+func outboxBackoff(attempt int) time.Duration {
+	if attempt >= 12 {
+		return time.Hour
+	}
+	return time.Duration(1<<uint(attempt)) * time.Second
+}
+
+// remove sent messages older than retention
+func (rb *Rabbit) clearOutbox() {
+	err := rb.outboxTable(rb.ops.outboxDb).
+		Unscoped().
+		Where("status = ?", constant.MqOutboxSent).
+		Where("sent_at < ?", carbon.Now().SubSeconds(rb.ops.outboxRetention).ToDateTimeString()).
+		Delete(&Outbox{}).Error
+	if err != nil {
+		log.WithContext(rb.ops.ctx).WithError(err).Warn("clear outbox failed")
+	}
+}
+
+// json numbers are decoded as float64, integers are restored to int32 like x-retry-count
+func outboxHeaders(s string) amqp.Table {
+	headers := make(amqp.Table)
+	utils.Json2Struct(s, &headers)
+	for k, v := range headers {
+		if f, ok := v.(float64); ok && f == math.Trunc(f) && f >= math.MinInt32 && f <= math.MaxInt32 {
+			headers[k] = int32(f)
+		}
+	}
+	return headers
+}
+
+// outbox table of db or transaction, naming strategy of db is not changed
+func (rb *Rabbit) outboxTable(db *gorm.DB) *gorm.DB {
+	return db.WithContext(rb.ops.ctx).Table(rb.ops.outboxTbPrefix + "outbox")
+}
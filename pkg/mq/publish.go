@@ -1,21 +1,19 @@
 package mq
 
 import (
-	"github.com/google/uuid"
-	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr"
 	"github.com/pkg/errors"
 	"github.com/streadway/amqp"
 	"google.golang.org/protobuf/proto"
+	"strconv"
 	"sync/atomic"
 	"time"
 )
 
 type Publish struct {
-	ops       PublishOptions
-	ex        *Exchange
-	msg       amqp.Publishing
-	publisher *tcr.Publisher
-	Error     error
+	ops   PublishOptions
+	ex    *Exchange
+	msg   amqp.Publishing
+	Error error
 }
 
 // PublishProto publish grpc proto msg
@@ -48,6 +46,13 @@ func (ex *Exchange) PublishByte(m []byte, options ...func(*PublishOptions)) (err
 		return
 	}
 	pu.msg.Body = m
+	span := pu.startSpan()
+	defer span.End()
+	if tx := pu.tx(); tx != nil {
+		// delivered by relay after transaction commit
+		err = pu.outbox(tx)
+		return
+	}
 	err = pu.publish()
 	if err != nil {
 		err = errors.WithStack(err)
@@ -83,6 +88,11 @@ func (ex *Exchange) beforePublish(options ...func(*PublishOptions)) *Publish {
 	if ops.deliveryMode <= 0 || ops.deliveryMode > amqp.Persistent {
 		ops.deliveryMode = amqp.Persistent
 	}
+	if ops.delay > 0 {
+		ops.expiration = strconv.FormatInt(ops.delay.Milliseconds(), 10)
+	} else {
+		ops.delay = 0
+	}
 	pu.ops = *ops
 	msg := amqp.Publishing{
 		DeliveryMode: ops.deliveryMode,
@@ -93,18 +103,6 @@ func (ex *Exchange) beforePublish(options ...func(*PublishOptions)) *Publish {
 	}
 	pu.msg = msg
 	pu.ex = ex
-	pu.publisher = tcr.NewPublisherFromConfig(
-		&tcr.RabbitSeasoning{
-			PoolConfig: ex.rb.poolConfig,
-			PublisherConfig: &tcr.PublisherConfig{
-				AutoAck:                false,
-				SleepOnIdleInterval:    uint32(ops.idleInterval),
-				SleepOnErrorInterval:   uint32(ops.reconnectInterval),
-				PublishTimeOutInterval: uint32(ops.timeout),
-			},
-		},
-		pu.ex.rb.pool,
-	)
 	return &pu
 }
 
@@ -114,25 +112,18 @@ func (pu *Publish) publish() (err error) {
 		return
 	}
 	for _, key := range pu.ops.routeKeys {
-		envelope := &tcr.Envelope{
-			DeliveryMode: pu.msg.DeliveryMode,
-			Exchange:     pu.ex.ops.name,
-			RoutingKey:   key,
-			ContentType:  pu.msg.ContentType,
-			Headers:      pu.msg.Headers,
-			Mandatory:    pu.ops.mandatory,
-			Immediate:    pu.ops.immediate,
-		}
-		letter := &tcr.Letter{
-			LetterID:   uuid.New(),
-			RetryCount: uint32(pu.ops.maxRetryCount),
-			Body:       pu.msg.Body,
-			Envelope:   envelope,
+		exchange := pu.ex.ops.name
+		routingKey := key
+		if pu.ops.delay > 0 {
+			// publish to delay queue by default exchange
+			exchange = ""
+			routingKey, err = pu.ex.declareDelayQueue(key, pu.ops.delay, pu.ops.delayPrecision)
+			if err != nil {
+				err = errors.Wrapf(err, "declare delay queue failed")
+				return
+			}
 		}
-		err = pu.publisher.PublishWithConfirmationContextError(
-			pu.ops.ctx,
-			letter,
-		)
+		err = pu.confirm(exchange, routingKey)
 		if err != nil {
 			err = errors.Wrapf(err, "publish failed")
 			return
@@ -4,19 +4,15 @@ import (
 	"context"
 	"fmt"
 	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
 	"google.golang.org/protobuf/types/known/emptypb"
 	"testing"
 	"time"
 )
 
 func TestExchange_PublishProto(t *testing.T) {
-	rb := NewRabbit(
-		uri,
-		WithReconnectMaxRetryCount(3),
-	)
-	if rb.Error != nil {
-		panic(rb.Error)
-	}
+	rb := getRabbit(t)
 	ex := rb.Exchange(
 		WithExchangeName("ex1"),
 		WithExchangeDeclare(false),
@@ -64,13 +60,7 @@ func TestExchange_PublishProto(t *testing.T) {
 }
 
 func TestExchange_PublishProto2(t *testing.T) {
-	rb := NewRabbit(
-		uri,
-		WithReconnectMaxRetryCount(3),
-	)
-	if rb.Error != nil {
-		panic(rb.Error)
-	}
+	rb := getRabbit(t)
 	ex := rb.Exchange(
 		WithExchangeName("ex1"),
 		WithExchangeDeclare(false),
@@ -89,3 +79,62 @@ func TestExchange_PublishProto2(t *testing.T) {
 		fmt.Println(time.Now(), "send end", err)
 	}
 }
+
+func TestExchange_PublishDelay(t *testing.T) {
+	rb := getRabbit(t)
+	ex := rb.Exchange(
+		WithExchangeName("ex1"),
+		WithExchangeDeclare(false),
+	)
+	if ex.Error != nil {
+		panic(ex.Error)
+	}
+
+	err := ex.PublishJson(
+		`{"delay": "5s"}`,
+		WithPublishRouteKey("rt1"),
+		WithPublishDelay(5*time.Second),
+	)
+	fmt.Println(time.Now(), "send delay end", err)
+	err = ex.PublishJson(
+		`{"at": "10s"}`,
+		WithPublishRouteKey("rt1"),
+		WithPublishAt(time.Now().Add(10*time.Second)),
+	)
+	fmt.Println(time.Now(), "send at end", err)
+}
+
+func TestDelayQueueName(t *testing.T) {
+	name, milli := delayQueueName("ex1", "rt1", 1500*time.Millisecond, 1000)
+	if name != "ex1.delay.2000.rt1" || milli != 2000 {
+		t.Errorf("name = %s, milli = %d, want ex1.delay.2000.rt1, 2000", name, milli)
+	}
+	// default exchange routes by queue name
+	name, milli = delayQueueName("", "q1", 1500*time.Millisecond, 0)
+	if name != "default.delay.1500.q1" || milli != 1500 {
+		t.Errorf("name = %s, milli = %d, want default.delay.1500.q1, 1500", name, milli)
+	}
+}
+
+func TestExchange_PublishMandatory(t *testing.T) {
+	rb := getRabbit(
+		t,
+		WithReturnCallback(func(r amqp.Return) {
+			fmt.Println(time.Now(), "returned", r.RoutingKey, r.ReplyText)
+		}),
+	)
+	ex := rb.Exchange(
+		WithExchangeName("ex1"),
+		WithExchangeDeclare(false),
+	)
+	if ex.Error != nil {
+		panic(ex.Error)
+	}
+
+	err := ex.PublishJson(
+		`{"unroutable": true}`,
+		WithPublishRouteKey("rt-not-bound"),
+		WithPublishMandatory(true),
+	)
+	fmt.Println(time.Now(), "send mandatory end", errors.Is(err, ErrReturned), rb.Stats())
+}
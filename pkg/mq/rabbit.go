@@ -16,6 +16,7 @@ type Rabbit struct {
 	poolConfig *tcr.PoolConfig
 	healthHost *tcr.ConnectionHost
 	lost       int32
+	confirmer  *confirmer
 	Error      error
 }
 
@@ -33,6 +34,9 @@ type Queue struct {
 
 func NewRabbit(dsn string, options ...func(*RabbitOptions)) (rb *Rabbit) {
 	rb = &Rabbit{}
+	rb.confirmer = &confirmer{
+		rb: rb,
+	}
 	ops := getRabbitOptionsOrSetDefault(nil)
 	for _, f := range options {
 		f(ops)
@@ -79,6 +83,9 @@ func NewRabbit(dsn string, options ...func(*RabbitOptions)) (rb *Rabbit) {
 	}
 	rb.pool = pool
 	go rb.healthCheck()
+	if ops.outboxDb != nil {
+		go rb.relay()
+	}
 	return
 }
 
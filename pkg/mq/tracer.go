@@ -0,0 +1,94 @@
+package mq
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/tracing"
+	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer(tracing.Mq)
+
+// w3c traceparent/tracestate and baggage are carried by amqp headers
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// headerCarrier adapts amqp headers to propagation.TextMapCarrier
+type headerCarrier amqp.Table
+
+func (h headerCarrier) Get(key string) string {
+	if v, ok := h[key].(string); ok {
+		return v
+	}
+	return ""
+}
+
+func (h headerCarrier) Set(key string, value string) {
+	h[key] = value
+}
+
+func (h headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// span context of ctx, span of gin context is kept in request context
+func spanCtx(ctx context.Context) context.Context {
+	if c, ok := ctx.(*gin.Context); ok && c.Request != nil {
+		return c.Request.Context()
+	}
+	return tracing.RealCtx(ctx)
+}
+
+// start producer span and inject trace/request id into headers,
+// trace of headers is continued if ctx has no span(republish/outbox relay)
+func (pu *Publish) startSpan() trace.Span {
+	headers := make(amqp.Table, len(pu.msg.Headers)+3)
+	for k, v := range pu.msg.Headers {
+		headers[k] = v
+	}
+	ctx := spanCtx(pu.ops.ctx)
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		ctx = propagator.Extract(ctx, headerCarrier(headers))
+	}
+	ctx, span := tracer.Start(
+		ctx,
+		tracing.Name(tracing.Mq, "Publish"),
+		trace.WithSpanKind(trace.SpanKindProducer),
+	)
+	propagator.Inject(ctx, headerCarrier(headers))
+	if _, ok := headers[constant.MqRequestIdHeaderKey]; !ok {
+		requestId, _, _ := tracing.GetId(ctx)
+		if requestId != "" {
+			headers[constant.MqRequestIdHeaderKey] = requestId
+		}
+	}
+	pu.msg.Headers = headers
+	return span
+}
+
+// extract trace/request id from headers and start consumer span
+func (co *Consume) newContext(ctx context.Context, d amqp.Delivery) (context.Context, trace.Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx = propagator.Extract(ctx, headerCarrier(d.Headers))
+	if requestId, ok := d.Headers[constant.MqRequestIdHeaderKey].(string); ok && requestId != "" {
+		ctx = context.WithValue(ctx, constant.MiddlewareRequestIdCtxKey, requestId)
+	}
+	ctx, span := tracer.Start(
+		ctx,
+		tracing.Name(tracing.Mq, "Consume"),
+		trace.WithSpanKind(trace.SpanKindConsumer),
+	)
+	if co.ops.autoRequestId {
+		ctx = tracing.NewId(ctx)
+	}
+	return ctx, span
+}
@@ -45,9 +45,9 @@ func (my MySql) FindApiGroupByCategoryByRoleKeyword(currentRoleKeyword, roleKeyw
 	// find all api
 	my.Tx.Find(&allApi)
 	// find all casbin by current user's role id
-	currentCasbins, err := FindCasbinByRoleKeyword(my.ops.enforcer, currentRoleKeyword)
+	currentCasbins, err := FindCasbinByRoleKeywordAndDomain(my.ops.enforcer, currentRoleKeyword, "")
 	// find all casbin by current role id
-	casbins, err := FindCasbinByRoleKeyword(my.ops.enforcer, roleKeyword)
+	casbins, err := FindCasbinByRoleKeywordAndDomain(my.ops.enforcer, roleKeyword, "")
 	if err != nil {
 		return tree, accessIds, errors.WithStack(err)
 	}
@@ -113,7 +113,7 @@ func (my MySql) CreateApi(r *req.CreateApi) (err error) {
 	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "CreateApi"))
 	defer span.End()
 	api := new(ms.SysApi)
-	err = my.Create(r, new(ms.SysApi))
+	err = my.Create(r, api)
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -123,6 +123,7 @@ func (my MySql) CreateApi(r *req.CreateApi) (err error) {
 		for _, keyword := range r.RoleKeywords {
 			cs = append(cs, ms.SysRoleCasbin{
 				Keyword: keyword,
+				Domain:  r.Domain,
 				Path:    api.Path,
 				Method:  api.Method,
 			})
@@ -160,17 +161,14 @@ func (my MySql) UpdateApiById(id uint, r req.UpdateApi) (err error) {
 			Method: oldApi.Method,
 		})
 		if len(oldCasbins) > 0 {
-			keywords := make([]string, 0)
-			for _, oldCasbin := range oldCasbins {
-				keywords = append(keywords, oldCasbin.Keyword)
-			}
 			// delete old rules
 			my.BatchDeleteRoleCasbin(oldCasbins)
-			// create new rules
+			// create new rules, role and domain are kept
 			newCasbins := make([]ms.SysRoleCasbin, 0)
-			for _, keyword := range keywords {
+			for _, oldCasbin := range oldCasbins {
 				newCasbins = append(newCasbins, ms.SysRoleCasbin{
-					Keyword: keyword,
+					Keyword: oldCasbin.Keyword,
+					Domain:  oldCasbin.Domain,
 					Path:    api.Path,
 					Method:  api.Method,
 				})
@@ -182,7 +180,12 @@ func (my MySql) UpdateApiById(id uint, r req.UpdateApi) (err error) {
 }
 
 func (my MySql) UpdateApiByRoleKeyword(keyword string, r req.UpdateMenuIncrementalIds) (err error) {
-	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "UpdateApiByRoleKeyword"))
+	return my.UpdateApiByRoleKeywordAndDomain(keyword, "", r)
+}
+
+// domain is tenant of role casbin rules, only used by model with domain
+func (my MySql) UpdateApiByRoleKeywordAndDomain(keyword, domain string, r req.UpdateMenuIncrementalIds) (err error) {
+	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "UpdateApiByRoleKeywordAndDomain"))
 	defer span.End()
 	if len(r.Delete) > 0 {
 		deleteApis := make([]ms.SysApi, 0)
@@ -193,6 +196,7 @@ func (my MySql) UpdateApiByRoleKeyword(keyword string, r req.UpdateMenuIncrement
 		for _, api := range deleteApis {
 			cs = append(cs, ms.SysRoleCasbin{
 				Keyword: keyword,
+				Domain:  domain,
 				Path:    api.Path,
 				Method:  api.Method,
 			})
@@ -208,6 +212,7 @@ func (my MySql) UpdateApiByRoleKeyword(keyword string, r req.UpdateMenuIncrement
 		for _, api := range createApis {
 			cs = append(cs, ms.SysRoleCasbin{
 				Keyword: keyword,
+				Domain:  domain,
 				Path:    api.Path,
 				Method:  api.Method,
 			})
@@ -4,6 +4,7 @@ import (
 	"github.com/casbin/casbin/v2"
 	"github.com/piupuer/go-helper/ms"
 	"github.com/piupuer/go-helper/pkg/log"
+	"github.com/piupuer/go-helper/pkg/middleware"
 	"github.com/piupuer/go-helper/pkg/tracing"
 	"github.com/piupuer/go-helper/pkg/utils"
 	"github.com/pkg/errors"
@@ -17,13 +18,10 @@ func (my MySql) FindRoleCasbin(c ms.SysRoleCasbin) []ms.SysRoleCasbin {
 		log.WithContext(my.Ctx).Warn("casbin enforcer is empty")
 		return cs
 	}
-	policies := my.ops.enforcer.GetFilteredPolicy(0, c.Keyword, c.Path, c.Method)
+	domain := my.ops.enforcer.HasDomain()
+	policies := my.ops.enforcer.GetFilteredPolicy(0, roleCasbinRule(domain, c)...)
 	for _, policy := range policies {
-		cs = append(cs, ms.SysRoleCasbin{
-			Keyword: policy[0],
-			Path:    policy[1],
-			Method:  policy[2],
-		})
+		cs = append(cs, roleCasbin(domain, policy))
 	}
 	return cs
 }
@@ -34,7 +32,7 @@ func (my MySql) CreateRoleCasbin(c ms.SysRoleCasbin) (bool, error) {
 	if my.ops.enforcer == nil {
 		return false, errors.Errorf("casbin enforcer is empty")
 	}
-	return my.ops.enforcer.AddPolicy(c.Keyword, c.Path, c.Method)
+	return my.ops.enforcer.AddPolicy(roleCasbinRule(my.ops.enforcer.HasDomain(), c))
 }
 
 func (my MySql) BatchCreateRoleCasbin(cs []ms.SysRoleCasbin) (bool, error) {
@@ -44,12 +42,9 @@ func (my MySql) BatchCreateRoleCasbin(cs []ms.SysRoleCasbin) (bool, error) {
 	if my.ops.enforcer == nil {
 		return false, errors.Errorf("casbin enforcer is empty")
 	}
+	domain := my.ops.enforcer.HasDomain()
 	for _, c := range cs {
-		rules = append(rules, []string{
-			c.Keyword,
-			c.Path,
-			c.Method,
-		})
+		rules = append(rules, roleCasbinRule(domain, c))
 	}
 	return my.ops.enforcer.AddPolicies(rules)
 }
@@ -60,7 +55,7 @@ func (my MySql) DeleteRoleCasbin(c ms.SysRoleCasbin) (bool, error) {
 	if my.ops.enforcer == nil {
 		return false, errors.Errorf("casbin enforcer is empty")
 	}
-	return my.ops.enforcer.RemovePolicy(c.Keyword, c.Path, c.Method)
+	return my.ops.enforcer.RemovePolicy(roleCasbinRule(my.ops.enforcer.HasDomain(), c))
 }
 
 func (my MySql) BatchDeleteRoleCasbin(cs []ms.SysRoleCasbin) (bool, error) {
@@ -69,40 +64,127 @@ func (my MySql) BatchDeleteRoleCasbin(cs []ms.SysRoleCasbin) (bool, error) {
 	if my.ops.enforcer == nil {
 		return false, errors.Errorf("casbin enforcer is empty")
 	}
+	domain := my.ops.enforcer.HasDomain()
 	rules := make([][]string, 0)
 	for _, c := range cs {
-		rules = append(rules, []string{
-			c.Keyword,
-			c.Path,
-			c.Method,
-		})
+		rules = append(rules, roleCasbinRule(domain, c))
 	}
 	return my.ops.enforcer.RemovePolicies(rules)
 }
 
+// find roles of user(grouping policy), filter by domain if model has domain
+func (my MySql) FindUserRoleCasbin(c ms.SysUserRoleCasbin) []ms.SysUserRoleCasbin {
+	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "FindUserRoleCasbin"))
+	defer span.End()
+	cs := make([]ms.SysUserRoleCasbin, 0)
+	if my.ops.enforcer == nil {
+		log.WithContext(my.Ctx).Warn("casbin enforcer is empty")
+		return cs
+	}
+	domain := my.ops.enforcer.HasDomain()
+	policies := my.ops.enforcer.GetFilteredGroupingPolicy(0, userRoleCasbinRule(domain, c)...)
+	for _, policy := range policies {
+		item := ms.SysUserRoleCasbin{
+			Username: policy[0],
+			Keyword:  policy[1],
+		}
+		if domain && len(policy) > 2 {
+			item.Domain = policy[2]
+		}
+		cs = append(cs, item)
+	}
+	return cs
+}
+
+// add roles to user, a user may have different roles in different domains
+func (my MySql) BatchCreateUserRoleCasbin(cs []ms.SysUserRoleCasbin) (bool, error) {
+	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "BatchCreateUserRoleCasbin"))
+	defer span.End()
+	if my.ops.enforcer == nil {
+		return false, errors.Errorf("casbin enforcer is empty")
+	}
+	domain := my.ops.enforcer.HasDomain()
+	rules := make([][]string, 0)
+	for _, c := range cs {
+		rules = append(rules, userRoleCasbinRule(domain, c))
+	}
+	return my.ops.enforcer.AddGroupingPolicies(rules)
+}
+
+func (my MySql) BatchDeleteUserRoleCasbin(cs []ms.SysUserRoleCasbin) (bool, error) {
+	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "BatchDeleteUserRoleCasbin"))
+	defer span.End()
+	if my.ops.enforcer == nil {
+		return false, errors.Errorf("casbin enforcer is empty")
+	}
+	domain := my.ops.enforcer.HasDomain()
+	rules := make([][]string, 0)
+	for _, c := range cs {
+		rules = append(rules, userRoleCasbinRule(domain, c))
+	}
+	return my.ops.enforcer.RemoveGroupingPolicies(rules)
+}
+
+// find path and method(V1, V2) of role, rules of all domains are merged if model has domain
 func FindCasbinByRoleKeyword(enforcer *casbin.Enforcer, roleKeyword string) ([]ms.SysCasbin, error) {
+	e, err := middleware.WrapEnforcer(enforcer)
+	if err != nil {
+		return make([]ms.SysCasbin, 0), err
+	}
+	return FindCasbinByRoleKeywordAndDomain(e, roleKeyword, "")
+}
+
+// find path and method(V1, V2) of role in domain
+func FindCasbinByRoleKeywordAndDomain(enforcer *middleware.Enforcer, roleKeyword, domain string) ([]ms.SysCasbin, error) {
 	casbins := make([]ms.SysCasbin, 0)
 	if enforcer == nil {
 		return casbins, errors.Errorf("casbin enforcer is empty")
 	}
-	list := make([][]string, 0)
-	if roleKeyword != "" {
-		// filter rules by keyword
-		list = enforcer.GetFilteredPolicy(0, roleKeyword)
-	} else {
-		list = enforcer.GetFilteredPolicy(0)
-	}
+	hasDomain := enforcer.HasDomain()
+	// filter rules by keyword and domain, empty field matches all
+	list := enforcer.GetFilteredPolicy(0, roleCasbinRule(hasDomain, ms.SysRoleCasbin{
+		Keyword: roleKeyword,
+		Domain:  domain,
+	})...)
 
 	var added []string
 	for _, v := range list {
-		if !utils.Contains(added, v[1]+v[2]) {
+		item := roleCasbin(hasDomain, v)
+		if !utils.Contains(added, item.Path+item.Method) {
 			casbins = append(casbins, ms.SysCasbin{
 				PType: "p",
-				V1:    v[1],
-				V2:    v[2],
+				V1:    item.Path,
+				V2:    item.Method,
 			})
-			added = append(added, v[1]+v[2])
+			added = append(added, item.Path+item.Method)
 		}
 	}
 	return casbins, nil
 }
+
+// policy fields: sub, obj, act or sub, dom, obj, act
+func roleCasbinRule(domain bool, c ms.SysRoleCasbin) []string {
+	if domain {
+		return []string{c.Keyword, c.Domain, c.Path, c.Method}
+	}
+	return []string{c.Keyword, c.Path, c.Method}
+}
+
+func roleCasbin(domain bool, policy []string) (c ms.SysRoleCasbin) {
+	if domain && len(policy) > 3 {
+		c.Keyword, c.Domain, c.Path, c.Method = policy[0], policy[1], policy[2], policy[3]
+		return
+	}
+	if len(policy) > 2 {
+		c.Keyword, c.Path, c.Method = policy[0], policy[1], policy[2]
+	}
+	return
+}
+
+// grouping policy fields: user, role or user, role, dom
+func userRoleCasbinRule(domain bool, c ms.SysUserRoleCasbin) []string {
+	if domain {
+		return []string{c.Username, c.Keyword, c.Domain}
+	}
+	return []string{c.Username, c.Keyword}
+}
@@ -53,6 +53,8 @@ func (my MySql) FsmApproveLog(r req.FsmApproveLog) (*resp.FsmApprovalLog, error)
 		fsm.WithCtx(my.Ctx),
 		fsm.WithDb(my.Tx),
 		fsm.WithTransition(my.ops.fsmTransition),
+		fsm.WithQueue(my.ops.fsmQueue),
+		fsm.WithHooks(my.ops.fsmHooks),
 	)
 	return f.ApproveLog(r)
 }
@@ -65,6 +67,7 @@ func (my MySql) FsmCancelLogByUuids(r req.FsmCancelLog) error {
 		fsm.WithCtx(my.Ctx),
 		fsm.WithDb(my.Tx),
 		fsm.WithTransition(my.ops.fsmTransition),
+		fsm.WithHooks(my.ops.fsmHooks),
 	)
 	return f.CancelLogByUuids(r)
 }
@@ -100,6 +103,8 @@ func (my MySql) UpdateFsmById(id uint, r req.FsmUpdateMachine) error {
 		fsm.WithCtx(my.Ctx),
 		fsm.WithDb(my.Tx),
 		fsm.WithTransition(my.ops.fsmTransition),
+		fsm.WithQueue(my.ops.fsmQueue),
+		fsm.WithHooks(my.ops.fsmHooks),
 	)
 	_, err := f.UpdateMachineById(id, r)
 	return err
@@ -115,3 +120,97 @@ func (my MySql) DeleteFsmByIds(ids []uint) error {
 	)
 	return f.DeleteMachineByIds(ids)
 }
+
+// transfer finite state machine log to other approvers
+func (my MySql) FsmTransferLog(r req.FsmTransferLog) error {
+	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "FsmTransferLog"))
+	defer span.End()
+	f := fsm.New(
+		fsm.WithCtx(my.Ctx),
+		fsm.WithDb(my.Tx),
+		fsm.WithTransition(my.ops.fsmTransition),
+		fsm.WithQueue(my.ops.fsmQueue),
+		fsm.WithHooks(my.ops.fsmHooks),
+	)
+	return f.TransferLog(r)
+}
+
+// find finite state machine delegation
+func (my MySql) FindFsmDelegation(r *req.FsmDelegation) ([]resp.FsmDelegation, error) {
+	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "FindFsmDelegation"))
+	defer span.End()
+	f := fsm.New(
+		fsm.WithCtx(my.Ctx),
+		fsm.WithDb(my.Tx),
+	)
+	return f.FindDelegation(r)
+}
+
+// create finite state machine delegation
+func (my MySql) CreateFsmDelegation(r req.FsmCreateDelegation) error {
+	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "CreateFsmDelegation"))
+	defer span.End()
+	f := fsm.New(
+		fsm.WithCtx(my.Ctx),
+		fsm.WithDb(my.Tx),
+	)
+	return f.CreateDelegation(r)
+}
+
+// delete finite state machine delegation of user
+func (my MySql) DeleteFsmDelegationByIds(userId uint, ids []uint) error {
+	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "DeleteFsmDelegationByIds"))
+	defer span.End()
+	f := fsm.New(
+		fsm.WithCtx(my.Ctx),
+		fsm.WithDb(my.Tx),
+	)
+	return f.DeleteDelegationByIds(userId, ids)
+}
+
+// find finite state machine versions
+func (my MySql) FindFsmVersion(r *req.FsmMachineVersion) ([]resp.FsmMachineVersion, error) {
+	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "FindFsmVersion"))
+	defer span.End()
+	f := fsm.New(
+		fsm.WithCtx(my.Ctx),
+		fsm.WithDb(my.Tx),
+	)
+	return f.FindMachineVersion(r)
+}
+
+// diff finite state machine versions
+func (my MySql) DiffFsmVersion(r req.FsmDiffMachineVersion) (*resp.FsmMachineVersionDiff, error) {
+	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "DiffFsmVersion"))
+	defer span.End()
+	f := fsm.New(
+		fsm.WithCtx(my.Ctx),
+		fsm.WithDb(my.Tx),
+	)
+	return f.DiffMachineVersion(r)
+}
+
+// migrate finite state machine pending logs to the newest version
+func (my MySql) FsmMigrateLog(r req.FsmMigrateLog) (*resp.FsmMigrateLog, error) {
+	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "FsmMigrateLog"))
+	defer span.End()
+	f := fsm.New(
+		fsm.WithCtx(my.Ctx),
+		fsm.WithDb(my.Tx),
+		fsm.WithTransition(my.ops.fsmTransition),
+		fsm.WithQueue(my.ops.fsmQueue),
+		fsm.WithHooks(my.ops.fsmHooks),
+	)
+	return f.MigrateLog(r)
+}
+
+// render finite state machine diagram
+func (my MySql) GetFsmDiagram(r req.FsmDiagram) (*resp.FsmDiagram, error) {
+	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "GetFsmDiagram"))
+	defer span.End()
+	f := fsm.New(
+		fsm.WithCtx(my.Ctx),
+		fsm.WithDb(my.Tx),
+	)
+	return f.Diagram(r)
+}
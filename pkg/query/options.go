@@ -2,11 +2,14 @@ package query
 
 import (
 	"context"
+	"fmt"
 	"github.com/casbin/casbin/v2"
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
 	"github.com/piupuer/go-helper/ms"
 	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/delay"
+	"github.com/piupuer/go-helper/pkg/fsm"
 	"github.com/piupuer/go-helper/pkg/middleware"
 	"github.com/piupuer/go-helper/pkg/resp"
 	"github.com/piupuer/go-helper/pkg/utils"
@@ -19,8 +22,10 @@ type MysqlOptions struct {
 	db            *gorm.DB
 	redis         redis.UniversalClient
 	cachePrefix   string
-	enforcer      *casbin.Enforcer
+	enforcer      *middleware.Enforcer
 	fsmTransition func(ctx context.Context, logs ...resp.FsmApprovalLog) error
+	fsmQueue      *delay.Queue
+	fsmHooks      *fsm.Hooks
 }
 
 func WithMysqlDb(db *gorm.DB) func(*MysqlOptions) {
@@ -53,7 +58,20 @@ func WithMysqlCtx(ctx context.Context) func(*MysqlOptions) {
 	}
 }
 
+// casbin.Enforcer is wrapped by middleware.WrapEnforcer, pass the same enforcer to middleware.WithCasbinEnforcer to share cached decisions
 func WithMysqlCasbinEnforcer(enforcer *casbin.Enforcer) func(*MysqlOptions) {
+	return func(options *MysqlOptions) {
+		if enforcer != nil {
+			e, err := middleware.WrapEnforcer(enforcer)
+			if err != nil {
+				panic(fmt.Sprintf("wrap casbin enforcer failed: %v", err))
+			}
+			getMysqlOptionsOrSetDefault(options).enforcer = e
+		}
+	}
+}
+
+func WithMysqlCasbinCachedEnforcer(enforcer *middleware.Enforcer) func(*MysqlOptions) {
 	return func(options *MysqlOptions) {
 		if enforcer != nil {
 			getMysqlOptionsOrSetDefault(options).enforcer = enforcer
@@ -69,6 +87,22 @@ func WithMysqlFsmTransition(fun func(ctx context.Context, logs ...resp.FsmApprov
 	}
 }
 
+func WithMysqlFsmQueue(qu *delay.Queue) func(*MysqlOptions) {
+	return func(options *MysqlOptions) {
+		if qu != nil {
+			getMysqlOptionsOrSetDefault(options).fsmQueue = qu
+		}
+	}
+}
+
+func WithMysqlFsmHooks(hooks *fsm.Hooks) func(*MysqlOptions) {
+	return func(options *MysqlOptions) {
+		if hooks != nil {
+			getMysqlOptionsOrSetDefault(options).fsmHooks = hooks
+		}
+	}
+}
+
 func getMysqlOptionsOrSetDefault(options *MysqlOptions) *MysqlOptions {
 	if options == nil {
 		return &MysqlOptions{
@@ -129,7 +163,7 @@ type RedisOptions struct {
 	ctx            context.Context
 	redis          redis.UniversalClient
 	redisUri       string
-	enforcer       *casbin.Enforcer
+	enforcer       *middleware.Enforcer
 	database       string
 	namingStrategy schema.Namer
 }
@@ -156,7 +190,20 @@ func WithRedisCtx(ctx context.Context) func(*RedisOptions) {
 	}
 }
 
+// refer to WithMysqlCasbinEnforcer
 func WithRedisCasbinEnforcer(enforcer *casbin.Enforcer) func(*RedisOptions) {
+	return func(options *RedisOptions) {
+		if enforcer != nil {
+			e, err := middleware.WrapEnforcer(enforcer)
+			if err != nil {
+				panic(fmt.Sprintf("wrap casbin enforcer failed: %v", err))
+			}
+			getRedisOptionsOrSetDefault(options).enforcer = e
+		}
+	}
+}
+
+func WithRedisCasbinCachedEnforcer(enforcer *middleware.Enforcer) func(*RedisOptions) {
 	return func(options *RedisOptions) {
 		if enforcer != nil {
 			getRedisOptionsOrSetDefault(options).enforcer = enforcer
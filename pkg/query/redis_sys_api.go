@@ -45,9 +45,9 @@ func (rd Redis) FindApiGroupByCategoryByRoleKeyword(currentRoleKeyword, roleKeyw
 		Table("sys_api").
 		Find(&allApi)
 	// find all casbin by current user's role id
-	currentCasbins, err := FindCasbinByRoleKeyword(rd.ops.enforcer, currentRoleKeyword)
+	currentCasbins, err := FindCasbinByRoleKeywordAndDomain(rd.ops.enforcer, currentRoleKeyword, "")
 	// find all casbin by current role id
-	casbins, err := FindCasbinByRoleKeyword(rd.ops.enforcer, roleKeyword)
+	casbins, err := FindCasbinByRoleKeywordAndDomain(rd.ops.enforcer, roleKeyword, "")
 	if err != nil {
 		return tree, accessIds, errors.WithStack(err)
 	}
@@ -2,6 +2,7 @@ package req
 
 import (
 	"github.com/piupuer/go-helper/pkg/utils"
+	"strings"
 )
 
 type Ids struct {
@@ -20,6 +21,17 @@ func (id Ids) Int64s() []int64 {
 	return utils.Str2Int64Arr(id.Ids)
 }
 
+func (id Ids) Strs() []string {
+	arr := make([]string, 0)
+	for _, v := range strings.Split(id.Ids, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			arr = append(arr, v)
+		}
+	}
+	return arr
+}
+
 type IdsStr string
 
 func (s IdsStr) Uints() []uint {
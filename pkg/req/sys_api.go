@@ -17,6 +17,7 @@ type CreateApi struct {
 	Title        string   `json:"title"`
 	RoleIds      []uint   `json:"roleIds"`
 	RoleKeywords []string `json:"roleKeywords"`
+	Domain       string   `json:"domain"` // tenant of role casbin rules, only used by model with domain
 }
 
 func (s CreateApi) FieldTrans() map[string]string {
@@ -35,3 +36,9 @@ type UpdateApi struct {
 	Title    *string `json:"title"`
 	RoleIds  []uint  `json:"roleIds"`
 }
+
+type UpdateApiIncrementalIds struct {
+	Create []uint `json:"create"`
+	Delete []uint `json:"delete"`
+	Domain string `json:"domain"` // tenant of role casbin rules, only used by model with domain
+}
@@ -8,3 +8,24 @@ type DelayExportHistory struct {
 	End      *NullUint `json:"end" form:"end"`
 	resp.Page
 }
+
+type DelayCron struct {
+	Uid  string `json:"uid" form:"uid"`
+	Name string `json:"name" form:"name"`
+	resp.Page
+}
+
+type UpdateDelayCron struct {
+	Name     *string `json:"name"`
+	Payload  *string `json:"payload"`
+	Expr     *string `json:"expr"`
+	Timezone *string `json:"timezone"`
+	Jitter   *int    `json:"jitter"`
+	Misfire  *uint   `json:"misfire"`
+}
+
+type DelayHistory struct {
+	Uid  string `json:"uid" form:"uid"`
+	Name string `json:"name" form:"name"`
+	resp.Page
+}
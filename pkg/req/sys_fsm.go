@@ -1,6 +1,9 @@
 package req
 
-import "github.com/piupuer/go-helper/pkg/resp"
+import (
+	"github.com/golang-module/carbon/v2"
+	"github.com/piupuer/go-helper/pkg/resp"
+)
 
 type FsmCreateMachine struct {
 	Category                   NullUint         `json:"category"`
@@ -13,11 +16,29 @@ type FsmCreateMachine struct {
 }
 
 type FsmCreateEvent struct {
-	Name       string   `json:"name" form:"name"`
-	Edit       NullUint `json:"edit" form:"edit"`
-	EditFields string   `json:"editFields" form:"editFields"`
-	Roles      IdsStr   `json:"roles" form:"roles"`
-	Users      IdsStr   `json:"users" form:"users"`
+	Name          string            `json:"name" form:"name"`
+	Edit          NullUint          `json:"edit" form:"edit"`
+	EditFields    string            `json:"editFields" form:"editFields"`
+	Roles         IdsStr            `json:"roles" form:"roles"`
+	Users         IdsStr            `json:"users" form:"users"`
+	Condition     string            `json:"condition" form:"condition"`
+	Join          NullUint          `json:"join" form:"join"`
+	Branches      []FsmCreateBranch `json:"branches" form:"branches"`
+	SignMode      NullUint          `json:"signMode" form:"signMode"`
+	Quorum        NullUint          `json:"quorum" form:"quorum"`
+	RefuseMode    NullUint          `json:"refuseMode" form:"refuseMode"`
+	Timeout       NullUint          `json:"timeout" form:"timeout"`
+	Remind        NullUint          `json:"remind" form:"remind"`
+	ExpireAction  NullUint          `json:"expireAction" form:"expireAction"`
+	EscalateRoles IdsStr            `json:"escalateRoles" form:"escalateRoles"`
+	EscalateUsers IdsStr            `json:"escalateUsers" form:"escalateUsers"`
+}
+
+type FsmCreateBranch struct {
+	Name      string `json:"name" form:"name"`
+	Condition string `json:"condition" form:"condition"`
+	Roles     IdsStr `json:"roles" form:"roles"`
+	Users     IdsStr `json:"users" form:"users"`
 }
 
 type FsmUpdateMachine struct {
@@ -37,10 +58,11 @@ type FsmCancelLog struct {
 }
 
 type FsmCreateLog struct {
-	Category        NullUint `json:"category" form:"category"`
-	Uuid            string   `json:"uuid" form:"uuid"`
-	SubmitterRoleId uint     `json:"submitterRoleId" form:"submitterRoleId"`
-	SubmitterUserId uint     `json:"submitterUserId" form:"submitterUserId"`
+	Category        NullUint               `json:"category" form:"category"`
+	Uuid            string                 `json:"uuid" form:"uuid"`
+	SubmitterRoleId uint                   `json:"submitterRoleId" form:"submitterRoleId"`
+	SubmitterUserId uint                   `json:"submitterUserId" form:"submitterUserId"`
+	Params          map[string]interface{} `json:"params"`
 }
 
 type FsmApproveLog struct {
@@ -116,3 +138,57 @@ type FsmMachine struct {
 	SubmitterConfirm *NullUint `json:"submitterConfirm" form:"submitterConfirm"`
 	resp.Page
 }
+
+type FsmTransferLog struct {
+	Category        NullUint `json:"category" form:"category"`
+	Uuid            string   `json:"uuid" form:"uuid"`
+	ApprovalRoleId  uint     `json:"approvalRoleId"`
+	ApprovalUserId  uint     `json:"approvalUserId"`
+	ApprovalOpinion string   `json:"approvalOpinion" form:"approvalOpinion"`
+	Roles           IdsStr   `json:"roles" form:"roles"`
+	Users           IdsStr   `json:"users" form:"users"`
+}
+
+type FsmCreateDelegation struct {
+	Category       NullUint        `json:"category"`
+	UserId         uint            `json:"userId"` // delegator, it is always current user in api
+	DelegateUserId NullUint        `json:"delegateUserId" validate:"required"`
+	StartAt        carbon.DateTime `json:"startAt" swaggertype:"string"`
+	EndAt          carbon.DateTime `json:"endAt" swaggertype:"string"`
+}
+
+func (s FsmCreateDelegation) FieldTrans() map[string]string {
+	m := make(map[string]string, 0)
+	m["DelegateUserId"] = "delegate user id"
+	return m
+}
+
+type FsmDelegation struct {
+	Category       *NullUint `json:"category" form:"category"`
+	UserId         uint      `json:"userId" form:"userId"`
+	DelegateUserId *NullUint `json:"delegateUserId" form:"delegateUserId"`
+	resp.Page
+}
+
+type FsmMachineVersion struct {
+	MachineId uint `json:"machineId" form:"machineId"`
+	resp.Page
+}
+
+type FsmDiffMachineVersion struct {
+	MachineId uint     `json:"machineId" form:"machineId"`
+	From      NullUint `json:"from" form:"from"`
+	To        NullUint `json:"to" form:"to"`
+}
+
+type FsmMigrateLog struct {
+	Category NullUint `json:"category" form:"category"`
+	Uuids    []string `json:"uuids"`
+}
+
+type FsmDiagram struct {
+	Category NullUint `json:"category" form:"category"`
+	Version  NullUint `json:"version" form:"version"`
+	Uuid     string   `json:"uuid" form:"uuid"`
+	Format   string   `json:"format" form:"format"` // dot/mermaid(default)
+}
@@ -0,0 +1,17 @@
+package req
+
+type MqDeadLetter struct {
+	Exchange string   `json:"exchange" form:"exchange" validate:"required"`
+	Queue    string   `json:"queue" form:"queue" validate:"required"`
+	Size     int      `json:"size" form:"size"`
+	Scan     int      `json:"scan" form:"scan"`
+	Headers  []string `json:"headers" form:"headers"` // header filter, key:value
+	Origin   bool     `json:"origin" form:"origin"`   // republish to origin exchange and routing key
+}
+
+func (s MqDeadLetter) FieldTrans() map[string]string {
+	m := make(map[string]string, 0)
+	m["Exchange"] = "exchange"
+	m["Queue"] = "queue"
+	return m
+}
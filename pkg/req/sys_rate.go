@@ -0,0 +1,34 @@
+package req
+
+type RateRule struct {
+	Path   string `json:"path" form:"path"`
+	Method string `json:"method" form:"method"`
+	Key    string `json:"key" form:"key"`
+}
+
+type CreateRateRule struct {
+	Path      string `json:"path"`                          // gin route pattern, prefix match if it ends with *, empty matches all routes
+	Method    string `json:"method"`                        // empty matches all methods
+	Key       string `json:"key" validate:"required"`       // ip/user/app
+	Algorithm string `json:"algorithm" validate:"required"` // token/window
+	Limit     int64  `json:"limit" validate:"required"`
+	Period    int64  `json:"period" validate:"required"` // seconds
+}
+
+func (s CreateRateRule) FieldTrans() map[string]string {
+	m := make(map[string]string, 0)
+	m["Key"] = "limit key"
+	m["Algorithm"] = "algorithm"
+	m["Limit"] = "limit"
+	m["Period"] = "period"
+	return m
+}
+
+type UpdateRateRule struct {
+	Path      *string `json:"path"`
+	Method    *string `json:"method"`
+	Key       *string `json:"key"`
+	Algorithm *string `json:"algorithm"`
+	Limit     *int64  `json:"limit"`
+	Period    *int64  `json:"period"`
+}
@@ -21,6 +21,8 @@ const (
 	InternalServerErrorMsg     = "server internal error"
 	IdempotenceTokenEmptyMsg   = "idempotent token is empty"
 	IdempotenceTokenInvalidMsg = "idempotent token expired"
+	IdempotenceKeyConflictMsg  = "request with the same idempotency key is in progress"
+	IdempotenceKeyMismatchMsg  = "idempotency key is used by another request"
 	UserDisabledMsg            = "the account has been disabled"
 	WeakPassword               = "the password is too weak"
 	UserLockedMsg              = "the account has been locked"
@@ -31,6 +33,8 @@ const (
 	IllegalSignTokenMsg        = "illegal token"
 	InvalidSignTimestampMsg    = "invalid timestamp"
 	InvalidSignScopeMsg        = "invalid scope"
+	InvalidSignNonceMsg        = "invalid nonce"
+	RepeatedSignNonceMsg       = "the nonce has been used"
 )
 
 var CustomError = map[int]string{
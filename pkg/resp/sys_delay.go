@@ -1,5 +1,7 @@
 package resp
 
+import "github.com/golang-module/carbon/v2"
+
 type DelayExportHistory struct {
 	Base
 	Uuid     string `json:"uuid"`
@@ -9,3 +11,42 @@ type DelayExportHistory struct {
 	End      uint   `json:"end"`
 	Url      string `json:"url"`
 }
+
+type DelayCron struct {
+	Uid       string          `json:"uid"`
+	Name      string          `json:"name"`
+	Expr      string          `json:"expr"`
+	Timezone  string          `json:"timezone"`
+	Jitter    int             `json:"jitter"`
+	Misfire   uint            `json:"misfire"`
+	Payload   string          `json:"payload"`
+	Queue     string          `json:"queue"`
+	Next      carbon.DateTime `json:"next" swaggertype:"string" example:"2019-01-01 00:00:00"` // next run time, empty if paused
+	Processed int64           `json:"processed"`
+	Paused    bool            `json:"paused"`
+}
+
+type DelayCallbackAttempt struct {
+	Uid        string          `json:"uid"`
+	Name       string          `json:"name"`
+	Url        string          `json:"url"`
+	Body       string          `json:"body"`
+	Attempt    int             `json:"attempt"`
+	Replay     bool            `json:"replay"`
+	StatusCode int             `json:"statusCode"`
+	Response   string          `json:"response"`
+	Error      string          `json:"error"`
+	StartAt    carbon.DateTime `json:"startAt" swaggertype:"string" example:"2019-01-01 00:00:00"`
+	Duration   int64           `json:"duration"` // milliseconds
+}
+
+type DelayHistory struct {
+	Uid      string          `json:"uid"`
+	Name     string          `json:"name"`
+	Attempt  int             `json:"attempt"`
+	StartAt  carbon.DateTime `json:"startAt" swaggertype:"string" example:"2019-01-01 00:00:00"`
+	EndAt    carbon.DateTime `json:"endAt" swaggertype:"string" example:"2019-01-01 00:00:00"`
+	Duration int64           `json:"duration"` // milliseconds
+	Error    string          `json:"error"`
+	Result   string          `json:"result"`
+}
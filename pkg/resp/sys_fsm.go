@@ -1,5 +1,7 @@
 package resp
 
+import "github.com/golang-module/carbon/v2"
+
 type FsmApprovalLog struct {
 	Uuid     string `json:"uuid"`
 	Category uint   `json:"category"`
@@ -27,13 +29,37 @@ type FsmApprovingLog struct {
 
 type FsmLogTrack struct {
 	Time
-	Name     string `json:"name"`
-	Opinion  string `json:"opinion"`
-	Status   uint   `json:"status"`
-	End      uint   `json:"end"`
-	Cancel   uint   `json:"cancel"`
-	Resubmit uint   `json:"resubmit"`
-	Confirm  uint   `json:"confirm"`
+	Name           string         `json:"name"`
+	Opinion        string         `json:"opinion"`
+	Status         uint           `json:"status"`
+	End            uint           `json:"end"`
+	Cancel         uint           `json:"cancel"`
+	Resubmit       uint           `json:"resubmit"`
+	Confirm        uint           `json:"confirm"`
+	Branches       []FsmLogBranch `json:"branches"`
+	Votes          []FsmLogVote   `json:"votes"`
+	ApprovalRoleId uint           `json:"approvalRoleId"`
+	ApprovalUserId uint           `json:"approvalUserId"`
+
+	Reminded   uint            `json:"reminded"` // remind times of approvers
+	RemindedAt carbon.DateTime `json:"remindedAt" swaggertype:"string" example:"2019-01-01 00:00:00"`
+}
+
+type FsmLogVote struct {
+	RoleId         uint   `json:"roleId"`
+	UserId         uint   `json:"userId"`
+	Status         uint   `json:"status"`
+	Opinion        string `json:"opinion"`
+	ApprovalRoleId uint   `json:"approvalRoleId"`
+	ApprovalUserId uint   `json:"approvalUserId"`
+}
+
+type FsmLogBranch struct {
+	Name           string `json:"name"`
+	Status         uint   `json:"status"`
+	Opinion        string `json:"opinion"`
+	ApprovalRoleId uint   `json:"approvalRoleId"`
+	ApprovalUserId uint   `json:"approvalUserId"`
 }
 
 type FsmSubmitterDetail struct {
@@ -51,4 +77,51 @@ type FsmMachine struct {
 	SubmitterConfirm           uint   `json:"submitterConfirm"`
 	SubmitterConfirmEditFields string `json:"submitterConfirmEditFields"`
 	EventsJson                 string `json:"eventsJson"`
+	Version                    uint   `json:"version"`
+}
+
+type FsmDelegation struct {
+	Base
+	Category       uint            `json:"category"`
+	UserId         uint            `json:"userId"`
+	DelegateUserId uint            `json:"delegateUserId"`
+	StartAt        carbon.DateTime `json:"startAt" swaggertype:"string" example:"2019-01-01 00:00:00"`
+	EndAt          carbon.DateTime `json:"endAt" swaggertype:"string" example:"2019-01-01 00:00:00"`
+}
+
+type FsmMachineVersion struct {
+	Base
+	MachineId                  uint   `json:"machineId"`
+	Version                    uint   `json:"version"`
+	Name                       string `json:"name"`
+	SubmitterName              string `json:"submitterName"`
+	SubmitterEditFields        string `json:"submitterEditFields"`
+	SubmitterConfirm           uint   `json:"submitterConfirm"`
+	SubmitterConfirmEditFields string `json:"submitterConfirmEditFields"`
+	EventsJson                 string `json:"eventsJson"`
+}
+
+type FsmMachineVersionDiff struct {
+	From   uint                 `json:"from"`
+	To     uint                 `json:"to"`
+	Fields []FsmVersionDiffItem `json:"fields"`
+	Levels []FsmVersionDiffItem `json:"levels"`
+}
+
+type FsmVersionDiffItem struct {
+	Name   string `json:"name"`
+	Action string `json:"action"` // add/remove/change
+	Old    string `json:"old"`
+	New    string `json:"new"`
+}
+
+type FsmMigrateLog struct {
+	Migrated  []string `json:"migrated"`  // migrated log uuids
+	Cancelled []string `json:"cancelled"` // cancelled log uuids(position does not exist in the newest version)
+}
+
+type FsmDiagram struct {
+	Format  string `json:"format"`
+	Version uint   `json:"version"`
+	Content string `json:"content"`
 }
@@ -0,0 +1,15 @@
+package resp
+
+import "github.com/golang-module/carbon/v2"
+
+type MqDeadLetter struct {
+	Body        string                 `json:"body"`
+	ContentType string                 `json:"contentType"`
+	Headers     map[string]interface{} `json:"headers"`
+	Exchange    string                 `json:"exchange"`
+	RoutingKey  string                 `json:"routingKey"`
+	Queue       string                 `json:"queue"`
+	Reason      string                 `json:"reason"`
+	Count       int64                  `json:"count"`
+	Time        carbon.DateTime        `json:"time" swaggertype:"string" example:"2019-01-01 00:00:00"`
+}
@@ -0,0 +1,11 @@
+package resp
+
+type RateRule struct {
+	Id        string `json:"id"`
+	Path      string `json:"path"`
+	Method    string `json:"method"`
+	Key       string `json:"key"`
+	Algorithm string `json:"algorithm"`
+	Limit     int64  `json:"limit"`
+	Period    int64  `json:"period"`
+}
@@ -5,4 +5,5 @@ const (
 	Middleware = "Middleware"
 	Cache      = "Cache"
 	Db         = "Db"
+	Mq         = "Mq"
 )
@@ -33,6 +33,9 @@ func Ed25519Sign(msg, hexPri string) (hexSignature string) {
 func Ed25519Verify(msg, hexSignature, hexPub string) (pass bool) {
 	publicKey, _ := hex.DecodeString(hexPub)
 	signature, _ := hex.DecodeString(hexSignature)
+	if len(publicKey) != ed25519.PublicKeySize {
+		return
+	}
 	pass = ed25519.Verify(publicKey, []byte(msg), signature)
 	return
 }
@@ -108,6 +111,9 @@ func RSAVerify(data, base64Signature, publicBytes []byte) (flag bool) {
 	signature := []byte(DecodeStrFromBase64(string(base64Signature)))
 	sum := loadSha256Sum(data)
 	pubKey := loadRsaPubKey(publicBytes)
+	if pubKey.N == nil {
+		return
+	}
 	err := rsa.VerifyPSS(pubKey, crypto.SHA256, sum, signature, &rsa.PSSOptions{
 		SaltLength: rsa.PSSSaltLengthEqualsHash,
 		Hash:       crypto.SHA256,
@@ -143,7 +149,9 @@ func loadRsaPubKey(publicBytes []byte) (publicKey *rsa.PublicKey) {
 	if err != nil {
 		return
 	}
-	publicKey = keyInit.(*rsa.PublicKey)
+	if key, ok := keyInit.(*rsa.PublicKey); ok {
+		publicKey = key
+	}
 	return
 }
 
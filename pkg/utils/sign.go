@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"github.com/golang-module/carbon/v2"
+	"github.com/google/uuid"
+	"github.com/piupuer/go-helper/pkg/constant"
+	"strings"
+)
+
+// SignToken generate hmac token of default header keys which can be verified by middleware.Sign(nonce is required if WithSignRedis), uri contains query string
+func SignToken(appId, secret, method, uri, body string) string {
+	// hmac never fails
+	token, _ := SignTokenWithNonce(appId, constant.MiddlewareSignTypeHmac, secret, method, uri, body)
+	return token
+}
+
+// SignTokenWithNonce generate token with random nonce, key is secret of hmac, hex private key of ed25519 or pem private key of rsa
+func SignTokenWithNonce(appId, signType, key, method, uri, body string) (token string, err error) {
+	timestamp := fmt.Sprintf("%d", carbon.Now().Timestamp())
+	nonce := strings.ReplaceAll(uuid.NewString(), "-", "")
+	content := SignContent(method, uri, timestamp, nonce, body)
+	var signature string
+	switch signType {
+	case constant.MiddlewareSignTypeEd25519:
+		signature = Ed25519Sign(content, key)
+	case constant.MiddlewareSignTypeRsa:
+		var b []byte
+		b, err = RSASign([]byte(content), []byte(key))
+		if err != nil {
+			return
+		}
+		signature = string(b)
+	default:
+		signature = SignHmac(key, content)
+	}
+	token = fmt.Sprintf(
+		`%s="%s",%s="%s",%s="%s",%s="%s"`,
+		constant.MiddlewareSignAppIdHeaderKey, appId,
+		constant.MiddlewareSignTimestampHeaderKey, timestamp,
+		constant.MiddlewareSignNonceHeaderKey, nonce,
+		constant.MiddlewareSignSignatureHeaderKey, signature,
+	)
+	return
+}
+
+// SignContent method|uri|timestamp(|nonce)|body, nonce is omitted if it is empty
+func SignContent(method, uri, timestamp, nonce, body string) string {
+	b := bytes.NewBuffer(nil)
+	b.WriteString(method)
+	b.WriteString(constant.MiddlewareSignSeparator)
+	b.WriteString(uri)
+	b.WriteString(constant.MiddlewareSignSeparator)
+	b.WriteString(timestamp)
+	b.WriteString(constant.MiddlewareSignSeparator)
+	if nonce != "" {
+		b.WriteString(nonce)
+		b.WriteString(constant.MiddlewareSignSeparator)
+	}
+	b.WriteString(JsonWithSort(body))
+	return b.String()
+}
+
+// SignHmac base64 of hmac-sha256
+func SignHmac(secret, content string) string {
+	hash := hmac.New(sha256.New, []byte(secret))
+	hash.Write([]byte(content))
+	return base64.StdEncoding.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestSignContent(t *testing.T) {
+	cases := []struct {
+		nonce   string
+		content string
+	}{
+		// empty nonce is compatible with signature before nonce is supported
+		{"", `POST|/api/v1/user?page=1|1640995200|{"a":1,"b":"x"}`},
+		{"0f2c6a", `POST|/api/v1/user?page=1|1640995200|0f2c6a|{"a":1,"b":"x"}`},
+	}
+	for _, item := range cases {
+		content := SignContent("POST", "/api/v1/user?page=1", "1640995200", item.nonce, `{"b":"x","a":1}`)
+		if content != item.content {
+			t.Errorf("SignContent(nonce=%q) = %s, want %s", item.nonce, content, item.content)
+		}
+	}
+}
+
+func TestSignHmac(t *testing.T) {
+	cases := []struct {
+		nonce     string
+		signature string
+	}{
+		{"", "VJqNZGH/UtMkuFWD957UAFyd4pU4tC8N8TDwOAjY/1E="},
+		{"0f2c6a", "U45KV3OVu91Ea7SxsX5AuHoKKiHfVoRijWXyl0KstXs="},
+	}
+	for _, item := range cases {
+		signature := SignHmac("secret", SignContent("POST", "/api/v1/user?page=1", "1640995200", item.nonce, `{"b":"x","a":1}`))
+		if signature != item.signature {
+			t.Errorf("SignHmac(nonce=%q) = %s, want %s", item.nonce, signature, item.signature)
+		}
+	}
+}
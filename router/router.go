@@ -22,14 +22,14 @@ func NewRouter(options ...func(*Options)) *Router {
 	// router auto transmit children
 	if ops.casbin {
 		cabinOps := middleware.ParseCasbinOptions(ops.casbinOps...)
-		if cabinOps.Enforcer != nil {
+		if cabinOps.CachedEnforcer != nil {
 			ops.v1Ops = append(
 				ops.v1Ops,
 				v1.WithDbOps(
-					query.WithMysqlCasbinEnforcer(cabinOps.Enforcer),
+					query.WithMysqlCasbinCachedEnforcer(cabinOps.CachedEnforcer),
 				),
 				v1.WithBinlogOps(
-					query.WithRedisCasbinEnforcer(cabinOps.Enforcer),
+					query.WithRedisCasbinCachedEnforcer(cabinOps.CachedEnforcer),
 				))
 		}
 	}
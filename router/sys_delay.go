@@ -7,3 +7,15 @@ func (rt Router) Delay() {
 	router1.GET("/export/list", v1.FindDelayExport(rt.ops.v1Ops...))
 	router1.DELETE("/export/delete/batch", v1.BatchDeleteDelayExportByIds(rt.ops.v1Ops...))
 }
+
+func (rt Router) DelayQueue() {
+	router1 := rt.Casbin("/delay")
+	router1.GET("/cron/list", v1.FindDelayCron(rt.ops.v1Ops...))
+	router1.GET("/cron/detail/:uid", v1.GetDelayCron(rt.ops.v1Ops...))
+	router1.PATCH("/cron/pause/:uid", v1.PauseDelayCron(rt.ops.v1Ops...))
+	router1.PATCH("/cron/resume/:uid", v1.ResumeDelayCron(rt.ops.v1Ops...))
+	router1.PATCH("/cron/update/:uid", v1.UpdateDelayCron(rt.ops.v1Ops...))
+	router1.GET("/callback/attempt/:uid", v1.FindDelayCallbackAttempt(rt.ops.v1Ops...))
+	router1.POST("/callback/replay/:uid", v1.ReplayDelayCallback(rt.ops.v1Ops...))
+	router1.GET("/history/list", v1.FindDelayHistory(rt.ops.v1Ops...))
+}
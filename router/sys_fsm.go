@@ -8,11 +8,19 @@ func (rt Router) Fsm() {
 	router1.GET("/list", v1.FindFsm(rt.ops.v1Ops...))
 	router2.POST("/create", v1.CreateFsm(rt.ops.v1Ops...))
 	router1.PATCH("/update/:id", v1.UpdateFsmById(rt.ops.v1Ops...))
+	router1.GET("/version/list", v1.FindFsmVersion(rt.ops.v1Ops...))
+	router1.GET("/version/diff", v1.DiffFsmVersion(rt.ops.v1Ops...))
 	router1.GET("/approving/list", v1.FindFsmApprovingLog(rt.ops.v1Ops...))
 	router1.GET("/log/track", v1.FindFsmLogTrack(rt.ops.v1Ops...))
+	router1.GET("/diagram", v1.GetFsmDiagram(rt.ops.v1Ops...))
 	router1.GET("/submitter/detail", v1.GetFsmSubmitterDetail(rt.ops.v1Ops...))
 	router1.PATCH("/submitter/detail", v1.UpdateFsmSubmitterDetail(rt.ops.v1Ops...))
 	router1.PATCH("/approve", v1.FsmApproveLog(rt.ops.v1Ops...))
 	router1.PATCH("/cancel", v1.FsmCancelLogByUuids(rt.ops.v1Ops...))
+	router1.PATCH("/log/migrate", v1.FsmMigrateLog(rt.ops.v1Ops...))
+	router1.PATCH("/transfer", v1.FsmTransferLog(rt.ops.v1Ops...))
+	router1.GET("/delegation/list", v1.FindFsmDelegation(rt.ops.v1Ops...))
+	router2.POST("/delegation/create", v1.CreateFsmDelegation(rt.ops.v1Ops...))
+	router1.DELETE("/delegation/delete/batch", v1.BatchDeleteFsmDelegationByIds(rt.ops.v1Ops...))
 	router1.DELETE("/delete/batch", v1.BatchDeleteFsmByIds(rt.ops.v1Ops...))
 }
@@ -0,0 +1,9 @@
+package router
+
+import v1 "github.com/piupuer/go-helper/api/v1"
+
+func (rt Router) Mq() {
+	router1 := rt.Casbin("/mq")
+	router1.GET("/deadLetter/list", v1.FindMqDeadLetter(rt.ops.v1Ops...))
+	router1.POST("/deadLetter/republish", v1.RepublishMqDeadLetter(rt.ops.v1Ops...))
+}
@@ -0,0 +1,11 @@
+package router
+
+import v1 "github.com/piupuer/go-helper/api/v1"
+
+func (rt Router) Rate() {
+	router1 := rt.Casbin("/rate")
+	router1.GET("/rule/list", v1.FindRateRule(rt.ops.v1Ops...))
+	router1.POST("/rule/create", v1.CreateRateRule(rt.ops.v1Ops...))
+	router1.PATCH("/rule/update/:id", v1.UpdateRateRuleById(rt.ops.v1Ops...))
+	router1.DELETE("/rule/delete/batch", v1.BatchDeleteRateRuleByIds(rt.ops.v1Ops...))
+}